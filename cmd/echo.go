@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/admin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var pingCmd = &cobra.Command{
+	Use:   "ping <nodeName>",
+	Short: "Send echo requests to a node over the mesh.",
+	Long: `Send echo requests to a node, routed over the mesh, and report round trip times and loss.
+
+Requests are sent by the node whose admin API is at --adminAddr. If no node is running there,
+or --ephemeral is given, an ephemeral node is started for the command, configured by the same
+flags as start-node.`,
+	Args:   cobra.ExactArgs(1),
+	PreRun: prepareEchoCommand,
+	RunE: func(c *cobra.Command, args []string) error {
+		count, _ := c.Flags().GetInt("count")
+		interval, _ := c.Flags().GetDuration("interval")
+		timeout, _ := c.Flags().GetDuration("timeout")
+		ttl, _ := c.Flags().GetInt("ttl")
+
+		client, stop, err := echoClient(c, args[0])
+		if err != nil {
+			return err
+		}
+		defer stop()
+
+		fmt.Printf("PING %s\n", args[0])
+		var rtts []float64
+		for seq := 1; seq <= count; seq++ {
+			if seq > 1 {
+				time.Sleep(interval)
+			}
+
+			echo, err := client.Ping(args[0], ttl, timeout)
+			switch {
+			case err == admin.ErrTimedOut:
+				fmt.Printf("seq=%d timed out\n", seq)
+			case err != nil:
+				return err
+			case !echo.Reached:
+				fmt.Printf("seq=%d time exceeded from %s, hops=%d\n", seq, echo.From, echo.Hops)
+			default:
+				fmt.Printf("seq=%d reply from %s, hops=%d, time=%.2f ms\n", seq, echo.From, echo.Hops, echo.RTT)
+				rtts = append(rtts, echo.RTT)
+			}
+		}
+
+		fmt.Printf("\n--- %s ping statistics ---\n", args[0])
+		fmt.Printf("%d sent, %d received, %.0f%% loss\n", count, len(rtts), float64(count-len(rtts))/float64(count)*100)
+		if len(rtts) > 0 {
+			min, max, sum := rtts[0], rtts[0], 0.0
+			for _, rtt := range rtts {
+				if rtt < min {
+					min = rtt
+				}
+				if rtt > max {
+					max = rtt
+				}
+				sum += rtt
+			}
+			fmt.Printf("rtt min/avg/max = %.2f/%.2f/%.2f ms\n", min, sum/float64(len(rtts)), max)
+		}
+
+		return nil
+	},
+}
+
+var tracerouteCmd = &cobra.Command{
+	Use:   "traceroute <nodeName>",
+	Short: "Show the path taken over the mesh to a node.",
+	Long: `Show the path taken over the mesh to a node, by sending echo requests with increasing TTLs
+and listing the nodes which answer that the TTL ran out. The path predicted by the routing table
+is shown for comparison.
+
+Requests are sent by the node whose admin API is at --adminAddr. If no node is running there,
+or --ephemeral is given, an ephemeral node is started for the command, configured by the same
+flags as start-node.`,
+	Args:   cobra.ExactArgs(1),
+	PreRun: prepareEchoCommand,
+	RunE: func(c *cobra.Command, args []string) error {
+		maxHops, _ := c.Flags().GetInt("maxHops")
+		timeout, _ := c.Flags().GetDuration("timeout")
+
+		client, stop, err := echoClient(c, args[0])
+		if err != nil {
+			return err
+		}
+		defer stop()
+
+		fmt.Printf("traceroute to %s, %d hops max\n", args[0], maxHops)
+		trace, err := client.Traceroute(args[0], maxHops, timeout)
+		if err != nil {
+			return err
+		}
+
+		path := []string{}
+		for i, hop := range trace.Hops {
+			if hop.From == "" {
+				fmt.Printf("%2d  *\n", i+1)
+				path = append(path, "*")
+				continue
+			}
+			fmt.Printf("%2d  %s  %.2f ms\n", i+1, hop.From, hop.RTT)
+			path = append(path, hop.From)
+		}
+
+		if len(trace.Predicted) == 0 {
+			fmt.Println("\nno route predicted by the routing table")
+			return nil
+		}
+
+		// the predicted path starts at the node sending the requests, which only answers if it's the target
+		predicted := trace.Predicted[1:]
+		if len(trace.Predicted) == 1 {
+			predicted = trace.Predicted
+		}
+		match := "matches"
+		if strings.Join(path, " ") != strings.Join(predicted, " ") {
+			match = "differs from"
+		}
+		fmt.Printf("\npath %s the routing table: %s\n", match, strings.Join(trace.Predicted, " > "))
+
+		return nil
+	},
+}
+
+func init() {
+	for _, c := range []*cobra.Command{pingCmd, tracerouteCmd} {
+		addNodeFlags(c)
+		c.Flags().Bool("ephemeral", false, "always start an ephemeral node, rather than using a running one")
+		c.Flags().Duration("wait", time.Second*30, "how long an ephemeral node waits for a route to the node")
+		c.Flags().Duration("timeout", time.Second, "how long to wait for each answer")
+		rootCmd.AddCommand(c)
+	}
+
+	pingCmd.Flags().IntP("count", "c", 4, "number of echo requests to send")
+	pingCmd.Flags().Duration("interval", time.Second, "time between echo requests")
+	pingCmd.Flags().Int("ttl", 16, "maximum number of hops of each echo request")
+	tracerouteCmd.Flags().Int("maxHops", 16, "maximum number of hops to trace")
+}
+
+func prepareEchoCommand(c *cobra.Command, args []string) {
+	bindNodeFlags(c)
+
+	// keep the output readable, unless asked for the ephemeral node's logs
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	if viper.GetBool("verbose") {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+
+	c.SilenceUsage = true
+	c.SilenceErrors = true
+}
+
+// echoClient returns a client for the admin API of the running node. If there isn't one, an ephemeral
+// node is started, and the client is for its admin API once it has a route to target.
+// The returned function stops the ephemeral node.
+func echoClient(c *cobra.Command, target string) (*admin.Client, func(), error) {
+	if useEphemeral, _ := c.Flags().GetBool("ephemeral"); !useEphemeral {
+		client := admin.NewClient(viper.GetString("adminAddr"))
+		if _, err := client.Identity(); err == nil {
+			return client, func() {}, nil
+		}
+		fmt.Fprintln(os.Stderr, "No node running, starting an ephemeral node")
+	}
+
+	nodeName := viper.GetString("nodeName")
+	if nodeName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, nil, err
+		}
+		nodeName = fmt.Sprintf("%s-%s-%d", hostname, c.Name(), os.Getpid())
+	}
+
+	i, err := newNode(context.Background(), nodeName)
+	if err != nil {
+		return nil, nil, err
+	}
+	i.StartAnnounce()
+
+	server := admin.NewServer(i)
+	addr, err := server.Start("127.0.0.1:0")
+	if err != nil {
+		closeNode(i)
+		return nil, nil, err
+	}
+	stop := func() {
+		server.Close()
+		closeNode(i)
+	}
+
+	wait, _ := c.Flags().GetDuration("wait")
+	deadline := time.Now().Add(wait)
+	for {
+		if _, ok := i.RoutingTable().NextHop(target); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return nil, nil, errors.New("no route to " + target + " after " + wait.String())
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	log.Debug().Str("nodeName", nodeName).Str("target", target).Msg("Ephemeral node has a route")
+
+	return admin.NewClient(addr), stop, nil
+}
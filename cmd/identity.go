@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Heanthor/rsec-net/pkg/net"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	identityKeyPEMType = "PRIVATE KEY"
+
+	// files in the config directory used by the keys commands, unless paths are given
+	configDirName       = ".rsec-net"
+	identityKeyFileName = "identity.key"
+	trustedKeysFileName = "trusted_keys"
+)
+
+var errNotTrusted = errors.New("node has no trusted key")
+
+// defaultKeyPath returns the path of a file in the config directory, $HOME/.rsec-net
+func defaultKeyPath(fileName string) string {
+	home, err := homedir.Dir()
+	if err != nil {
+		return filepath.Join(configDirName, fileName)
+	}
+
+	return filepath.Join(home, configDirName, fileName)
+}
+
+// loadIdentityKey reads the node's identity key from path, generating and saving a new one if it doesn't exist
+func loadIdentityKey(path string) (ed25519.PrivateKey, error) {
+	key, err := readIdentityKey(path)
+	if os.IsNotExist(err) {
+		log.Info().Str("path", path).Msg("Generating new identity key")
+		return generateIdentityKey(path)
+	}
+
+	return key, err
+}
+
+// readIdentityKey reads the node's identity key from path
+func readIdentityKey(path string) (ed25519.PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != identityKeyPEMType {
+		return nil, errors.New("identity key file is not a PEM encoded private key")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("identity key is not an Ed25519 key")
+	}
+
+	return edKey, nil
+}
+
+// generateIdentityKey generates an identity key, and saves it to path readable only by the current user
+func generateIdentityKey(path string) (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writePrivateFile(path, pem.EncodeToMemory(&pem.Block{Type: identityKeyPEMType, Bytes: der})); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// loadTrustedKeys reads a trusted key list. Each line holds a node name and its base64 encoded public key,
+// separated by whitespace. Blank lines and lines starting with # are ignored.
+func loadTrustedKeys(path string) (map[string]ed25519.PublicKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	trusted := make(map[string]ed25519.PublicKey)
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected node name and public key", path, i+1)
+		}
+
+		key, err := net.ParsePublicKey(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+		trusted[fields[0]] = key
+	}
+
+	return trusted, nil
+}
+
+// trustKey pins the node name to key in the trusted key list at path, replacing the key it was pinned to
+// if there was one. The list is created if it doesn't exist.
+func trustKey(path, nodeName string, key ed25519.PublicKey) error {
+	if nodeName == "" || strings.ContainsAny(nodeName, " \t\r\n") || strings.HasPrefix(nodeName, "#") {
+		return fmt.Errorf("invalid node name %q", nodeName)
+	}
+
+	return editTrustedKeys(path, func(lines []string) ([]string, error) {
+		entry := nodeName + " " + net.EncodePublicKey(key)
+		for i, line := range lines {
+			if trustedKeyName(line) == nodeName {
+				lines[i] = entry
+				return lines, nil
+			}
+		}
+
+		return append(lines, entry), nil
+	})
+}
+
+// revokeKey removes the node name from the trusted key list at path.
+// Returns errNotTrusted if it isn't in the list.
+func revokeKey(path, nodeName string) error {
+	return editTrustedKeys(path, func(lines []string) ([]string, error) {
+		kept := lines[:0]
+		for _, line := range lines {
+			if trustedKeyName(line) != nodeName {
+				kept = append(kept, line)
+			}
+		}
+		if len(kept) == len(lines) {
+			return nil, errNotTrusted
+		}
+
+		return kept, nil
+	})
+}
+
+// trustedKeyName returns the node name of a trusted key list line, or "" for blank lines and comments
+func trustedKeyName(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return ""
+	}
+
+	return fields[0]
+}
+
+// editTrustedKeys rewrites the lines of the trusted key list at path, keeping comments and blank lines
+// as they were. The new list replaces the old one atomically, readable only by the current user.
+func editTrustedKeys(path string, edit func(lines []string) ([]string, error)) error {
+	var lines []string
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		lines = strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+		if len(b) == 0 {
+			lines = nil
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	lines, err = edit(lines)
+	if err != nil {
+		return err
+	}
+
+	content := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		content += "\n"
+	}
+
+	return writePrivateFile(path, []byte(content))
+}
+
+// writePrivateFile replaces the file at path with one holding data, readable only by the current user.
+// The data is written to a temporary file which is renamed over the old one, so a file which was readable
+// by others is never left with the new data in it.
+func writePrivateFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	// created readable only by the current user
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Heanthor/rsec-net/pkg/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustAndRevokeKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rsec-net")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys", trustedKeysFileName)
+
+	k1, _, _ := ed25519.GenerateKey(rand.Reader)
+	k2, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	require.NoError(t, trustKey(path, "n1", k1))
+	require.NoError(t, trustKey(path, "n2", k1))
+	require.NoError(t, trustKey(path, "n1", k2))
+
+	trusted, err := loadTrustedKeys(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ed25519.PublicKey{"n1": k2, "n2": k1}, trusted)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	require.NoError(t, revokeKey(path, "n1"))
+	assert.Equal(t, errNotTrusted, revokeKey(path, "n1"))
+
+	trusted, err = loadTrustedKeys(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ed25519.PublicKey{"n2": k1}, trusted)
+
+	assert.Error(t, trustKey(path, "bad name", k1))
+}
+
+func TestTrustKey_KeepsComments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rsec-net")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, trustedKeysFileName)
+
+	k1, _, _ := ed25519.GenerateKey(rand.Reader)
+	k2, _, _ := ed25519.GenerateKey(rand.Reader)
+	initial := "# lab nodes\nn1 " + net.EncodePublicKey(k1) + "\n\n"
+	require.NoError(t, ioutil.WriteFile(path, []byte(initial), 0600))
+
+	require.NoError(t, trustKey(path, "n1", k2))
+
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "# lab nodes\nn1 "+net.EncodePublicKey(k2)+"\n\n", string(b))
+}
+
+func TestGenerateIdentityKey_Replaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rsec-net")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, identityKeyFileName)
+
+	// a key which was left readable by others
+	require.NoError(t, ioutil.WriteFile(path, []byte("old"), 0644))
+	require.NoError(t, os.Chmod(path, 0644))
+
+	key, err := generateIdentityKey(path)
+	require.NoError(t, err)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	read, err := readIdentityKey(path)
+	require.NoError(t, err)
+	assert.Equal(t, key, read)
+}
+
+func TestLoadNodeKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rsec-net")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keyPath := filepath.Join(dir, identityKeyFileName)
+	trustedPath := filepath.Join(dir, trustedKeysFileName)
+
+	k, _, _ := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, trustKey(trustedPath, "n1", k))
+
+	key, trusted, err := loadNodeKeys(keyPath, trustedPath)
+	require.NoError(t, err)
+	assert.NotNil(t, key)
+	assert.Equal(t, map[string]ed25519.PublicKey{"n1": k}, trusted)
+
+	// pins are never dropped
+	_, _, err = loadNodeKeys("", trustedPath)
+	assert.Error(t, err)
+	_, _, err = loadNodeKeys(keyPath, filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+
+	key, trusted, err = loadNodeKeys("", "")
+	require.NoError(t, err)
+	assert.Nil(t, key)
+	assert.Nil(t, trusted)
+}
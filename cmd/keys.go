@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/Heanthor/rsec-net/pkg/net"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage the node's identity key and trusted keys.",
+	Long: `Manage the node's identity key, and the list of node names pinned to trusted identity keys.
+
+By default, the identity key is kept in $HOME/.rsec-net/identity.key, and the trusted keys in
+$HOME/.rsec-net/trusted_keys, which start-node also uses by default. Pass other paths to both with
+--identityKey and --trustedKeys.`,
+	PersistentPreRun: func(c *cobra.Command, args []string) {
+		// errors are reported by Execute, and are about the files rather than the usage
+		c.SilenceUsage = true
+		c.SilenceErrors = true
+	},
+}
+
+var keysGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate a new identity key.",
+	Long:  `Generate a new identity key, readable only by the current user. Refuses to replace an existing key unless --force is given.`,
+	Args:  cobra.NoArgs,
+	RunE: func(c *cobra.Command, args []string) error {
+		path := keyFilePath(c, "identityKey")
+
+		if force, _ := c.Flags().GetBool("force"); !force {
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("identity key %s already exists, use --force to replace it", path)
+			}
+		}
+
+		key, err := generateIdentityKey(path)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Wrote identity key to %s\n", path)
+		printIdentity(key.Public().(ed25519.PublicKey))
+
+		return nil
+	},
+}
+
+var keysShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the node's public identity key and fingerprint.",
+	Args:  cobra.NoArgs,
+	RunE: func(c *cobra.Command, args []string) error {
+		key, err := readIdentityKey(keyFilePath(c, "identityKey"))
+		if err != nil {
+			return err
+		}
+
+		printIdentity(key.Public().(ed25519.PublicKey))
+
+		return nil
+	},
+}
+
+var keysTrustCmd = &cobra.Command{
+	Use:   "trust <nodeName> <publicKey>",
+	Short: "Pin a node name to its identity key.",
+	Long:  `Pin a node name to its identity key, as printed by "keys show" on the node. Replaces the key the name was pinned to, if any. Takes effect when the node is restarted.`,
+	Args:  cobra.ExactArgs(2),
+	RunE: func(c *cobra.Command, args []string) error {
+		key, err := net.ParsePublicKey(args[1])
+		if err != nil {
+			return err
+		}
+
+		path := keyFilePath(c, "trustedKeys")
+		if err := trustKey(path, args[0], key); err != nil {
+			return err
+		}
+
+		fmt.Printf("Trusted %s with key %s in %s\n", args[0], net.Fingerprint(key), path)
+
+		return nil
+	},
+}
+
+var keysRevokeCmd = &cobra.Command{
+	Use:   "revoke <nodeName>",
+	Short: "Remove a node name from the trusted keys.",
+	Long:  `Remove a node name from the trusted keys. Takes effect when the node is restarted.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(c *cobra.Command, args []string) error {
+		path := keyFilePath(c, "trustedKeys")
+		if err := revokeKey(path, args[0]); err != nil {
+			return fmt.Errorf("%s: %v", args[0], err)
+		}
+
+		fmt.Printf("Revoked %s in %s\n", args[0], path)
+
+		return nil
+	},
+}
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the trusted keys.",
+	Args:  cobra.NoArgs,
+	RunE: func(c *cobra.Command, args []string) error {
+		trusted, err := loadTrustedKeys(keyFilePath(c, "trustedKeys"))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		names := make([]string, 0, len(trusted))
+		for nodeName := range trusted {
+			names = append(names, nodeName)
+		}
+		sort.Strings(names)
+
+		for _, nodeName := range names {
+			fmt.Printf("%s\t%s\t%s\n", nodeName, net.EncodePublicKey(trusted[nodeName]), net.Fingerprint(trusted[nodeName]))
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	keysCmd.PersistentFlags().String("identityKey", defaultKeyPath(identityKeyFileName), "path to the node's identity key")
+	keysCmd.PersistentFlags().String("trustedKeys", defaultKeyPath(trustedKeysFileName), "path to the list of trusted keys")
+	keysGenerateCmd.Flags().Bool("force", false, "replace an existing identity key")
+
+	keysCmd.AddCommand(keysGenerateCmd, keysShowCmd, keysTrustCmd, keysRevokeCmd, keysListCmd)
+	rootCmd.AddCommand(keysCmd)
+}
+
+// keyFilePath returns the path given by the named flag. If the flag wasn't set, the path in the config
+// file is used, falling back to the flag's default in the config directory.
+// The flags aren't bound to viper, since start-node binds its flags of the same name.
+func keyFilePath(c *cobra.Command, name string) string {
+	f := c.Flags().Lookup(name)
+	if f.Changed {
+		return f.Value.String()
+	}
+	if path := viper.GetString(name); path != "" {
+		return path
+	}
+
+	return f.DefValue
+}
+
+func printIdentity(key ed25519.PublicKey) {
+	nodeName := viper.GetString("nodeName")
+	if nodeName == "" {
+		nodeName = "<nodeName>"
+	}
+
+	fmt.Printf("Public key:  %s\n", net.EncodePublicKey(key))
+	fmt.Printf("Fingerprint: %s\n", net.Fingerprint(key))
+	fmt.Printf("\nTo trust this node, run on the other nodes:\n  rsec-net keys trust %s %s\n", nodeName, net.EncodePublicKey(key))
+}
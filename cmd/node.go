@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
+	"github.com/Heanthor/rsec-net/pkg/net"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// nodeFlags are the names of the flags configuring a node, shared by the commands which start one
+var nodeFlags = []string{
+	"announceAddr", "announceListenPort", "announceMulticast", "dataListenPort", "dataAddr", "nodeName",
+	"announceInterval", "holdTime", "identityKey", "trustedKeys", "networkKey",
+}
+
+// addNodeFlags adds the flags configuring a node to the command
+func addNodeFlags(c *cobra.Command) {
+	c.Flags().String("announceAddr", "239.0.0.0:1145", "Address to announce on (host:port)")
+	c.Flags().String("announceListenPort", "1145", "Port to listen for announce packets on")
+	c.Flags().BoolP("announceMulticast", "m", false, "true if announcing using multicast")
+	c.Flags().String("dataListenPort", "1146", "Port to listen for data packets on")
+	c.Flags().String("dataAddr", "", "Address other nodes send data packets to (host:port), default hostname:dataListenPort")
+	c.Flags().StringP("nodeName", "n", "", "Node name")
+	c.Flags().IntP("announceInterval", "i", 5, "interval (in seconds) to announce presence to the network")
+	c.Flags().Int("holdTime", 0, "time (in seconds) without announcements before a node is considered down, default 3 announce intervals")
+	c.Flags().String("identityKey", defaultKeyPath(identityKeyFileName), "path to the node's identity key, generated if it doesn't exist. Encrypts traffic between nodes, unless empty")
+	c.Flags().String("trustedKeys", defaultKeyPath(trustedKeysFileName), "path to a list of node names and the identity keys they are pinned to, which needs an identity key")
+	c.Flags().String("networkKey", "", "passphrase shared by every node in the network. Datagrams from nodes without it are dropped")
+}
+
+// bindNodeFlags binds the node flags of the running command to their config keys.
+// Flags are bound when a command runs rather than in init, since several commands share them.
+func bindNodeFlags(c *cobra.Command) {
+	for _, name := range nodeFlags {
+		viper.BindPFlag(name, c.Flags().Lookup(name))
+	}
+}
+
+// shutdownTimeout is how long a node is given to stop when it is closed
+const shutdownTimeout = time.Second * 5
+
+// newNode creates a net interface configured by the node flags, but doesn't start announcing.
+// The interface runs until ctx is done or it is closed.
+func newNode(ctx context.Context, nodeName string) (*net.Interface, error) {
+	identityKey, trustedKeys, err := loadNodeKeys(viper.GetString("identityKey"), viper.GetString("trustedKeys"))
+	if err != nil {
+		return nil, err
+	}
+	if identityKey != nil {
+		log.Info().Str("fingerprint", net.Fingerprint(identityKey.Public().(ed25519.PublicKey))).Msg("Encrypting traffic between nodes")
+	}
+
+	var networkKey []byte
+	if passphrase := viper.GetString("networkKey"); passphrase != "" {
+		networkKey = udp.DeriveNetworkKey(passphrase)
+		log.Info().Msg("Dropping datagrams without the network key")
+	}
+
+	// create data connections
+	dataReceive := viper.GetString("dataListenPort")
+	listenAddr := ":" + dataReceive
+	dr, err := udp.NewUniReader(listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create udp data UniReader on %s: %v", listenAddr, err)
+	}
+	dr.SetNetworkKey(networkKey)
+
+	dataAddr := viper.GetString("dataAddr")
+	if dataAddr == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("unable to determine hostname for dataAddr: %v", err)
+		}
+		dataAddr = hostname + ":" + dataReceive
+	}
+
+	interval := viper.GetInt("announceInterval")
+	settings := net.InterfaceSettings{
+		AnnounceInterval: time.Second * time.Duration(interval),
+		HoldTime:         time.Second * time.Duration(viper.GetInt("holdTime")),
+		DataAddr:         dataAddr,
+		IdentityKey:      identityKey,
+		TrustedKeys:      trustedKeys,
+	}
+
+	if networkKey != nil {
+		settings.NewWriter = func(addr string) (udp.NetWriter, error) {
+			w, err := udp.NewUDPWriter(addr)
+			if err != nil {
+				return nil, err
+			}
+			w.SetNetworkKey(networkKey)
+			w.SetTag("data")
+
+			return w, nil
+		}
+	}
+
+	// create announce connection
+	var ar udp.NetReader
+	announceSend := viper.GetString("announceAddr")
+	announceReceive := viper.GetString("announceListenPort")
+	aListenAddr := ":" + announceReceive
+	if viper.GetBool("announceMulticast") {
+		mr, err := udp.NewMulticastReader(aListenAddr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create udp announce NetReader on %s: %v", aListenAddr, err)
+		}
+		mr.SetNetworkKey(networkKey)
+		ar = mr
+	} else {
+		ur, err := udp.NewUniReader(aListenAddr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create udp announce NetReader on %s: %v", aListenAddr, err)
+		}
+		ur.SetNetworkKey(networkKey)
+		ar = ur
+	}
+
+	as, err := udp.NewUDPWriter(announceSend)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create announce UDPWriter to %s: %v", announceSend, err)
+	}
+	as.SetNetworkKey(networkKey)
+	as.SetTag("announce")
+
+	i, err := net.NewInterface(ctx, nodeName, dr, as, ar, settings)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start net interface: %v", err)
+	}
+
+	return i, nil
+}
+
+// loadNodeKeys loads the node's identity key, and the trusted keys, if their paths aren't empty.
+// The trusted keys are optional if they are at the default path, which the keys commands create.
+func loadNodeKeys(keyPath, trustedPath string) (ed25519.PrivateKey, map[string]ed25519.PublicKey, error) {
+	var trustedKeys map[string]ed25519.PublicKey
+	if trustedPath != "" {
+		var err error
+		trustedKeys, err = loadTrustedKeys(trustedPath)
+		if os.IsNotExist(err) && trustedPath == defaultKeyPath(trustedKeysFileName) {
+			// no keys have been trusted yet
+			err = nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to load trusted keys %s: %v", trustedPath, err)
+		}
+	}
+
+	if keyPath == "" {
+		if trustedKeys != nil {
+			return nil, nil, fmt.Errorf("trusted keys %s need an identity key", trustedPath)
+		}
+
+		return nil, nil, nil
+	}
+
+	identityKey, err := loadIdentityKey(keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load identity key %s: %v", keyPath, err)
+	}
+
+	return identityKey, trustedKeys, nil
+}
+
+// closeNode closes a net interface, waiting at most shutdownTimeout for it to stop
+func closeNode(i *net.Interface) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := i.Close(ctx); err != nil {
+		log.Error().Err(err).Msg("Node didn't stop cleanly")
+	}
+}
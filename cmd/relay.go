@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/Heanthor/rsec-net/internal/relay"
+	"github.com/Heanthor/rsec-net/internal/sim"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var relayCmd = &cobra.Command{
+	Use:   "relay <scenario.yaml>",
+	Short: "Relay announcements and data between nodes along the links of a scenario.",
+	Long: `Relay announcements and data between nodes which can't multicast to each other, such as containers.
+
+Nodes send their announcements to the relay, with --announceMulticast=false and --announceAddr set to
+the relay's address. Each datagram is forwarded only to the nodes the sender has a link to in the scenario,
+after the link's latency and bandwidth delays, unless the link drops it. Nodes are found at the host of their
+name, unless given in --hosts, and listen for announcements on --announcePort.
+
+Data and probes are only relayed for nodes given in --dataPorts, which must set --dataAddr to the relay's
+host and that port, and listen on the same port themselves. Other nodes send data to each other directly.
+The scenario's events, and the cost and reorder of its links, are ignored.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(c *cobra.Command, args []string) error {
+		c.SilenceUsage = true
+		c.SilenceErrors = true
+
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		if viper.GetBool("verbose") {
+			zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		}
+
+		sc, err := sim.LoadScenario(args[0])
+		if err != nil {
+			return fmt.Errorf("unable to load scenario: %v", err)
+		}
+
+		hosts, _ := c.Flags().GetStringToString("hosts")
+		sources, _ := c.Flags().GetStringToString("sources")
+		dataPorts, _ := c.Flags().GetStringToString("dataPorts")
+		config, err := relay.NewConfig(sc, viper.GetString("announcePort"), hosts, sources, dataPorts)
+		if err != nil {
+			return fmt.Errorf("invalid relay configuration: %v", err)
+		}
+
+		r, err := relay.New(config, viper.GetString("relayAddr"))
+		if err != nil {
+			return fmt.Errorf("unable to start relay: %v", err)
+		}
+		log.Info().Str("addr", r.Addr().String()).Int("nodes", len(config.Nodes)).Int("links", len(config.Links)).Msg("Relay started")
+
+		return r.Serve()
+	},
+}
+
+func init() {
+	relayCmd.Flags().String("relayAddr", "0.0.0.0:1100", "address to listen for announcements on, host:port")
+	viper.BindPFlag("relayAddr", relayCmd.Flags().Lookup("relayAddr"))
+	relayCmd.Flags().String("announcePort", "1140", "port nodes listen for announcements on")
+	viper.BindPFlag("announcePort", relayCmd.Flags().Lookup("announcePort"))
+	relayCmd.Flags().StringToString("hosts", nil, "hosts of nodes which aren't found at the host of their name, node=host,...")
+	relayCmd.Flags().StringToString("sources", nil, "addresses datagrams from nodes come from, if not their host, node=host[:port],...")
+	relayCmd.Flags().StringToString("dataPorts", nil, "ports to relay data to nodes on, node=port,...")
+
+	rootCmd.AddCommand(relayCmd)
+}
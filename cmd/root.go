@@ -50,6 +50,8 @@ func init() {
 	rootCmd.PersistentFlags().String("profilePath", "./", "profile file location, default current directory")
 	rootCmd.PersistentFlags().Bool("netProfile", false, "enable pprof profiling server")
 	rootCmd.PersistentFlags().Int("netProfilePort", 8080, "pprof profiling server port")
+	rootCmd.PersistentFlags().Bool("admin", false, "enable the admin API server")
+	rootCmd.PersistentFlags().String("adminAddr", "127.0.0.1:8081", "admin API server address, host:port or unix:<path>")
 
 	viper.BindPFlag("verbose", rootCmd.PersistentFlags().Lookup("verbose"))
 	viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
@@ -57,6 +59,8 @@ func init() {
 	viper.BindPFlag("profilePath", rootCmd.PersistentFlags().Lookup("profilePath"))
 	viper.BindPFlag("netProfile", rootCmd.PersistentFlags().Lookup("netProfile"))
 	viper.BindPFlag("netProfilePort", rootCmd.PersistentFlags().Lookup("netProfilePort"))
+	viper.BindPFlag("admin", rootCmd.PersistentFlags().Lookup("admin"))
+	viper.BindPFlag("adminAddr", rootCmd.PersistentFlags().Lookup("adminAddr"))
 }
 
 // initConfig reads in config file and ENV variables if set.
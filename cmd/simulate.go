@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Heanthor/rsec-net/internal/sim"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var simulateCmd = &cobra.Command{
+	Use:   "simulate <scenario.yaml>",
+	Short: "Run a scenario on a simulated mesh.",
+	Long: `Run the nodes, links and timed events of a scenario file in-process, on a virtual clock,
+and report how long the nodes took to converge after each event and their routes at the end.
+
+Runs with the same scenario are repeatable, as long as its links don't drop or reorder datagrams.
+See scenarios/ for examples.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(c *cobra.Command, args []string) error {
+		c.SilenceUsage = true
+		c.SilenceErrors = true
+
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+		if viper.GetBool("verbose") {
+			zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		}
+
+		sc, err := sim.LoadScenario(args[0])
+		if err != nil {
+			return fmt.Errorf("unable to load scenario: %v", err)
+		}
+
+		result, err := sc.Run()
+		if err != nil {
+			return fmt.Errorf("unable to run scenario: %v", err)
+		}
+
+		printSimulation(result)
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(simulateCmd)
+}
+
+func printSimulation(result *sim.Result) {
+	for _, c := range result.Convergence {
+		what := "start"
+		if len(c.Events) > 0 {
+			events := make([]string, len(c.Events))
+			for i, e := range c.Events {
+				events[i] = e.String()
+			}
+			what = strings.Join(events, ", ")
+		}
+
+		if c.Converged {
+			fmt.Printf("%v\t%s: converged after %v\n", c.At, what, c.Took)
+		} else {
+			fmt.Printf("%v\t%s: didn't converge in %v\n", c.At, what, c.Took)
+		}
+	}
+
+	fmt.Printf("\nRoutes at %v:\n", result.Elapsed)
+
+	nodes := make([]string, 0, len(result.Routes))
+	for nodeName := range result.Routes {
+		nodes = append(nodes, nodeName)
+	}
+	sort.Strings(nodes)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "\nNODE\tDESTINATION\tNEXT HOP\tCOST\tPATH\n")
+	for _, nodeName := range nodes {
+		dests := make([]string, 0, len(result.Routes[nodeName]))
+		for dest := range result.Routes[nodeName] {
+			dests = append(dests, dest)
+		}
+		sort.Strings(dests)
+
+		if len(dests) == 0 {
+			fmt.Fprintf(w, "%s\t-\t\t\t\n", nodeName)
+		}
+		for _, dest := range dests {
+			r := result.Routes[nodeName][dest]
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", nodeName, dest, r.NextHop, r.Cost, strings.Join(r.Path, " > "))
+		}
+	}
+	w.Flush()
+}
@@ -1,25 +1,24 @@
 package cmd
 
 import (
+	"context"
 	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
 	"strconv"
 	"syscall"
-	"time"
 
 	// pprof network server
 	_ "net/http/pprof"
 
-	"github.com/Heanthor/rsec-net/internal/udp"
+	"github.com/Heanthor/rsec-net/internal/admin"
 
 	"github.com/rs/zerolog"
 
 	"github.com/pkg/profile"
 	"github.com/spf13/viper"
 
-	"github.com/Heanthor/rsec-net/pkg/net"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
@@ -28,6 +27,9 @@ var announceCmd = &cobra.Command{
 	Use:   "start-node",
 	Short: "Add the node onto the network.",
 	Long:  `Add the node onto the network.`,
+	PreRun: func(c *cobra.Command, args []string) {
+		bindNodeFlags(c)
+	},
 	Run: func(c *cobra.Command, args []string) {
 		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 		if viper.GetBool("verbose") {
@@ -43,20 +45,7 @@ var announceCmd = &cobra.Command{
 }
 
 func init() {
-	announceCmd.Flags().String("announceAddr", "239.0.0.0:1145", "Address to announce on (host:port)")
-	announceCmd.Flags().String("announceListenPort", "1145", "Port to listen for announce packets on")
-	announceCmd.Flags().BoolP("announceMulticast", "m", false, "true if announcing using multicast")
-	announceCmd.Flags().String("dataListenPort", "1146", "Port to listen for data packets on")
-	announceCmd.Flags().StringP("nodeName", "n", "", "Node name")
-	announceCmd.Flags().IntP("announceInterval", "i", 5, "interval (in seconds) to announce presence to the network")
-
-	viper.BindPFlag("announceAddr", announceCmd.Flags().Lookup("announceAddr"))
-	viper.BindPFlag("announceListenPort", announceCmd.Flags().Lookup("announceListenPort"))
-	viper.BindPFlag("announceMulticast", announceCmd.Flags().Lookup("announceMulticast"))
-	viper.BindPFlag("dataAddr", announceCmd.Flags().Lookup("dataAddr"))
-	viper.BindPFlag("dataListenPort", announceCmd.Flags().Lookup("dataListenPort"))
-	viper.BindPFlag("nodeName", announceCmd.Flags().Lookup("nodeName"))
-	viper.BindPFlag("announceInterval", announceCmd.Flags().Lookup("announceInterval"))
+	addNodeFlags(announceCmd)
 
 	rootCmd.AddCommand(announceCmd)
 }
@@ -104,50 +93,47 @@ func initalizeAnnounce(nodeName string) {
 		}()
 	}
 
-	interval := viper.GetInt("announceInterval")
-	settings := net.InterfaceSettings{
-		AnnounceInterval: time.Second * time.Duration(interval),
-	}
-
-	// create data connections
-	dataReceive := viper.GetString("dataListenPort")
-	listenAddr := ":" + dataReceive
-	dr, err := udp.NewUniReader(listenAddr)
+	i, err := newNode(context.Background(), nodeName)
 	if err != nil {
-		log.Panic().Err(err).Str("listenAddr", listenAddr).Msg("unable to create udp data UniReader")
+		log.Panic().Err(err).Msg("unable to create node")
 	}
+	i.StartAnnounce()
 
-	// create announce connection
-	var ar udp.NetReader
-	announceSend := viper.GetString("announceAddr")
-	announceReceive := viper.GetString("announceListenPort")
-	aListenAddr := ":" + announceReceive
-	if viper.GetBool("announceMulticast") {
-		ar, err = udp.NewMulticastReader(aListenAddr)
-	} else {
-		ar, err = udp.NewUniReader(aListenAddr)
-	}
-	if err != nil {
-		log.Panic().Err(err).Str("aListenAddr", aListenAddr).Msg("unable to create udp announce NetReader")
+	var adminServer *admin.Server
+	if viper.GetBool("admin") {
+		adminServer = admin.NewServer(i)
+		go func() {
+			addr := viper.GetString("adminAddr")
+			log.Info().Str("adminAddr", addr).Msg("Started admin API server")
+			if err := adminServer.ListenAndServe(addr); err != nil {
+				log.Error().Err(err).Str("adminAddr", addr).Msg("Admin API server failed")
+			}
+		}()
 	}
 
-	as, err := udp.NewUDPWriter(announceSend)
-	if err != nil {
-		log.Panic().Err(err).Str("dataAddr", announceSend).Msg("unable to create announce udp data UDPWriter")
-	}
+	go func() {
+		for e := range i.Events() {
+			log.Info().Str("nodeName", e.NodeName).Str("kind", e.Kind.String()).Msg("Node event")
+		}
+	}()
 
-	i, err := net.NewInterface(nodeName, dr, as, ar, settings)
-	if err != nil {
-		log.Panic().Err(err).Msg("unable to start net interface")
-	}
-	i.StartAnnounce()
+	go func() {
+		for err := range i.Errors() {
+			log.Error().Err(err).Msg("Node error")
+		}
+	}()
 
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 
 	<-c
 	log.Info().Msg("CTRL-C pressed, stopping...")
-	profiler.Stop()
-	i.Close()
+	if profiler != nil {
+		profiler.Stop()
+	}
+	if adminServer != nil {
+		adminServer.Close()
+	}
+	closeNode(i)
 	os.Exit(0)
 }
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/admin"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state of a running node.",
+	Long: `Show the identity, neighbors, topology, routes and counters of a running node,
+queried from its admin API server. The node must be started with --admin, and the same --adminAddr.`,
+	Args: cobra.NoArgs,
+	RunE: func(c *cobra.Command, args []string) error {
+		c.SilenceUsage = true
+		c.SilenceErrors = true
+
+		status, err := queryStatus(admin.NewClient(viper.GetString("adminAddr")))
+		if err != nil {
+			return fmt.Errorf("unable to query node: %v", err)
+		}
+
+		if asJSON, _ := c.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(status)
+		}
+
+		printStatus(status)
+
+		return nil
+	},
+}
+
+func init() {
+	statusCmd.Flags().Bool("json", false, "print the status as JSON")
+
+	rootCmd.AddCommand(statusCmd)
+}
+
+type nodeStatus struct {
+	Identity  admin.Identity   `json:"identity"`
+	Neighbors []admin.Neighbor `json:"neighbors"`
+	Topology  []admin.Link     `json:"topology"`
+	Routes    []admin.Route    `json:"routes"`
+	Counters  admin.Counters   `json:"counters"`
+}
+
+func queryStatus(c *admin.Client) (status nodeStatus, err error) {
+	if status.Identity, err = c.Identity(); err != nil {
+		return
+	}
+	if status.Neighbors, err = c.Neighbors(); err != nil {
+		return
+	}
+	if status.Topology, err = c.Topology(); err != nil {
+		return
+	}
+	if status.Routes, err = c.Routes(); err != nil {
+		return
+	}
+	status.Counters, err = c.Counters()
+
+	return
+}
+
+func printStatus(status nodeStatus) {
+	fmt.Printf("Node:        %s (%s)\n", status.Identity.NodeName, status.Identity.Addr)
+	if status.Identity.Fingerprint != "" {
+		fmt.Printf("Fingerprint: %s\n", status.Identity.Fingerprint)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "\nNEIGHBOR\tADDRESS\tRTT\tJITTER\tLOSS\tLAST SEEN\n")
+	for _, n := range status.Neighbors {
+		fmt.Fprintf(w, "%s\t%s\t%.2fms\t%.2fms\t%.0f%%\t%s ago\n", n.NodeName, n.Addr, n.RTT, n.Jitter, n.Loss*100,
+			time.Since(n.LastSeen).Round(time.Millisecond*100))
+	}
+
+	fmt.Fprintf(w, "\nDESTINATION\tNEXT HOP\tCOST\tPATH\n")
+	for _, r := range status.Routes {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", r.Dest, r.NextHop, r.Cost, strings.Join(r.Path, " > "))
+	}
+
+	fmt.Fprintf(w, "\nLINK\tCOST\n")
+	for _, l := range status.Topology {
+		fmt.Fprintf(w, "%s > %s\t%d\n", l.From, l.To, l.Cost)
+	}
+	w.Flush()
+
+	fmt.Printf("\nAnnouncements sent: %d, received: %d\n", status.Counters.AnnouncementsSent, status.Counters.AnnouncementsReceived)
+	fmt.Printf("Route recomputations: %d\n", status.Counters.RouteRecomputations)
+
+	reasons := make([]string, 0, len(status.Counters.Dropped))
+	for reason, n := range status.Counters.Dropped {
+		if n > 0 {
+			reasons = append(reasons, fmt.Sprintf("%s %d", reason, n))
+		}
+	}
+	sort.Strings(reasons)
+	if len(reasons) == 0 {
+		reasons = append(reasons, "none")
+	}
+	fmt.Printf("Dropped packets: %s\n", strings.Join(reasons, ", "))
+}
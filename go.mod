@@ -15,7 +15,6 @@ require (
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/spf13/viper v1.6.1
 	github.com/stretchr/testify v1.4.0
-	golang.org/x/sys v0.0.0-20191220220014-0732a990476f // indirect
-	golang.org/x/text v0.3.2 // indirect
-	gopkg.in/yaml.v2 v2.2.7 // indirect
+	golang.org/x/crypto v0.9.0
+	gopkg.in/yaml.v2 v2.2.7
 )
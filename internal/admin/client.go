@@ -0,0 +1,136 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// queryTimeout is how long to wait for responses which don't involve other nodes
+const queryTimeout = time.Second * 5
+
+// ErrTimedOut is returned when an echo request isn't answered within its timeout
+var ErrTimedOut = errors.New("timed out")
+
+// Client queries the admin server of a running node
+type Client struct {
+	http    *http.Client
+	baseURL string
+}
+
+// NewClient creates a client for the admin server listening on addr, host:port or unix:<path>
+func NewClient(addr string) *Client {
+	c := &Client{
+		http:    &http.Client{},
+		baseURL: "http://" + addr,
+	}
+
+	if strings.HasPrefix(addr, unixPrefix) {
+		path := strings.TrimPrefix(addr, unixPrefix)
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+		// the host is ignored when dialing the socket
+		c.baseURL = "http://unix"
+	}
+
+	return c
+}
+
+// Identity returns the node's identity
+func (c *Client) Identity() (Identity, error) {
+	var id Identity
+	err := c.get("/identity", &id, queryTimeout)
+
+	return id, err
+}
+
+// Neighbors returns the node's connected nodes
+func (c *Client) Neighbors() ([]Neighbor, error) {
+	var neighbors []Neighbor
+	err := c.get("/neighbors", &neighbors, queryTimeout)
+
+	return neighbors, err
+}
+
+// Topology returns the links in the node's view of the network graph
+func (c *Client) Topology() ([]Link, error) {
+	var links []Link
+	err := c.get("/topology", &links, queryTimeout)
+
+	return links, err
+}
+
+// Routes returns the node's routing table
+func (c *Client) Routes() ([]Route, error) {
+	var routes []Route
+	err := c.get("/routes", &routes, queryTimeout)
+
+	return routes, err
+}
+
+// Counters returns the node's counters
+func (c *Client) Counters() (Counters, error) {
+	var counters Counters
+	err := c.get("/counters", &counters, queryTimeout)
+
+	return counters, err
+}
+
+// Ping sends an echo request to the named node with the given TTL, waiting up to timeout for the answer.
+// Returns ErrTimedOut if it isn't answered.
+func (c *Client) Ping(nodeName string, ttl int, timeout time.Duration) (Echo, error) {
+	var echo Echo
+	q := url.Values{"node": {nodeName}, "ttl": {strconv.Itoa(ttl)}, "timeout": {timeout.String()}}
+	err := c.get("/ping?"+q.Encode(), &echo, timeout+queryTimeout)
+
+	return echo, err
+}
+
+// Traceroute traces the route to the named node, waiting up to timeout for the answer from each hop
+func (c *Client) Traceroute(nodeName string, maxHops int, timeout time.Duration) (Trace, error) {
+	var trace Trace
+	q := url.Values{"node": {nodeName}, "maxHops": {strconv.Itoa(maxHops)}, "timeout": {timeout.String()}}
+	err := c.get("/traceroute?"+q.Encode(), &trace, timeout*time.Duration(maxHops)+queryTimeout)
+
+	return trace, err
+}
+
+func (c *Client) get(path string, resp interface{}, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	r, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	switch r.StatusCode {
+	case http.StatusOK:
+	case http.StatusGatewayTimeout:
+		return ErrTimedOut
+	default:
+		msg, _ := ioutil.ReadAll(io.LimitReader(r.Body, 512))
+		return fmt.Errorf("%s: %s", r.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return json.NewDecoder(r.Body).Decode(resp)
+}
@@ -0,0 +1,120 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	rsnet "github.com/Heanthor/rsec-net/pkg/net"
+)
+
+const (
+	defaultEchoTTL     = 16
+	defaultEchoTimeout = time.Second
+	// maxEchoTimeout bounds how long a request may keep the server waiting for each echo
+	maxEchoTimeout = time.Second * 30
+)
+
+func (s *Server) ping(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseEchoQuery(w, r, "ttl")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), q.timeout)
+	defer cancel()
+
+	reply, err := s.node.Echo(ctx, q.node, uint8(q.hops))
+	if err != nil {
+		echoError(w, err)
+		return
+	}
+
+	writeJSON(w, r, toEcho(reply))
+}
+
+func (s *Server) traceroute(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseEchoQuery(w, r, "maxHops")
+	if !ok {
+		return
+	}
+
+	replies, err := s.node.Traceroute(r.Context(), q.node, q.hops, q.timeout)
+	if err != nil {
+		echoError(w, err)
+		return
+	}
+
+	trace := Trace{Hops: []Echo{}, Predicted: []string{}}
+	for _, reply := range replies {
+		trace.Hops = append(trace.Hops, toEcho(reply))
+	}
+	if q.node == s.node.Identity().NodeName {
+		trace.Predicted = []string{q.node}
+	} else if route, ok := s.node.RoutingTable().Routes()[q.node]; ok {
+		trace.Predicted = route.Path
+	}
+
+	writeJSON(w, r, trace)
+}
+
+type echoQuery struct {
+	node    string
+	hops    int
+	timeout time.Duration
+}
+
+// parseEchoQuery parses the node, number of hops, and timeout of an echo request, writing an error
+// response if they are invalid
+func parseEchoQuery(w http.ResponseWriter, r *http.Request, hopsParam string) (echoQuery, bool) {
+	q := echoQuery{
+		node:    r.URL.Query().Get("node"),
+		hops:    defaultEchoTTL,
+		timeout: defaultEchoTimeout,
+	}
+	if q.node == "" {
+		http.Error(w, "node is required", http.StatusBadRequest)
+		return q, false
+	}
+
+	if v := r.URL.Query().Get(hopsParam); v != "" {
+		hops, err := strconv.Atoi(v)
+		if err != nil || hops < 1 || hops > 255 {
+			http.Error(w, hopsParam+" must be between 1 and 255", http.StatusBadRequest)
+			return q, false
+		}
+		q.hops = hops
+	}
+
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 || timeout > maxEchoTimeout {
+			http.Error(w, "timeout must be a duration up to "+maxEchoTimeout.String(), http.StatusBadRequest)
+			return q, false
+		}
+		q.timeout = timeout
+	}
+
+	return q, true
+}
+
+func echoError(w http.ResponseWriter, err error) {
+	switch err {
+	case rsnet.ErrNoRoute:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case context.DeadlineExceeded:
+		http.Error(w, "timed out", http.StatusGatewayTimeout)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func toEcho(reply rsnet.EchoReply) Echo {
+	return Echo{
+		From:    reply.From,
+		Reached: reply.Reached,
+		Hops:    reply.Hops,
+		RTT:     millis(reply.RTT),
+	}
+}
@@ -0,0 +1,121 @@
+package admin
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// metricsContentType is the Prometheus text exposition format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metrics serves the node's counters in the Prometheus text exposition format
+func (s *Server) metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	mw := &metricsWriter{w: bufio.NewWriter(w)}
+
+	c := s.node.Counters()
+	mw.family("rsec_net_announcements_sent_total", "Announcements sent.", "counter",
+		sample{value: float64(c.AnnouncementsSent)})
+	mw.family("rsec_net_announcements_received_total", "Announcements received from other nodes.", "counter",
+		sample{value: float64(c.AnnouncementsReceived)})
+	mw.family("rsec_net_route_recomputations_total", "Times the routing table was recomputed.", "counter",
+		sample{value: float64(c.RouteRecomputations)})
+
+	var dropped []sample
+	for reason, n := range c.Dropped {
+		dropped = append(dropped, sample{labels: []string{"reason", reason.String()}, value: float64(n)})
+	}
+
+	var packets, bytes, decodeErrors []sample
+	var badMAC uint64
+	for tag, st := range s.transportStats() {
+		packets = append(packets,
+			sample{labels: []string{"tag", tag, "direction", "in"}, value: float64(st.PacketsIn)},
+			sample{labels: []string{"tag", tag, "direction", "out"}, value: float64(st.PacketsOut)})
+		bytes = append(bytes,
+			sample{labels: []string{"tag", tag, "direction", "in"}, value: float64(st.BytesIn)},
+			sample{labels: []string{"tag", tag, "direction", "out"}, value: float64(st.BytesOut)})
+		decodeErrors = append(decodeErrors, sample{labels: []string{"tag", tag}, value: float64(st.DecodeErrors)})
+		badMAC += st.BadMAC
+	}
+	dropped = append(dropped, sample{labels: []string{"reason", "bad_mac"}, value: float64(badMAC)})
+	mw.family("rsec_net_packets_total", "Datagrams read and written, by socket tag.", "counter", packets...)
+	mw.family("rsec_net_bytes_total", "Bytes of datagrams read and written, by socket tag.", "counter", bytes...)
+	mw.family("rsec_net_decode_errors_total", "Datagrams read which couldn't be reassembled or decoded.", "counter", decodeErrors...)
+	mw.family("rsec_net_dropped_packets_total", "Packets dropped, by reason.", "counter", dropped...)
+
+	neighbors := s.node.Neighbors()
+	var rtt, loss []sample
+	for _, info := range neighbors {
+		rtt = append(rtt, sample{labels: []string{"neighbor", info.NodeName}, value: info.Latency.SmoothedRTT.Seconds()})
+		loss = append(loss, sample{labels: []string{"neighbor", info.NodeName}, value: info.Latency.Loss})
+	}
+	mw.family("rsec_net_neighbors", "Connected nodes.", "gauge", sample{value: float64(len(neighbors))})
+	mw.family("rsec_net_neighbor_rtt_seconds", "Smoothed round trip time to each connected node.", "gauge", rtt...)
+	mw.family("rsec_net_neighbor_loss_ratio", "Smoothed fraction of probes to each connected node which went unanswered.", "gauge", loss...)
+
+	if err := mw.flush(); err != nil {
+		log.Error().Err(err).Msg("Unable to write metrics")
+	}
+}
+
+// sample is a single value of a metric family. labels holds pairs of label names and values.
+type sample struct {
+	labels []string
+	value  float64
+}
+
+type metricsWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// family writes a metric family, with its samples sorted by labels so the output is stable
+func (m *metricsWriter) family(name, help, metricType string, samples ...sample) {
+	m.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+
+	lines := make([]string, 0, len(samples))
+	for _, s := range samples {
+		lines = append(lines, name+formatLabels(s.labels)+" "+strconv.FormatFloat(s.value, 'g', -1, 64)+"\n")
+	}
+	sort.Strings(lines)
+
+	for _, line := range lines {
+		m.printf("%s", line)
+	}
+}
+
+func (m *metricsWriter) printf(format string, args ...interface{}) {
+	if m.err == nil {
+		_, m.err = fmt.Fprintf(m.w, format, args...)
+	}
+}
+
+func (m *metricsWriter) flush() error {
+	if m.err != nil {
+		return m.err
+	}
+
+	return m.w.Flush()
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelValueEscaper.Replace(labels[i+1])+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,332 @@
+// Package admin serves the state of a running node as JSON over HTTP, for debugging and monitoring.
+//
+// The server listens on a TCP address (host:port), or on a Unix socket if the address is of the form
+// unix:<path>. Every endpoint only accepts GET:
+//
+//	/identity   the node's name, data address, and identity key
+//	/neighbors  connected nodes, with measured latency and when they last announced
+//	/topology   the network graph from link state advertisements
+//	/routes     the routing table
+//	/counters   running totals of notable packets
+//	/metrics    counters, transport stats and neighbor latency in the Prometheus text format
+//	/ping       sends an echo request, ?node=<name>[&ttl=<hops>][&timeout=<duration>]
+//	/traceroute traces the route to a node, ?node=<name>[&maxHops=<hops>][&timeout=<duration per hop>]
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
+	rsnet "github.com/Heanthor/rsec-net/pkg/net"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	unixPrefix = "unix:"
+	// staleSocketTimeout is how long to try connecting to an existing socket before deciding it is stale
+	staleSocketTimeout = time.Second
+)
+
+// Node is the running node served by the admin server, implemented by *net.Interface
+type Node interface {
+	Identity() rsnet.Identity
+	Neighbors() []rsnet.NodeInfo
+	Topology() map[string]map[string]int
+	RoutingTable() *rsnet.RoutingTable
+	Counters() rsnet.Counters
+	Echo(ctx context.Context, nodeName string, ttl uint8) (rsnet.EchoReply, error)
+	Traceroute(ctx context.Context, nodeName string, maxHops int, timeout time.Duration) ([]rsnet.EchoReply, error)
+}
+
+// Identity is the response of /identity
+type Identity struct {
+	NodeName string `json:"nodeName"`
+	Addr     string `json:"addr"`
+	// PublicKey and Fingerprint are empty if the node doesn't have an identity key
+	PublicKey   string `json:"publicKey,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// Neighbor is an element of the response of /neighbors. Round trip times are in milliseconds.
+type Neighbor struct {
+	NodeName string    `json:"nodeName"`
+	Addr     string    `json:"addr"`
+	LastSeen time.Time `json:"lastSeen"`
+	RTT      float64   `json:"rttMs"`
+	Jitter   float64   `json:"jitterMs"`
+	MinRTT   float64   `json:"minRttMs"`
+	MaxRTT   float64   `json:"maxRttMs"`
+	Loss     float64   `json:"loss"`
+	Samples  int       `json:"samples"`
+}
+
+// Link is an element of the response of /topology, a directed link advertised by its origin
+type Link struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Cost int    `json:"cost"`
+}
+
+// Route is an element of the response of /routes
+type Route struct {
+	Dest    string   `json:"dest"`
+	NextHop string   `json:"nextHop"`
+	Cost    int      `json:"cost"`
+	Path    []string `json:"path"`
+}
+
+// Counters is the response of /counters
+type Counters struct {
+	AnnouncementsSent     uint64 `json:"announcementsSent"`
+	AnnouncementsReceived uint64 `json:"announcementsReceived"`
+	RouteRecomputations   uint64 `json:"routeRecomputations"`
+	// Dropped is the number of packets dropped for each reason
+	Dropped map[string]uint64 `json:"dropped"`
+}
+
+// Echo is the response of /ping, and an element of the response of /traceroute. Round trip times are in milliseconds.
+type Echo struct {
+	// From is the node which answered, or empty if nobody answered within the timeout
+	From    string  `json:"from"`
+	Reached bool    `json:"reached"`
+	Hops    int     `json:"hops"`
+	RTT     float64 `json:"rttMs"`
+}
+
+// Trace is the response of /traceroute
+type Trace struct {
+	Hops []Echo `json:"hops"`
+	// Predicted is the path to the node in the routing table, for comparison
+	Predicted []string `json:"predicted"`
+}
+
+// Server serves the admin endpoints for a node
+type Server struct {
+	node Node
+	http *http.Server
+
+	// transportStats returns the stats of each socket tag. The sockets' stats are shared by the whole process.
+	transportStats func() map[string]udp.Stats
+}
+
+// NewServer creates an admin server for the node
+func NewServer(node Node) *Server {
+	s := &Server{node: node, transportStats: udp.TagStats}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/identity", s.get(s.identity))
+	mux.HandleFunc("/neighbors", s.get(s.neighbors))
+	mux.HandleFunc("/topology", s.get(s.topology))
+	mux.HandleFunc("/routes", s.get(s.routes))
+	mux.HandleFunc("/counters", s.get(s.counters))
+	mux.HandleFunc("/metrics", getOnly(s.metrics))
+	mux.HandleFunc("/ping", getOnly(s.ping))
+	mux.HandleFunc("/traceroute", getOnly(s.traceroute))
+	s.http = &http.Server{Handler: mux}
+
+	return s
+}
+
+// Handler returns the handler serving the admin endpoints
+func (s *Server) Handler() http.Handler {
+	return s.http.Handler
+}
+
+// ListenAndServe listens on addr, and serves until Close is called.
+// A stale Unix socket left behind by a previous node is replaced.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := listen(addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Start listens on addr, and serves in the background until Close is called.
+// Returns the address listened on, which has the port chosen if addr's port was 0.
+func (s *Server) Start(addr string) (string, error) {
+	l, err := listen(addr)
+	if err != nil {
+		return "", err
+	}
+
+	go func() {
+		if err := s.Serve(l); err != nil {
+			log.Error().Err(err).Str("adminAddr", addr).Msg("Admin API server failed")
+		}
+	}()
+
+	if strings.HasPrefix(addr, unixPrefix) {
+		return addr, nil
+	}
+
+	return l.Addr().String(), nil
+}
+
+// Serve serves on the listener until Close is called
+func (s *Server) Serve(l net.Listener) error {
+	if err := s.http.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	return s.http.Close()
+}
+
+func listen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, unixPrefix) {
+		return net.Listen("tcp", addr)
+	}
+
+	path := strings.TrimPrefix(addr, unixPrefix)
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	// the admin API is for the node's operator only, so the socket is never accessible to anyone else
+	restore := restrictUmask()
+	defer restore()
+
+	return net.Listen("unix", path)
+}
+
+// removeStaleSocket removes the socket at path if nothing is listening on it, such as one left behind by a node
+// which didn't shut down cleanly. Returns an error if something other than a socket is there, or it is in use.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s already exists, and isn't a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, staleSocketTimeout); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use", path)
+	}
+
+	return os.Remove(path)
+}
+
+// getOnly wraps a handler, rejecting requests with any method other than GET
+func getOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// get wraps a handler returning a response to be encoded as JSON
+func (s *Server) get(handler func() interface{}) http.HandlerFunc {
+	return getOnly(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, handler())
+	})
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(resp); err != nil {
+		log.Error().Err(err).Str("path", r.URL.Path).Msg("Unable to write admin response")
+	}
+}
+
+func (s *Server) identity() interface{} {
+	id := s.node.Identity()
+	resp := Identity{NodeName: id.NodeName, Addr: id.Addr}
+	if id.PublicKey != nil {
+		resp.PublicKey = rsnet.EncodePublicKey(id.PublicKey)
+		resp.Fingerprint = rsnet.Fingerprint(id.PublicKey)
+	}
+
+	return resp
+}
+
+func (s *Server) neighbors() interface{} {
+	neighbors := []Neighbor{}
+	for _, info := range s.node.Neighbors() {
+		neighbors = append(neighbors, Neighbor{
+			NodeName: info.NodeName,
+			Addr:     info.Addr,
+			LastSeen: info.LastSeen,
+			RTT:      millis(info.Latency.SmoothedRTT),
+			Jitter:   millis(info.Latency.Jitter),
+			MinRTT:   millis(info.Latency.MinRTT),
+			MaxRTT:   millis(info.Latency.MaxRTT),
+			Loss:     info.Latency.Loss,
+			Samples:  info.Latency.Samples,
+		})
+	}
+
+	return neighbors
+}
+
+func (s *Server) topology() interface{} {
+	links := []Link{}
+	for from, adj := range s.node.Topology() {
+		for to, cost := range adj {
+			links = append(links, Link{from, to, cost})
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].From != links[j].From {
+			return links[i].From < links[j].From
+		}
+		return links[i].To < links[j].To
+	})
+
+	return links
+}
+
+func (s *Server) routes() interface{} {
+	routes := []Route{}
+	for _, r := range s.node.RoutingTable().Routes() {
+		routes = append(routes, Route(r))
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Dest < routes[j].Dest
+	})
+
+	return routes
+}
+
+func (s *Server) counters() interface{} {
+	c := s.node.Counters()
+	resp := Counters{
+		AnnouncementsSent:     c.AnnouncementsSent,
+		AnnouncementsReceived: c.AnnouncementsReceived,
+		RouteRecomputations:   c.RouteRecomputations,
+		Dropped:               make(map[string]uint64, len(c.Dropped)),
+	}
+	for reason, n := range c.Dropped {
+		resp.Dropped[reason.String()] = n
+	}
+
+	return resp
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,272 @@
+package admin
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
+	rsnet "github.com/Heanthor/rsec-net/pkg/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeNode struct {
+	identity  rsnet.Identity
+	neighbors []rsnet.NodeInfo
+	topology  map[string]map[string]int
+	routes    *rsnet.RoutingTable
+	counters  rsnet.Counters
+}
+
+func (f *fakeNode) Identity() rsnet.Identity            { return f.identity }
+func (f *fakeNode) Neighbors() []rsnet.NodeInfo         { return f.neighbors }
+func (f *fakeNode) Topology() map[string]map[string]int { return f.topology }
+func (f *fakeNode) RoutingTable() *rsnet.RoutingTable   { return f.routes }
+func (f *fakeNode) Counters() rsnet.Counters            { return f.counters }
+
+// Echo pretends the node is two hops away, through n2, and that n4 never answers
+func (f *fakeNode) Echo(ctx context.Context, nodeName string, ttl uint8) (rsnet.EchoReply, error) {
+	switch {
+	case nodeName == "n4":
+		<-ctx.Done()
+		return rsnet.EchoReply{}, ctx.Err()
+	case nodeName != "n3":
+		return rsnet.EchoReply{}, rsnet.ErrNoRoute
+	case ttl < 2:
+		return rsnet.EchoReply{From: "n2", Hops: 1, RTT: time.Millisecond}, nil
+	default:
+		return rsnet.EchoReply{From: "n3", Reached: true, Hops: 2, RTT: time.Millisecond * 2}, nil
+	}
+}
+
+func (f *fakeNode) Traceroute(ctx context.Context, nodeName string, maxHops int, timeout time.Duration) ([]rsnet.EchoReply, error) {
+	var replies []rsnet.EchoReply
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		reply, err := f.Echo(ctx, nodeName, uint8(ttl))
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+		if reply.Reached {
+			break
+		}
+	}
+
+	return replies, nil
+}
+
+func newFakeNode() *fakeNode {
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	routes := rsnet.NewRoutingTable("n1")
+	routes.SetNeighbors(map[string]int{"n2": 5})
+	routes.UpdateLinkState(&rsnet.LinkStatePacket{Origin: "n2", Adjacencies: map[string]int{"n3": 7}})
+
+	n2 := rsnet.NodeInfo{NodeName: "n2", Addr: "host2:1146", LastSeen: time.Unix(100, 0).UTC()}
+	n2.Latency.SmoothedRTT = time.Millisecond * 3 / 2
+
+	return &fakeNode{
+		identity:  rsnet.Identity{NodeName: "n1", Addr: "host1:1146", PublicKey: pub},
+		neighbors: []rsnet.NodeInfo{n2},
+		topology:  map[string]map[string]int{"n1": {"n2": 5}, "n2": {"n3": 7, "n1": 5}},
+		routes:    routes,
+		counters: rsnet.Counters{
+			AnnouncementsSent:   10,
+			RouteRecomputations: 2,
+			Dropped:             map[rsnet.DropReason]uint64{rsnet.DropReplay: 4, rsnet.DropNoRoute: 0},
+		},
+	}
+}
+
+func TestServer(t *testing.T) {
+	node := newFakeNode()
+	ts := httptest.NewServer(NewServer(node).Handler())
+	defer ts.Close()
+	c := NewClient(strings.TrimPrefix(ts.URL, "http://"))
+
+	id, err := c.Identity()
+	require.NoError(t, err)
+	assert.Equal(t, "n1", id.NodeName)
+	assert.Equal(t, rsnet.EncodePublicKey(node.identity.PublicKey), id.PublicKey)
+	assert.Equal(t, rsnet.Fingerprint(node.identity.PublicKey), id.Fingerprint)
+
+	neighbors, err := c.Neighbors()
+	require.NoError(t, err)
+	assert.Equal(t, []Neighbor{{NodeName: "n2", Addr: "host2:1146", LastSeen: time.Unix(100, 0).UTC(), RTT: 1.5}}, neighbors)
+
+	links, err := c.Topology()
+	require.NoError(t, err)
+	assert.Equal(t, []Link{{"n1", "n2", 5}, {"n2", "n1", 5}, {"n2", "n3", 7}}, links)
+
+	routes, err := c.Routes()
+	require.NoError(t, err)
+	require.Len(t, routes, 2)
+	assert.Equal(t, Route{Dest: "n3", NextHop: "n2", Cost: 12, Path: []string{"n1", "n2", "n3"}}, routes[1])
+
+	counters, err := c.Counters()
+	require.NoError(t, err)
+	assert.Equal(t, Counters{
+		AnnouncementsSent:   10,
+		RouteRecomputations: 2,
+		Dropped:             map[string]uint64{"replay": 4, "no_route": 0},
+	}, counters)
+}
+
+func TestServer_GetOnly(t *testing.T) {
+	ts := httptest.NewServer(NewServer(newFakeNode()).Handler())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/identity", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestServer_UnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rsec-net")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	addr := "unix:" + filepath.Join(dir, "admin.sock")
+
+	s := NewServer(newFakeNode())
+	done := make(chan error)
+	go func() {
+		done <- s.ListenAndServe(addr)
+	}()
+
+	c := NewClient(addr)
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := c.Identity()
+		if err == nil {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "server didn't start: %v", err)
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	require.NoError(t, s.Close())
+	assert.NoError(t, <-done)
+}
+
+func TestListen_UnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rsec-net")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "admin.sock")
+
+	l, err := listen("unix:" + path)
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// in use
+	_, err = listen("unix:" + path)
+	assert.Error(t, err)
+
+	// stale, left behind without being removed
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, l.Close())
+	l, err = listen("unix:" + path)
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	// anything other than a socket is left alone
+	keyPath := filepath.Join(dir, "identity.key")
+	require.NoError(t, ioutil.WriteFile(keyPath, []byte("key"), 0600))
+	_, err = listen("unix:" + keyPath)
+	assert.Error(t, err)
+	data, err := ioutil.ReadFile(keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, "key", string(data))
+}
+
+func TestServer_Metrics(t *testing.T) {
+	s := NewServer(newFakeNode())
+	s.transportStats = func() map[string]udp.Stats {
+		return map[string]udp.Stats{
+			"data":     {PacketsIn: 3, BytesIn: 300, PacketsOut: 2, BytesOut: 200, DecodeErrors: 1},
+			"announce": {PacketsIn: 5, BytesIn: 500, BadMAC: 2},
+		}
+	}
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, metricsContentType, resp.Header.Get("Content-Type"))
+
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	body := string(b)
+
+	for _, line := range []string{
+		"# TYPE rsec_net_announcements_sent_total counter",
+		"rsec_net_announcements_sent_total 10",
+		"rsec_net_route_recomputations_total 2",
+		`rsec_net_packets_total{tag="data",direction="in"} 3`,
+		`rsec_net_bytes_total{tag="data",direction="out"} 200`,
+		`rsec_net_decode_errors_total{tag="data"} 1`,
+		`rsec_net_dropped_packets_total{reason="replay"} 4`,
+		`rsec_net_dropped_packets_total{reason="bad_mac"} 2`,
+		"rsec_net_neighbors 1",
+		`rsec_net_neighbor_rtt_seconds{neighbor="n2"} 0.0015`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	assert.Equal(t, "", formatLabels(nil))
+	assert.Equal(t, `{a="1",b="x\\\"\n"}`, formatLabels([]string{"a", "1", "b", "x\\\"\n"}))
+}
+
+func TestServer_Ping(t *testing.T) {
+	ts := httptest.NewServer(NewServer(newFakeNode()).Handler())
+	defer ts.Close()
+	c := NewClient(strings.TrimPrefix(ts.URL, "http://"))
+
+	echo, err := c.Ping("n3", 16, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, Echo{From: "n3", Reached: true, Hops: 2, RTT: 2}, echo)
+
+	echo, err = c.Ping("n3", 1, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, Echo{From: "n2", Hops: 1, RTT: 1}, echo)
+
+	_, err = c.Ping("n4", 16, time.Millisecond*10)
+	assert.Equal(t, ErrTimedOut, err)
+
+	_, err = c.Ping("nowhere", 16, time.Second)
+	assert.EqualError(t, err, "404 Not Found: no route to node")
+
+	_, err = c.Ping("n3", 0, time.Second)
+	assert.Error(t, err)
+	_, err = c.Ping("n3", 16, time.Hour)
+	assert.Error(t, err)
+}
+
+func TestServer_Traceroute(t *testing.T) {
+	ts := httptest.NewServer(NewServer(newFakeNode()).Handler())
+	defer ts.Close()
+	c := NewClient(strings.TrimPrefix(ts.URL, "http://"))
+
+	trace, err := c.Traceroute("n3", 16, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, Trace{
+		Hops:      []Echo{{From: "n2", Hops: 1, RTT: 1}, {From: "n3", Reached: true, Hops: 2, RTT: 2}},
+		Predicted: []string{"n1", "n2", "n3"},
+	}, trace)
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package admin
+
+import "syscall"
+
+// restrictUmask makes files created until restore is called readable and writable by their owner only. The umask is
+// process wide, so files created by other goroutines in the meantime are too.
+func restrictUmask() (restore func()) {
+	old := syscall.Umask(0177)
+
+	return func() {
+		syscall.Umask(old)
+	}
+}
@@ -0,0 +1,6 @@
+package admin
+
+// restrictUmask does nothing, since Windows has no umask
+func restrictUmask() (restore func()) {
+	return func() {}
+}
@@ -61,9 +61,20 @@ type DijkstraSearcher struct {
 
 // ShortestPath calculates the shortest path (by cost) from start to end.
 func (d *DijkstraSearcher) ShortestPath(graph *DirectedGraph, startKey, targetKey string) []*Node {
-	path := []*Node{}
-	if startKey == targetKey {
-		return path
+	path, ok := d.ShortestPaths(graph, startKey)[targetKey]
+	if !ok {
+		return []*Node{}
+	}
+
+	return path
+}
+
+// ShortestPaths calculates the shortest path (by cost) from start to every node reachable from it,
+// keyed by the node the path ends at. Searches once, rather than once per node like ShortestPath.
+func (d *DijkstraSearcher) ShortestPaths(graph *DirectedGraph, startKey string) map[string][]*Node {
+	paths := make(map[string][]*Node)
+	if _, ok := graph.adjList[startKey]; !ok {
+		return paths
 	}
 
 	pendingNodes := make(map[string]struct{})
@@ -72,60 +83,48 @@ func (d *DijkstraSearcher) ShortestPath(graph *DirectedGraph, startKey, targetKe
 
 	for vertexKey := range graph.adjList {
 		distanceTo[vertexKey] = math.MaxInt32
-		prevHop[vertexKey] = nil
 		pendingNodes[vertexKey] = struct{}{}
 	}
 
 	distanceTo[startKey] = 0
 
 	for len(pendingNodes) > 0 {
-		// u ← vertex in Q with min dist[u]
 		minDistance := math.MaxInt32
 		var minDistanceKey string
 
 		for vertexKey := range pendingNodes {
-			if distance := distanceTo[vertexKey]; distance <= minDistance {
+			// ties are broken by key, so paths of equal cost are chosen the same way every time
+			distance := distanceTo[vertexKey]
+			if distance < minDistance || distance == minDistance && vertexKey < minDistanceKey {
 				minDistance = distance
 				minDistanceKey = vertexKey
 			}
 		}
 
-		// remove u from Q
-		delete(pendingNodes, minDistanceKey)
-
-		if minDistanceKey == targetKey {
-			tmp, err := graph.GetNode(targetKey)
-			if err != nil {
-				panic(err)
-			}
-
-			if prevHop[targetKey] != nil || tmp.Key == startKey {
-				for tmp != nil {
-					path = append([]*Node{tmp}, path...)
-					tmp = prevHop[tmp.Key]
-				}
-			}
-
-			return path
+		if minDistance == math.MaxInt32 {
+			// the rest are unreachable
+			break
 		}
+		delete(pendingNodes, minDistanceKey)
 
-		edges := graph.adjList[minDistanceKey].edges
-		for _, e := range edges {
+		for _, e := range graph.adjList[minDistanceKey].edges {
 			candidateDistance := minDistance + e.Cost
 
 			k := e.Dest.Key
 			if candidateDistance < distanceTo[k] {
 				distanceTo[k] = candidateDistance
-				node, err := graph.GetNode(minDistanceKey)
-				if err != nil {
-					// this will never happen..
-					panic(err)
-				}
-
-				prevHop[k] = node
+				prevHop[k] = graph.adjList[minDistanceKey].start
 			}
 		}
 	}
 
-	return path
+	for key, prev := range prevHop {
+		path := []*Node{graph.adjList[key].start}
+		for ; prev != nil; prev = prevHop[prev.Key] {
+			path = append([]*Node{prev}, path...)
+		}
+		paths[key] = path
+	}
+
+	return paths
 }
@@ -217,3 +217,59 @@ func TestDijkstraSearcher_ShortestPath_NoPath(t *testing.T) {
 	result := searcher.ShortestPath(g, "n1", "n3")
 	assert.Equal(t, []*Node{}, result)
 }
+
+func TestDijkstraSearcher_ShortestPaths(t *testing.T) {
+	n1 := &Node{"n1", nil}
+	n2 := &Node{"n2", nil}
+	n3 := &Node{"n3", nil}
+	n4 := &Node{"n4", nil}
+	n5 := &Node{"n5", nil}
+
+	g, err := NewDirectedGraphChain().
+		AddNode(n1).
+		AddNode(n2).
+		AddNode(n3).
+		AddNode(n4).
+		AddNode(n5).
+		AddEdge("n1", "n2", 2).
+		AddEdge("n1", "n3", 5).
+		AddEdge("n2", "n3", 1).
+		AddEdge("n3", "n4", 1).
+		AddEdge("n5", "n1", 1).
+		DirectedGraph()
+	assert.NoError(t, err)
+
+	searcher := DijkstraSearcher{}
+	result := searcher.ShortestPaths(g, "n1")
+	assert.Equal(t, map[string][]*Node{
+		"n2": {n1, n2},
+		"n3": {n1, n2, n3},
+		"n4": {n1, n2, n3, n4},
+	}, result)
+
+	assert.Equal(t, map[string][]*Node{}, searcher.ShortestPaths(g, "n6"))
+}
+
+func TestDijkstraSearcher_ShortestPath_Tie(t *testing.T) {
+	n1 := &Node{"n1", nil}
+	n2 := &Node{"n2", nil}
+	n3 := &Node{"n3", nil}
+	n4 := &Node{"n4", nil}
+
+	g, err := NewDirectedGraphChain().
+		AddNode(n1).
+		AddNode(n2).
+		AddNode(n3).
+		AddNode(n4).
+		AddEdge("n1", "n3", 1).
+		AddEdge("n1", "n2", 1).
+		AddEdge("n3", "n4", 1).
+		AddEdge("n2", "n4", 1).
+		DirectedGraph()
+	assert.NoError(t, err)
+
+	searcher := DijkstraSearcher{}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, []*Node{n1, n2, n4}, searcher.ShortestPath(g, "n1", "n4"))
+	}
+}
@@ -61,7 +61,17 @@ func (d *DirectedGraph) RemoveNode(key string) error {
 	return nil
 }
 
-// AddNode adds a new node to the graph.
+// Keys returns the keys of every node in the graph.
+func (d *DirectedGraph) Keys() []string {
+	keys := make([]string, 0, len(d.adjList))
+	for k := range d.adjList {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// GetNode returns the node with the given key.
 func (d *DirectedGraph) GetNode(key string) (*Node, error) {
 	if n, ok := d.adjList[key]; !ok {
 		return nil, errors.New("node with key not in graph")
@@ -145,3 +145,17 @@ func TestDirectedGraph_GetEdgeCost(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 5, cost)
 }
+
+func TestDirectedGraph_Keys(t *testing.T) {
+	n1 := Node{"n1", nil}
+	n2 := Node{"n2", nil}
+
+	graph := &DirectedGraph{
+		adjList: map[string]value{
+			n1.Key: {&n1, []edge{{&n2, 5}}},
+			n2.Key: {&n2, []edge{}},
+		},
+	}
+
+	assert.ElementsMatch(t, []string{"n1", "n2"}, graph.Keys())
+}
@@ -2,4 +2,5 @@ package graph
 
 type Searcher interface {
 	ShortestPath(graph *DirectedGraph, startKey, targetKey string) []*Node
+	ShortestPaths(graph *DirectedGraph, startKey string) map[string][]*Node
 }
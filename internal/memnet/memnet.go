@@ -0,0 +1,453 @@
+// Package memnet is an in-process transport implementing udp.NetReader and udp.NetWriter, for tests and
+// simulation. Readers and writers are attached to a Switch, which delivers datagrams between them over
+// links which may drop, delay and reorder them. Addresses are arbitrary host:port strings, and links
+// connect hosts, so a link applies to every port of the hosts it connects.
+package memnet
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// readQueueSize is how many datagrams a reader buffers before dropping more, like a socket's receive buffer
+	readQueueSize = 1024
+	// linkQueueSize is how many datagrams a link holds in flight before dropping more
+	linkQueueSize = 1024
+	// maxReorderHold is how long a datagram held back to be reordered waits for the next one on its link
+	maxReorderHold = time.Millisecond * 50
+	// maxBacklog is how far behind a bandwidth limited link may fall before datagrams are dropped
+	maxBacklog = time.Millisecond * 500
+)
+
+// ErrAddrInUse is returned by StartReceiving if another reader is receiving on the same address
+var ErrAddrInUse = errors.New("address already in use")
+
+// Clock tells the time, and times link delays. net.Clock satisfies it.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Link configures the datagrams sent from one host to another
+type Link struct {
+	// Loss is the fraction of datagrams dropped, from 0 to 1
+	Loss float64
+	// Delay is how long datagrams take to arrive
+	Delay time.Duration
+	// Reorder is the fraction of datagrams held back, and delivered after the next datagram on the link
+	Reorder float64
+	// Bandwidth caps the link, in bytes per second. Datagrams queue behind each other, and are dropped once
+	// the queue is too long. Zero is unlimited.
+	Bandwidth int
+}
+
+// Switch delivers datagrams between the readers and writers attached to it
+type Switch struct {
+	mu      sync.Mutex
+	clock   Clock
+	rand    *rand.Rand
+	readers map[string]*Reader
+	// groups holds the readers joined to each multicast group, keyed by group and then reader address
+	groups map[string]map[string]*Reader
+
+	defaultLink Link
+	links       map[hostPair]Link
+	pipes       map[hostPair]*pipe
+
+	// inFlight holds the datagrams which haven't been handled or dropped yet
+	inFlight map[*datagram]struct{}
+
+	closed chan bool
+}
+
+// hostPair is the direction of a link, from one host to another
+type hostPair struct {
+	from, to string
+}
+
+// pipe carries the datagrams in flight on a link, in the order they were written
+type pipe struct {
+	datagrams chan *datagram
+	// lastDue is when the last datagram written is due. Datagrams are never due before the ones ahead of them.
+	lastDue time.Time
+	// busyUntil is when the link finishes sending the datagrams queued on it, if it has a bandwidth cap
+	busyUntil time.Time
+}
+
+type datagram struct {
+	frame []byte
+	to    string
+	// due is when the datagram is delivered, or when it is let go if it is held back to be reordered
+	due     time.Time
+	reorder bool
+}
+
+// NewSwitch creates a switch with perfect links. Random drops and reordering are drawn from a source
+// seeded with seed, so they are the same on every run which writes the same datagrams in the same order.
+func NewSwitch(seed int64) *Switch {
+	return &Switch{
+		clock:    systemClock{},
+		rand:     rand.New(rand.NewSource(seed)),
+		readers:  make(map[string]*Reader),
+		groups:   make(map[string]map[string]*Reader),
+		links:    make(map[hostPair]Link),
+		pipes:    make(map[hostPair]*pipe),
+		inFlight: make(map[*datagram]struct{}),
+		closed:   make(chan bool),
+	}
+}
+
+// SetClock makes the switch time link delays with the clock. Must be called before any datagrams are sent.
+func (s *Switch) SetClock(clock Clock) {
+	s.clock = clock
+}
+
+// Pending returns the number of datagrams which are due, but haven't been received from their reader or dropped yet.
+// Datagrams delayed by their link, or held back to be reordered, don't count until they are due.
+func (s *Switch) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	pending := 0
+	for d := range s.inFlight {
+		if !d.due.After(now) {
+			pending++
+		}
+	}
+
+	return pending
+}
+
+// handled forgets about a datagram, once it has been received or dropped
+func (s *Switch) handled(d *datagram) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.inFlight, d)
+}
+
+// setDue changes when a datagram is due
+func (s *Switch) setDue(d *datagram, due time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d.due = due
+}
+
+// SetLink configures the link from one host to another. Links are one way.
+func (s *Switch) SetLink(from, to string, link Link) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.links[hostPair{from, to}] = link
+}
+
+// SetDefaultLink configures every link which hasn't been configured with SetLink
+func (s *Switch) SetDefaultLink(link Link) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.defaultLink = link
+}
+
+// Close stops delivering datagrams. Datagrams still in flight are dropped.
+func (s *Switch) Close() {
+	close(s.closed)
+}
+
+// NewReader creates a reader receiving datagrams written to addr (host:port)
+func (s *Switch) NewReader(addr string) *Reader {
+	return &Reader{sw: s, addr: addr}
+}
+
+// NewMulticastReader creates a reader receiving datagrams written to addr (host:port),
+// and joined to the group (host:port), receiving every datagram written to it.
+func (s *Switch) NewMulticastReader(group, addr string) *Reader {
+	return &Reader{sw: s, addr: addr, group: group}
+}
+
+// NewWriter creates a writer on the host from, writing to addr (host:port), which may be a multicast group
+func (s *Switch) NewWriter(from, addr string) *Writer {
+	return &Writer{sw: s, from: from, addr: addr}
+}
+
+// send passes the frame to the link to every reader at the address, or joined to the group at the address
+func (s *Switch) send(from, addr string, frame []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var to []string
+	if _, ok := s.readers[addr]; ok {
+		to = append(to, addr)
+	}
+	for readerAddr := range s.groups[addr] {
+		if readerAddr != addr {
+			to = append(to, readerAddr)
+		}
+	}
+	// sorted, so random draws are the same on every run
+	sort.Strings(to)
+
+	for _, readerAddr := range to {
+		pair := hostPair{from, host(readerAddr)}
+		link, ok := s.links[pair]
+		if !ok {
+			link = s.defaultLink
+		}
+
+		if s.rand.Float64() < link.Loss {
+			continue
+		}
+		p, ok := s.pipes[pair]
+		if !ok {
+			p = &pipe{datagrams: make(chan *datagram, linkQueueSize)}
+			s.pipes[pair] = p
+			go s.carry(p)
+		}
+
+		now := s.clock.Now()
+		d := &datagram{
+			frame:   frame,
+			to:      readerAddr,
+			due:     now.Add(link.Delay),
+			reorder: s.rand.Float64() < link.Reorder,
+		}
+		if link.Bandwidth > 0 {
+			start := p.busyUntil
+			if start.Before(now) {
+				start = now
+			}
+			if start.Sub(now) > maxBacklog {
+				log.Debug().Str("from", from).Str("to", readerAddr).Msg("memnet link backlogged, dropped datagram")
+				continue
+			}
+
+			p.busyUntil = start.Add(time.Duration(len(frame)) * time.Second / time.Duration(link.Bandwidth))
+			d.due = p.busyUntil.Add(link.Delay)
+		}
+		if d.due.Before(p.lastDue) {
+			d.due = p.lastDue
+		}
+		select {
+		case p.datagrams <- d:
+			p.lastDue = d.due
+			s.inFlight[d] = struct{}{}
+		default:
+			log.Debug().Str("from", from).Str("to", readerAddr).Msg("memnet link full, dropped datagram")
+		}
+	}
+}
+
+// carry delivers the datagrams on a link once they are due, until the switch is closed
+func (s *Switch) carry(p *pipe) {
+	var held *datagram
+	var flush <-chan time.Time
+
+	for {
+		select {
+		case d := <-p.datagrams:
+			if wait := d.due.Sub(s.clock.Now()); wait > 0 {
+				select {
+				case <-s.clock.After(wait):
+				case <-s.closed:
+					return
+				}
+			}
+
+			if d.reorder && held == nil {
+				// held until the next datagram, or the flush, whichever is first
+				s.setDue(d, s.clock.Now().Add(maxReorderHold))
+				held = d
+				flush = s.clock.After(maxReorderHold)
+				continue
+			}
+
+			s.deliver(d)
+			if held != nil {
+				s.setDue(held, s.clock.Now())
+				s.deliver(held)
+				held, flush = nil, nil
+			}
+		case <-flush:
+			s.deliver(held)
+			held, flush = nil, nil
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+func (s *Switch) deliver(d *datagram) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.readers[d.to]
+	if !ok {
+		// stopped receiving while the datagram was in flight
+		delete(s.inFlight, d)
+		return
+	}
+
+	select {
+	case r.queue <- d:
+	default:
+		delete(s.inFlight, d)
+		log.Debug().Str("addr", d.to).Msg("memnet reader full, dropped datagram")
+	}
+}
+
+func (s *Switch) attach(r *Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.readers[r.addr]; ok {
+		return ErrAddrInUse
+	}
+	s.readers[r.addr] = r
+
+	if r.group != "" {
+		if s.groups[r.group] == nil {
+			s.groups[r.group] = make(map[string]*Reader)
+		}
+		s.groups[r.group][r.addr] = r
+	}
+
+	return nil
+}
+
+// detach stops deliveries to the reader. Datagrams already queued for it are dropped.
+func (s *Switch) detach(r *Reader) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.readers, r.addr)
+	if r.group != "" {
+		delete(s.groups[r.group], r.addr)
+	}
+}
+
+// Reader implements udp.NetReader for a Switch
+type Reader struct {
+	sw    *Switch
+	addr  string
+	group string
+
+	queue    chan *datagram
+	stopChan chan bool
+	done     chan bool
+}
+
+// StartReceiving attaches the reader to the switch, and returns a channel which will yield messages when they arrive
+func (r *Reader) StartReceiving(tag string) (<-chan interface{}, error) {
+	r.queue = make(chan *datagram, readQueueSize)
+	r.stopChan = make(chan bool)
+	r.done = make(chan bool)
+	if err := r.sw.attach(r); err != nil {
+		return nil, err
+	}
+
+	dataChan := make(chan interface{})
+	go func() {
+		defer close(r.done)
+		defer close(dataChan)
+
+		for {
+			select {
+			case d := <-r.queue:
+				data, err := udp.Unmarshal(d.frame)
+				if err != nil {
+					r.sw.handled(d)
+					log.Error().Err(err).Msg("Read failure")
+					continue
+				}
+
+				select {
+				case dataChan <- data:
+					r.sw.handled(d)
+				case <-r.stopChan:
+					r.sw.handled(d)
+					return
+				}
+			case <-r.stopChan:
+				return
+			}
+		}
+	}()
+
+	return dataChan, nil
+}
+
+// StopReceiving detaches the reader from the switch, and closes the channel returned by StartReceiving
+func (r *Reader) StopReceiving() {
+	r.sw.detach(r)
+	close(r.stopChan)
+	<-r.done
+
+	for {
+		select {
+		case d := <-r.queue:
+			r.sw.handled(d)
+		default:
+			return
+		}
+	}
+}
+
+// ReadAddr returns the address being read from (host:port)
+func (r *Reader) ReadAddr() string {
+	return r.addr
+}
+
+// Writer implements udp.NetWriter for a Switch
+type Writer struct {
+	sw   *Switch
+	from string
+	addr string
+}
+
+// Write encodes the message and sends it over the switch
+func (w *Writer) Write(data interface{}) error {
+	frame, err := udp.Marshal(data)
+	if err != nil {
+		log.Error().Err(err).Msg("Encode failure")
+		return err
+	}
+
+	w.sw.send(w.from, w.addr, frame)
+
+	return nil
+}
+
+// WriteAddr returns the address written to
+func (w *Writer) WriteAddr() string {
+	return w.addr
+}
+
+// host returns the host of an address, or the whole address if it has no port
+func host(addr string) string {
+	h, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return h
+}
@@ -0,0 +1,235 @@
+package memnet
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type msg struct {
+	N uint32
+}
+
+const testWireType = 201
+
+func (m msg) WireType() uint8 {
+	return testWireType
+}
+
+func (m msg) MarshalBinary() ([]byte, error) {
+	var e udp.Encoder
+	e.PutUint32(m.N)
+
+	return e.Encoded(), nil
+}
+
+func init() {
+	udp.Register(testWireType, func(payload []byte) (interface{}, error) {
+		d := udp.NewDecoder(payload)
+		m := msg{d.GetUint32()}
+
+		return m, d.Err()
+	})
+}
+
+func startReader(t *testing.T, r *Reader) <-chan interface{} {
+	c, err := r.StartReceiving("test")
+	require.NoError(t, err)
+
+	return c
+}
+
+// receive reads messages until none arrive for a while
+func receive(c <-chan interface{}) []uint32 {
+	var got []uint32
+	for {
+		select {
+		case m := <-c:
+			got = append(got, m.(msg).N)
+		case <-time.After(time.Millisecond * 200):
+			return got
+		}
+	}
+}
+
+func writeN(t *testing.T, w *Writer, n int) {
+	for i := 0; i < n; i++ {
+		require.NoError(t, w.Write(msg{uint32(i)}))
+	}
+}
+
+func TestSwitch_Unicast(t *testing.T) {
+	s := NewSwitch(1)
+	defer s.Close()
+
+	b := s.NewReader("b:1")
+	bc := startReader(t, b)
+	defer b.StopReceiving()
+	c := s.NewReader("c:1")
+	cc := startReader(t, c)
+	defer c.StopReceiving()
+
+	w := s.NewWriter("a", "b:1")
+	assert.Equal(t, "b:1", w.WriteAddr())
+	writeN(t, w, 3)
+
+	assert.Equal(t, []uint32{0, 1, 2}, receive(bc))
+	assert.Empty(t, receive(cc))
+}
+
+func TestSwitch_Multicast(t *testing.T) {
+	s := NewSwitch(1)
+	defer s.Close()
+
+	var chans []<-chan interface{}
+	for _, addr := range []string{"a:1", "b:1", "c:1"} {
+		r := s.NewMulticastReader("group:1", addr)
+		chans = append(chans, startReader(t, r))
+		defer r.StopReceiving()
+	}
+
+	writeN(t, s.NewWriter("a", "group:1"), 2)
+
+	// including the writer's own host, like multicast loopback
+	for _, c := range chans {
+		assert.Equal(t, []uint32{0, 1}, receive(c))
+	}
+}
+
+func TestSwitch_Loss(t *testing.T) {
+	s := NewSwitch(1)
+	defer s.Close()
+	s.SetLink("a", "b", Link{Loss: 1})
+	s.SetLink("c", "b", Link{Loss: 0.5})
+
+	r := s.NewReader("b:1")
+	rc := startReader(t, r)
+	defer r.StopReceiving()
+
+	writeN(t, s.NewWriter("a", "b:1"), 10)
+	assert.Empty(t, receive(rc))
+
+	writeN(t, s.NewWriter("c", "b:1"), 200)
+	got := len(receive(rc))
+	assert.True(t, got > 70 && got < 130, "received %d of 200", got)
+
+	// links are one way, and unconfigured ones use the default
+	s.SetDefaultLink(Link{Loss: 1})
+	writeN(t, s.NewWriter("b", "b:1"), 10)
+	assert.Empty(t, receive(rc))
+}
+
+func TestSwitch_Delay(t *testing.T) {
+	s := NewSwitch(1)
+	defer s.Close()
+	s.SetLink("a", "b", Link{Delay: time.Millisecond * 100})
+
+	r := s.NewReader("b:1")
+	rc := startReader(t, r)
+	defer r.StopReceiving()
+
+	start := time.Now()
+	writeN(t, s.NewWriter("a", "b:1"), 1)
+	<-rc
+	assert.True(t, time.Since(start) >= time.Millisecond*100)
+}
+
+func TestSwitch_Bandwidth(t *testing.T) {
+	s := NewSwitch(1)
+	defer s.Close()
+	// a 13 byte frame takes 10ms, so only the first half second of datagrams are queued
+	s.SetLink("a", "b", Link{Bandwidth: 1300})
+
+	r := s.NewReader("b:1")
+	rc := startReader(t, r)
+	defer r.StopReceiving()
+
+	writeN(t, s.NewWriter("a", "b:1"), 100)
+	got := receive(rc)
+	assert.True(t, len(got) > 45 && len(got) < 55, "received %d of 100", len(got))
+	assert.True(t, sort.SliceIsSorted(got, func(i, j int) bool { return got[i] < got[j] }))
+}
+
+func TestSwitch_Reorder(t *testing.T) {
+	s := NewSwitch(1)
+	defer s.Close()
+	s.SetLink("a", "b", Link{Reorder: 0.3})
+
+	r := s.NewReader("b:1")
+	rc := startReader(t, r)
+	defer r.StopReceiving()
+
+	writeN(t, s.NewWriter("a", "b:1"), 100)
+	got := receive(rc)
+	require.Len(t, got, 100)
+	assert.False(t, sort.SliceIsSorted(got, func(i, j int) bool { return got[i] < got[j] }))
+
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	for i, n := range got {
+		assert.Equal(t, uint32(i), n)
+	}
+}
+
+// pendingBecomes waits for the switch to have n datagrams pending
+func pendingBecomes(t *testing.T, s *Switch, n int) {
+	deadline := time.Now().Add(time.Second)
+	for s.Pending() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d datagrams pending, expected %d", s.Pending(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSwitch_Pending(t *testing.T) {
+	s := NewSwitch(1)
+	defer s.Close()
+	s.SetLink("a", "b", Link{Delay: time.Millisecond * 50})
+
+	r := s.NewReader("b:1")
+	rc := startReader(t, r)
+
+	// delayed datagrams aren't pending until they are due
+	writeN(t, s.NewWriter("a", "b:1"), 2)
+	assert.Equal(t, 0, s.Pending())
+	pendingBecomes(t, s, 2)
+
+	// and are pending until they are received
+	<-rc
+	pendingBecomes(t, s, 1)
+	<-rc
+	pendingBecomes(t, s, 0)
+
+	// or forgotten once the reader stops
+	writeN(t, s.NewWriter("c", "b:1"), 1)
+	pendingBecomes(t, s, 1)
+	r.StopReceiving()
+	pendingBecomes(t, s, 0)
+}
+
+func TestReader_StartStop(t *testing.T) {
+	s := NewSwitch(1)
+	defer s.Close()
+
+	r := s.NewReader("b:1")
+	assert.Equal(t, "b:1", r.ReadAddr())
+	rc := startReader(t, r)
+
+	_, err := s.NewReader("b:1").StartReceiving("test")
+	assert.Equal(t, ErrAddrInUse, err)
+
+	r.StopReceiving()
+	_, ok := <-rc
+	assert.False(t, ok)
+
+	// the address is free again, and nothing is delivered to the stopped reader
+	r2 := s.NewReader("b:1")
+	rc2 := startReader(t, r2)
+	defer r2.StopReceiving()
+	writeN(t, s.NewWriter("a", "b:1"), 1)
+	assert.Equal(t, []uint32{0}, receive(rc2))
+}
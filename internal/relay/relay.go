@@ -0,0 +1,436 @@
+// Package relay forwards announcements between nodes which can't multicast to each other, such as containers,
+// along the links of a scenario. Data for nodes may be relayed too. Links delay, drop and rate limit datagrams,
+// so a multi-hop mesh can be emulated on a network where every node can reach every other.
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/sim"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxDatagramSize is the largest datagram relayed
+	maxDatagramSize = 65535
+	// maxBacklog is how far behind a bandwidth limited link may fall before datagrams are dropped
+	maxBacklog = time.Millisecond * 500
+	// resolveInterval limits how often node addresses are resolved again, when a datagram comes from an unknown source
+	resolveInterval = time.Second
+)
+
+// ErrClosed is returned by Serve once the relay is closed
+var ErrClosed = errors.New("relay closed")
+
+// Config places the nodes of a scenario on the network, so the relay can emulate the links between them
+type Config struct {
+	// Nodes holds where each node is, keyed by node name
+	Nodes map[string]NodeConfig
+	// Links are the scenario's links. Their latency, loss and bandwidth are emulated; cost and reorder aren't.
+	Links []sim.ScenarioLink
+}
+
+// NodeConfig is where a node is
+type NodeConfig struct {
+	// Addr is the host:port datagrams for the node are sent to, its announce listen address
+	Addr string
+	// Source identifies datagrams from the node, by host or host:port. Defaults to the host of Addr.
+	// With a port, only datagrams from that port are identified, so data sent from another port can't be relayed.
+	Source string
+	// DataAddr is the host:port the node receives data on. If set, data and probes for the node are relayed too.
+	DataAddr string
+	// RelayDataPort is the port the relay receives data for the node on, which the node must advertise as its
+	// data address, with the relay's host. Defaults to the port of DataAddr.
+	RelayDataPort string
+}
+
+// NewConfig places the nodes of a scenario on hosts with the same names, listening for announcements on
+// announcePort. hosts overrides the host of nodes, sources sets their Source, and dataPorts relays data to
+// nodes listening on the port, all keyed by node name.
+func NewConfig(sc *sim.Scenario, announcePort string, hosts, sources, dataPorts map[string]string) (*Config, error) {
+	c := &Config{Nodes: make(map[string]NodeConfig), Links: sc.Links}
+
+	nodeNames := append([]string{}, sc.Nodes...)
+	for _, e := range sc.Events {
+		if e.Type == sim.EventNodeStart {
+			nodeNames = append(nodeNames, e.Node)
+		}
+	}
+	for _, nodeName := range nodeNames {
+		host, ok := hosts[nodeName]
+		if !ok {
+			host = nodeName
+		}
+
+		n := NodeConfig{
+			Addr:   net.JoinHostPort(host, announcePort),
+			Source: sources[nodeName],
+		}
+		if port, ok := dataPorts[nodeName]; ok {
+			n.DataAddr = net.JoinHostPort(host, port)
+		}
+		c.Nodes[nodeName] = n
+	}
+
+	for _, overrides := range []map[string]string{hosts, sources, dataPorts} {
+		for nodeName := range overrides {
+			if _, ok := c.Nodes[nodeName]; !ok {
+				return nil, fmt.Errorf("unknown node %s", nodeName)
+			}
+		}
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	dataPorts := make(map[string]string)
+	for nodeName, n := range c.Nodes {
+		if _, _, err := net.SplitHostPort(n.Addr); err != nil {
+			return fmt.Errorf("node %s: %v", nodeName, err)
+		}
+		if n.DataAddr == "" {
+			continue
+		}
+
+		port, err := n.relayDataPort()
+		if err != nil {
+			return fmt.Errorf("node %s: %v", nodeName, err)
+		}
+		if other, ok := dataPorts[port]; ok && port != "0" {
+			return fmt.Errorf("nodes %s and %s have the same data port %s", other, nodeName, port)
+		}
+		dataPorts[port] = nodeName
+	}
+
+	for _, l := range c.Links {
+		for _, nodeName := range l.Between {
+			if _, ok := c.Nodes[nodeName]; !ok {
+				return fmt.Errorf("link %v: unknown node %s", l.Between, nodeName)
+			}
+		}
+	}
+
+	return nil
+}
+
+// relayDataPort returns the port the relay receives data for the node on
+func (n NodeConfig) relayDataPort() (string, error) {
+	if n.RelayDataPort != "" {
+		return n.RelayDataPort, nil
+	}
+	_, port, err := net.SplitHostPort(n.DataAddr)
+
+	return port, err
+}
+
+// Relay receives datagrams from nodes, and forwards them to the nodes they have links to
+type Relay struct {
+	// conn receives announcements, which are forwarded to every node the sender has a link to
+	conn *net.UDPConn
+	// dataConns receive data for each node, keyed by node name, which is forwarded to that node if the sender has
+	// a link to it
+	dataConns map[string]*net.UDPConn
+	config    *Config
+	// links holds the links from each node, keyed by node name and then by the node at the other end
+	links map[string]map[string]*link
+
+	mu sync.Mutex
+	// sources maps resolved source addresses to node names. Sources with a port are keyed by ip:port, others by ip.
+	sources      map[string]string
+	lastResolved time.Time
+	// addrs caches the resolved addresses of nodes
+	addrs  map[string]*net.UDPAddr
+	rand   *rand.Rand
+	closed bool
+}
+
+// link is one way of a configured link
+type link struct {
+	from, to string
+	config   sim.ScenarioLink
+
+	mu sync.Mutex
+	// busyUntil is when the link finishes sending the datagrams queued on it, if it has a bandwidth cap
+	busyUntil time.Time
+}
+
+// New creates a relay listening for announcements on listenAddr, host:port, and for data on the same host
+func New(config *Config, listenAddr string) (*Relay, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	host, _, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := listen(listenAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Relay{
+		conn:      conn,
+		dataConns: make(map[string]*net.UDPConn),
+		config:    config,
+		links:     make(map[string]map[string]*link),
+		sources:   make(map[string]string),
+		addrs:     make(map[string]*net.UDPAddr),
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for nodeName, n := range config.Nodes {
+		r.links[nodeName] = make(map[string]*link)
+		if n.DataAddr == "" {
+			continue
+		}
+
+		port, _ := n.relayDataPort()
+		dataConn, err := listen(net.JoinHostPort(host, port))
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("node %s: %v", nodeName, err)
+		}
+		r.dataConns[nodeName] = dataConn
+	}
+	for _, l := range config.Links {
+		a, b := l.Between[0], l.Between[1]
+		r.links[a][b] = &link{from: a, to: b, config: l}
+		r.links[b][a] = &link{from: b, to: a, config: l}
+	}
+	r.resolveSources()
+
+	return r, nil
+}
+
+func listen(addr string) (*net.UDPConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.ListenUDP("udp", udpAddr)
+}
+
+// Addr returns the address the relay is listening for announcements on
+func (r *Relay) Addr() net.Addr {
+	return r.conn.LocalAddr()
+}
+
+// DataAddr returns the address the relay is listening for data for the node on, or nil if its data isn't relayed
+func (r *Relay) DataAddr(nodeName string) net.Addr {
+	conn, ok := r.dataConns[nodeName]
+	if !ok {
+		return nil
+	}
+
+	return conn.LocalAddr()
+}
+
+// Serve relays datagrams until the relay is closed, and then returns ErrClosed
+func (r *Relay) Serve() error {
+	errs := make(chan error, len(r.dataConns)+1)
+	go func() {
+		errs <- r.serve(r.conn, "")
+	}()
+	for nodeName, conn := range r.dataConns {
+		nodeName, conn := nodeName, conn
+		go func() {
+			errs <- r.serve(conn, nodeName)
+		}()
+	}
+
+	err := <-errs
+	if err != ErrClosed {
+		// stop the other sockets too
+		r.Close()
+	}
+
+	return err
+}
+
+// serve relays the datagrams received on conn to the node to, or if to is empty, to every node the sender has
+// a link to. Returns ErrClosed once the relay is closed.
+func (r *Relay) serve(conn *net.UDPConn, to string) error {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			r.mu.Lock()
+			closed := r.closed
+			r.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+
+			return err
+		}
+
+		nodeName, ok := r.source(from)
+		if !ok {
+			log.Debug().Str("from", from.String()).Msg("Dropping datagram from unknown source")
+			continue
+		}
+
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		if to == "" {
+			for _, l := range r.links[nodeName] {
+				r.forward(l, r.conn, r.config.Nodes[l.to].Addr, data)
+			}
+			continue
+		}
+
+		l, ok := r.links[nodeName][to]
+		if !ok {
+			log.Debug().Str("from", nodeName).Str("to", to).Msg("Dropping data between nodes without a link")
+			continue
+		}
+		r.forward(l, conn, r.config.Nodes[to].DataAddr, data)
+	}
+}
+
+// Close stops the relay. Datagrams delayed on links are dropped.
+func (r *Relay) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+
+	for _, conn := range r.dataConns {
+		conn.Close()
+	}
+
+	return r.conn.Close()
+}
+
+// source returns the node a datagram came from
+func (r *Relay) source(from *net.UDPAddr) (string, bool) {
+	r.mu.Lock()
+	nodeName, ok := r.lookupSource(from)
+	stale := time.Since(r.lastResolved) >= resolveInterval
+	r.mu.Unlock()
+	if ok || !stale {
+		return nodeName, ok
+	}
+
+	// nodes may have started, and become resolvable, since we last looked
+	r.resolveSources()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.lookupSource(from)
+}
+
+// lookupSource must be called with the lock held
+func (r *Relay) lookupSource(from *net.UDPAddr) (string, bool) {
+	if nodeName, ok := r.sources[from.String()]; ok {
+		return nodeName, true
+	}
+	nodeName, ok := r.sources[from.IP.String()]
+
+	return nodeName, ok
+}
+
+// resolveSources resolves the source of every node to its addresses
+func (r *Relay) resolveSources() {
+	sources := make(map[string]string)
+	for nodeName, n := range r.config.Nodes {
+		source := n.Source
+		if source == "" {
+			source, _, _ = net.SplitHostPort(n.Addr)
+		}
+
+		host, port, err := net.SplitHostPort(source)
+		if err != nil {
+			host, port = source, ""
+		}
+
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			log.Debug().Err(err).Str("nodeName", nodeName).Msg("Unable to resolve node")
+			continue
+		}
+		for _, ip := range ips {
+			key := ip.String()
+			if port != "" {
+				key = net.JoinHostPort(key, port)
+			}
+			sources[key] = nodeName
+		}
+	}
+
+	r.mu.Lock()
+	r.sources = sources
+	r.lastResolved = time.Now()
+	r.mu.Unlock()
+}
+
+// forward sends a datagram along a link to addr, from conn, once it has been delayed by the link's latency and
+// bandwidth. It may be dropped instead, at random or if the link is too far behind.
+func (r *Relay) forward(l *link, conn *net.UDPConn, addr string, data []byte) {
+	r.mu.Lock()
+	lost := l.config.Loss > 0 && r.rand.Float64() < l.config.Loss
+	r.mu.Unlock()
+	if lost {
+		return
+	}
+
+	l.mu.Lock()
+	delay := l.config.Latency
+	if l.config.Bandwidth > 0 {
+		now := time.Now()
+		start := l.busyUntil
+		if start.Before(now) {
+			start = now
+		}
+		if start.Sub(now) > maxBacklog {
+			l.mu.Unlock()
+			log.Debug().Str("from", l.from).Str("to", l.to).Msg("Link backlogged, dropping datagram")
+			return
+		}
+
+		l.busyUntil = start.Add(time.Duration(len(data)) * time.Second / time.Duration(l.config.Bandwidth))
+		delay += l.busyUntil.Sub(now)
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		r.send(l, conn, addr, data)
+		return
+	}
+	time.AfterFunc(delay, func() {
+		r.send(l, conn, addr, data)
+	})
+}
+
+func (r *Relay) send(l *link, conn *net.UDPConn, addr string, data []byte) {
+	r.mu.Lock()
+	udpAddr, ok := r.addrs[addr]
+	r.mu.Unlock()
+	if !ok {
+		var err error
+		if udpAddr, err = net.ResolveUDPAddr("udp", addr); err != nil {
+			log.Debug().Err(err).Str("to", l.to).Msg("Unable to resolve node")
+			return
+		}
+
+		r.mu.Lock()
+		r.addrs[addr] = udpAddr
+		r.mu.Unlock()
+	}
+
+	if _, err := conn.WriteToUDP(data, udpAddr); err != nil {
+		log.Debug().Err(err).Str("to", l.to).Msg("Unable to relay datagram")
+		return
+	}
+	log.Debug().Str("from", l.from).Str("to", l.to).Int("bytes", len(data)).Msg("Relayed datagram")
+}
@@ -0,0 +1,185 @@
+package relay
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/sim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testNode is a socket standing in for a node, which sends to the relay and receives what it forwards
+type testNode struct {
+	conn *net.UDPConn
+}
+
+func newTestNode(t *testing.T) *testNode {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	return &testNode{conn}
+}
+
+func (n *testNode) addr() string {
+	return n.conn.LocalAddr().String()
+}
+
+func (n *testNode) send(t *testing.T, r *Relay, data []byte) {
+	_, err := n.conn.WriteTo(data, r.Addr())
+	require.NoError(t, err)
+}
+
+// receive returns the datagrams received within the timeout
+func (n *testNode) receive(timeout time.Duration) []string {
+	var received []string
+	buf := make([]byte, maxDatagramSize)
+	n.conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		size, _, err := n.conn.ReadFrom(buf)
+		if err != nil {
+			return received
+		}
+		received = append(received, string(buf[:size]))
+	}
+}
+
+// newTestRelay starts a relay between test nodes, which are identified by their addresses
+func newTestRelay(t *testing.T, nodes map[string]*testNode, links ...sim.ScenarioLink) *Relay {
+	config := &Config{Nodes: make(map[string]NodeConfig), Links: links}
+	for nodeName, n := range nodes {
+		config.Nodes[nodeName] = NodeConfig{Addr: n.addr(), Source: n.addr()}
+	}
+
+	r, err := New(config, "127.0.0.1:0")
+	require.NoError(t, err)
+	go r.Serve()
+
+	return r
+}
+
+func TestNewConfig(t *testing.T) {
+	sc, err := sim.ParseScenario([]byte(`
+nodes: [a, b]
+links:
+  - between: [a, b]
+    latency: 5ms
+    loss: 0.1
+    bandwidth: 1000
+events:
+  - at: 1s
+    type: node-start
+    node: c
+`))
+	require.NoError(t, err)
+
+	c, err := NewConfig(sc, "1140", map[string]string{"b": "10.0.0.2"}, map[string]string{"b": "10.0.0.3"},
+		map[string]string{"a": "1146"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]NodeConfig{
+		"a": {Addr: "a:1140", DataAddr: "a:1146"},
+		"b": {Addr: "10.0.0.2:1140", Source: "10.0.0.3"},
+		"c": {Addr: "c:1140"},
+	}, c.Nodes)
+	assert.Equal(t, sc.Links, c.Links)
+
+	_, err = NewConfig(sc, "1140", map[string]string{"x": "10.0.0.2"}, nil, nil)
+	assert.Error(t, err)
+	_, err = NewConfig(sc, "1140", nil, nil, map[string]string{"a": "1146", "b": "1146"})
+	assert.Error(t, err)
+}
+
+func TestRelay_ForwardsAlongLinks(t *testing.T) {
+	a, b, c := newTestNode(t), newTestNode(t), newTestNode(t)
+	r := newTestRelay(t, map[string]*testNode{"a": a, "b": b, "c": c},
+		sim.ScenarioLink{Between: []string{"a", "b"}},
+		sim.ScenarioLink{Between: []string{"b", "c"}})
+	defer r.Close()
+
+	a.send(t, r, []byte("from a"))
+	assert.Equal(t, []string{"from a"}, b.receive(time.Millisecond*100))
+	assert.Empty(t, c.receive(time.Millisecond*10))
+	assert.Empty(t, a.receive(time.Millisecond*10))
+
+	b.send(t, r, []byte("from b"))
+	assert.Equal(t, []string{"from b"}, a.receive(time.Millisecond*100))
+	assert.Equal(t, []string{"from b"}, c.receive(time.Millisecond*100))
+}
+
+func TestRelay_UnknownSource(t *testing.T) {
+	a, b := newTestNode(t), newTestNode(t)
+	r := newTestRelay(t, map[string]*testNode{"b": b})
+	defer r.Close()
+
+	a.send(t, r, []byte("from a"))
+	assert.Empty(t, b.receive(time.Millisecond*50))
+}
+
+func TestRelay_Loss(t *testing.T) {
+	a, b := newTestNode(t), newTestNode(t)
+	r := newTestRelay(t, map[string]*testNode{"a": a, "b": b}, sim.ScenarioLink{Between: []string{"a", "b"}, Loss: 1})
+	defer r.Close()
+
+	a.send(t, r, []byte("from a"))
+	assert.Empty(t, b.receive(time.Millisecond*50))
+}
+
+func TestRelay_Latency(t *testing.T) {
+	a, b := newTestNode(t), newTestNode(t)
+	r := newTestRelay(t, map[string]*testNode{"a": a, "b": b},
+		sim.ScenarioLink{Between: []string{"a", "b"}, Latency: time.Millisecond * 100})
+	defer r.Close()
+
+	sent := time.Now()
+	a.send(t, r, []byte("from a"))
+	assert.Empty(t, b.receive(time.Millisecond*50))
+	assert.Equal(t, []string{"from a"}, b.receive(time.Millisecond*200))
+	assert.True(t, time.Since(sent) >= time.Millisecond*100)
+}
+
+func TestRelay_Bandwidth(t *testing.T) {
+	a, b := newTestNode(t), newTestNode(t)
+	// 10ms per datagram, so 100 datagrams would take a second, more than the backlog allows
+	r := newTestRelay(t, map[string]*testNode{"a": a, "b": b},
+		sim.ScenarioLink{Between: []string{"a", "b"}, Bandwidth: 100000})
+	defer r.Close()
+
+	for i := 0; i < 100; i++ {
+		a.send(t, r, make([]byte, 1000))
+	}
+
+	assert.True(t, len(b.receive(time.Millisecond*100)) < 20)
+
+	received := b.receive(time.Second)
+	assert.True(t, len(received) < 70, fmt.Sprintf("received %d", len(received)))
+}
+
+func TestRelay_Data(t *testing.T) {
+	a, b, c := newTestNode(t), newTestNode(t), newTestNode(t)
+	bData := newTestNode(t)
+	config := &Config{
+		Nodes: map[string]NodeConfig{
+			"a": {Addr: a.addr(), Source: a.addr()},
+			"b": {Addr: b.addr(), Source: b.addr(), DataAddr: bData.addr(), RelayDataPort: "0"},
+			"c": {Addr: c.addr(), Source: c.addr()},
+		},
+		Links: []sim.ScenarioLink{{Between: []string{"a", "b"}}},
+	}
+	r, err := New(config, "127.0.0.1:0")
+	require.NoError(t, err)
+	go r.Serve()
+	defer r.Close()
+	require.Nil(t, r.DataAddr("a"))
+
+	_, err = a.conn.WriteTo([]byte("data from a"), r.DataAddr("b"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"data from a"}, bData.receive(time.Millisecond*100))
+	assert.Empty(t, b.receive(time.Millisecond*10))
+
+	// c has no link to b
+	_, err = c.conn.WriteTo([]byte("data from c"), r.DataAddr("b"))
+	require.NoError(t, err)
+	assert.Empty(t, bData.receive(time.Millisecond*50))
+}
@@ -0,0 +1,162 @@
+package sim
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	rsnet "github.com/Heanthor/rsec-net/pkg/net"
+)
+
+// Clock is a virtual net.Clock. Time stands still until it is moved forward by the simulation,
+// which fires the timers and tickers that come due in order.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers timerQueue
+}
+
+// timer is a pending After channel, or a ticker if period is set
+type timer struct {
+	when    time.Time
+	period  time.Duration
+	seq     uint64
+	c       chan time.Time
+	stopped bool
+}
+
+// NewClock creates a virtual clock starting at start
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the virtual time
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// After returns a channel which receives the virtual time once d has elapsed
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0).c
+}
+
+// NewTicker returns a ticker which ticks every d of virtual time. Panics if d isn't positive, like time.NewTicker.
+func (c *Clock) NewTicker(d time.Duration) rsnet.Ticker {
+	if d <= 0 {
+		panic("sim: non-positive interval for NewTicker")
+	}
+
+	return &ticker{clock: c, t: c.add(d, d)}
+}
+
+func (c *Clock) add(d, period time.Duration) *timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	t := &timer{
+		when:   c.now.Add(d),
+		period: period,
+		seq:    c.seq,
+		// buffered, so firing never blocks. Like time.Ticker, ticks are dropped if the last one wasn't read.
+		c: make(chan time.Time, 1),
+	}
+	heap.Push(&c.timers, t)
+
+	return t
+}
+
+// next returns when the next timer fires, or false if there are no timers
+func (c *Clock) next() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.timers) > 0 {
+		if t := c.timers[0]; !t.stopped {
+			return t.when, true
+		}
+		heap.Pop(&c.timers)
+	}
+
+	return time.Time{}, false
+}
+
+// fire moves the time forward to when, and fires every timer due by then, in the order they are due
+func (c *Clock) fire(when time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if when.After(c.now) {
+		c.now = when
+	}
+
+	for len(c.timers) > 0 && !c.timers[0].when.After(c.now) {
+		t := heap.Pop(&c.timers).(*timer)
+		if t.stopped {
+			continue
+		}
+
+		select {
+		case t.c <- c.now:
+		default:
+		}
+
+		if t.period > 0 {
+			c.seq++
+			t.when = t.when.Add(t.period)
+			t.seq = c.seq
+			heap.Push(&c.timers, t)
+		}
+	}
+}
+
+type ticker struct {
+	clock *Clock
+	t     *timer
+}
+
+func (t *ticker) C() <-chan time.Time {
+	return t.t.c
+}
+
+func (t *ticker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	t.t.stopped = true
+}
+
+// timerQueue is a heap of timers, ordered by when they are due and then by when they were scheduled
+type timerQueue []*timer
+
+func (q timerQueue) Len() int {
+	return len(q)
+}
+
+func (q timerQueue) Less(i, j int) bool {
+	if !q[i].when.Equal(q[j].when) {
+		return q[i].when.Before(q[j].when)
+	}
+
+	return q[i].seq < q[j].seq
+}
+
+func (q timerQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *timerQueue) Push(x interface{}) {
+	*q = append(*q, x.(*timer))
+}
+
+func (q *timerQueue) Pop() interface{} {
+	old := *q
+	t := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return t
+}
@@ -0,0 +1,122 @@
+package sim
+
+import (
+	"bytes"
+	"runtime"
+)
+
+// nodePackages are the packages whose code runs the nodes, and carries datagrams between them
+var nodePackages = [][]byte{
+	[]byte("github.com/Heanthor/rsec-net/pkg/net."),
+	[]byte("github.com/Heanthor/rsec-net/internal/memnet."),
+}
+
+// waitStates are the states of goroutines which stay blocked until another goroutine sends on a channel, unlocks a
+// mutex and so on. Other states, such as runnable, or waiting for wall clock time or a syscall, end on their own.
+var waitStates = [][]byte{
+	[]byte("chan receive"),
+	[]byte("chan send"),
+	[]byte("select"),
+	[]byte("sync."),
+	[]byte("semacquire"),
+}
+
+// nodesBlocked returns true if every goroutine running node code is blocked waiting for another goroutine.
+// The goroutines are looked at with the world stopped, so none can wake another in between. If they are all
+// blocked, nothing more happens until the simulation fires a timer or changes the network.
+func (s *Sim) nodesBlocked() bool {
+	for {
+		n := runtime.Stack(s.stacks, true)
+		if n < len(s.stacks) {
+			return blocked(s.stacks[:n])
+		}
+		s.stacks = make([]byte, 2*len(s.stacks))
+	}
+}
+
+// blocked returns true if every goroutine of the nodes in the traces from runtime.Stack, other than the first,
+// which is the caller, is blocked waiting for another goroutine. Goroutines of the nodes are those running
+// node code, and those started by them, such as the goroutines libraries start on their behalf.
+func blocked(stacks []byte) bool {
+	goroutines := bytes.Split(stacks, []byte("\n\n"))[1:]
+	parents := make(map[string]string, len(goroutines))
+	for _, g := range goroutines {
+		parents[goroutineID(g)] = parentID(g)
+	}
+	isNode := make(map[string]bool, len(goroutines))
+	for _, g := range goroutines {
+		isNode[goroutineID(g)] = runsNode(g)
+	}
+
+	for _, g := range goroutines {
+		if waiting(g) {
+			continue
+		}
+		// an ancestor may have exited, or not be a goroutine of the nodes
+		for id := goroutineID(g); id != ""; id = parents[id] {
+			if isNode[id] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// goroutineID returns the ID of the goroutine, from its header: goroutine 7 [chan receive]:
+func goroutineID(g []byte) string {
+	g = bytes.TrimPrefix(g, []byte("goroutine "))
+	if i := bytes.IndexByte(g, ' '); i >= 0 {
+		return string(g[:i])
+	}
+
+	return ""
+}
+
+// parentID returns the ID of the goroutine which started the goroutine, from the end of its trace:
+// created by main.main in goroutine 1. Empty if it isn't known.
+func parentID(g []byte) string {
+	const in = " in goroutine "
+	i := bytes.LastIndex(g, []byte("created by "))
+	if i < 0 {
+		return ""
+	}
+	line := g[i:]
+	if j := bytes.IndexByte(line, '\n'); j >= 0 {
+		line = line[:j]
+	}
+	if j := bytes.LastIndex(line, []byte(in)); j >= 0 {
+		return string(line[j+len(in):])
+	}
+
+	return ""
+}
+
+// runsNode returns true if node code is on the stack of the goroutine
+func runsNode(g []byte) bool {
+	for _, pkg := range nodePackages {
+		if bytes.Contains(g, pkg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// waiting returns true if the goroutine is in one of the waitStates, from its header: goroutine 7 [chan receive]:
+func waiting(g []byte) bool {
+	start := bytes.IndexByte(g, '[')
+	end := bytes.IndexByte(g, ']')
+	if start < 0 || end < start {
+		return false
+	}
+
+	state := g[start+1 : end]
+	for _, s := range waitStates {
+		if bytes.HasPrefix(state, s) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,312 @@
+package sim
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/memnet"
+	rsnet "github.com/Heanthor/rsec-net/pkg/net"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// defaultScenarioDuration is how long a scenario without a duration runs after its last event
+const defaultScenarioDuration = time.Second * 30
+
+// Scenario event types
+const (
+	EventLinkDown  = "link-down"
+	EventLinkUp    = "link-up"
+	EventNodeKill  = "node-kill"
+	EventNodeStart = "node-start"
+	EventPartition = "partition"
+	EventHeal      = "heal"
+)
+
+// Scenario describes a simulation: the nodes, the links between them, and events which happen at set times.
+// Times are durations since the start, such as 10s or 1m30s.
+type Scenario struct {
+	// Seed seeds random drops and reordering, so runs are repeatable
+	Seed int64 `yaml:"seed"`
+	// AnnounceInterval defaults to a second, and HoldTime to three announce intervals
+	AnnounceInterval time.Duration `yaml:"announceInterval"`
+	HoldTime         time.Duration `yaml:"holdTime"`
+	// Duration is how long the simulation runs for. Defaults to 30s after the last event.
+	Duration time.Duration `yaml:"duration"`
+
+	// Nodes are started at the start of the simulation
+	Nodes  []string        `yaml:"nodes"`
+	Links  []ScenarioLink  `yaml:"links"`
+	Events []ScenarioEvent `yaml:"events"`
+}
+
+// ScenarioLink is a link between two nodes, which carries datagrams both ways
+type ScenarioLink struct {
+	Between []string `yaml:"between"`
+	// Cost is advertised by both nodes for the link. If it isn't set, the cost is derived from latency and loss.
+	Cost int `yaml:"cost"`
+	// Latency delays every datagram, each way
+	Latency time.Duration `yaml:"latency"`
+	// Loss is the fraction of datagrams dropped, from 0 to 1
+	Loss float64 `yaml:"loss"`
+	// Reorder is the fraction of datagrams held back and delivered after the next one, from 0 to 1
+	Reorder float64 `yaml:"reorder"`
+	// Bandwidth caps each way of the link, in bytes per second. Zero is unlimited.
+	Bandwidth int `yaml:"bandwidth"`
+}
+
+// ScenarioEvent is something which happens to the network at a set time
+type ScenarioEvent struct {
+	At   time.Duration `yaml:"at"`
+	Type string        `yaml:"type"`
+	// Link is the two nodes at the ends of the link, for link-down and link-up
+	Link []string `yaml:"link"`
+	// Node is the node for node-kill and node-start
+	Node string `yaml:"node"`
+	// Groups are the groups of nodes to cut off from each other, for partition
+	Groups [][]string `yaml:"groups"`
+}
+
+func (e ScenarioEvent) String() string {
+	switch e.Type {
+	case EventLinkDown, EventLinkUp:
+		return fmt.Sprintf("%s %s", e.Type, strings.Join(e.Link, " "))
+	case EventNodeKill, EventNodeStart:
+		return fmt.Sprintf("%s %s", e.Type, e.Node)
+	case EventPartition:
+		groups := make([]string, len(e.Groups))
+		for i, g := range e.Groups {
+			groups[i] = "[" + strings.Join(g, " ") + "]"
+		}
+		return fmt.Sprintf("%s %s", e.Type, strings.Join(groups, " "))
+	default:
+		return e.Type
+	}
+}
+
+// LoadScenario reads a scenario from a YAML file
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseScenario(data)
+}
+
+// ParseScenario parses a scenario from YAML, and checks it refers only to nodes and links it describes
+func ParseScenario(data []byte) (*Scenario, error) {
+	var sc Scenario
+	if err := yaml.UnmarshalStrict(data, &sc); err != nil {
+		return nil, err
+	}
+	if err := sc.validate(); err != nil {
+		return nil, err
+	}
+
+	return &sc, nil
+}
+
+func (sc *Scenario) validate() error {
+	if sc.AnnounceInterval < 0 || sc.HoldTime < 0 || sc.Duration < 0 {
+		return fmt.Errorf("times must not be negative")
+	}
+
+	nodes := make(map[string]bool)
+	for _, nodeName := range sc.Nodes {
+		if nodeName == "" {
+			return fmt.Errorf("node names must not be empty")
+		}
+		if nodes[nodeName] {
+			return fmt.Errorf("node %s is listed twice", nodeName)
+		}
+		nodes[nodeName] = true
+	}
+	// nodes may also join later on
+	for _, e := range sc.Events {
+		if e.Type == EventNodeStart && e.Node != "" {
+			nodes[e.Node] = true
+		}
+	}
+
+	checkNodes := func(names []string) error {
+		for _, nodeName := range names {
+			if !nodes[nodeName] {
+				return fmt.Errorf("unknown node %s", nodeName)
+			}
+		}
+		return nil
+	}
+
+	links := make(map[nodePair]bool)
+	for _, l := range sc.Links {
+		if len(l.Between) != 2 || l.Between[0] == l.Between[1] {
+			return fmt.Errorf("link %v must be between two different nodes", l.Between)
+		}
+		if err := checkNodes(l.Between); err != nil {
+			return fmt.Errorf("link %v: %v", l.Between, err)
+		}
+		if l.Cost < 0 || l.Latency < 0 || l.Bandwidth < 0 || l.Loss < 0 || l.Loss > 1 || l.Reorder < 0 || l.Reorder > 1 {
+			return fmt.Errorf("link %v: cost, latency and bandwidth must not be negative, and loss and reorder must be from 0 to 1", l.Between)
+		}
+		links[pair(l.Between[0], l.Between[1])] = true
+	}
+
+	var last time.Duration
+	for _, e := range sc.Events {
+		if e.At < 0 {
+			return fmt.Errorf("event %s: at must not be negative", e)
+		}
+		if e.At > last {
+			last = e.At
+		}
+
+		var err error
+		switch e.Type {
+		case EventLinkDown, EventLinkUp:
+			if len(e.Link) != 2 || !links[pair(e.Link[0], e.Link[1])] {
+				err = fmt.Errorf("no link %v", e.Link)
+			}
+		case EventNodeKill, EventNodeStart:
+			err = checkNodes([]string{e.Node})
+		case EventPartition:
+			if len(e.Groups) < 2 {
+				err = fmt.Errorf("at least two groups are needed")
+			}
+			for _, g := range e.Groups {
+				if err == nil {
+					err = checkNodes(g)
+				}
+			}
+		case EventHeal:
+		default:
+			err = fmt.Errorf("unknown type, must be one of %s", strings.Join([]string{
+				EventLinkDown, EventLinkUp, EventNodeKill, EventNodeStart, EventPartition, EventHeal}, ", "))
+		}
+		if err != nil {
+			return fmt.Errorf("event %s at %v: %v", e, e.At, err)
+		}
+	}
+
+	if sc.Duration == 0 {
+		sc.Duration = last + defaultScenarioDuration
+	} else if sc.Duration < last {
+		return fmt.Errorf("duration %v ends before the last event, at %v", sc.Duration, last)
+	}
+
+	return nil
+}
+
+// Result is the outcome of running a scenario
+type Result struct {
+	// Convergence holds how long the nodes took to converge from the start, and after each time events happened
+	Convergence []Convergence
+	// Routes holds the routes of every node running at the end, keyed by node and then by destination
+	Routes map[string]map[string]rsnet.Route
+	// Elapsed is the virtual time the scenario ran for
+	Elapsed time.Duration
+}
+
+// Convergence is how long the nodes took to converge after events happened
+type Convergence struct {
+	// At is when the events happened, zero for the start
+	At     time.Duration
+	Events []ScenarioEvent
+	// Took is how long the nodes took to converge, unless they didn't before the next events or the end
+	Took      time.Duration
+	Converged bool
+}
+
+// Run runs a scenario to the end
+func (sc *Scenario) Run() (*Result, error) {
+	s := New(sc.Seed, rsnet.InterfaceSettings{
+		AnnounceInterval: sc.AnnounceInterval,
+		HoldTime:         sc.HoldTime,
+	})
+	defer s.Close()
+
+	for _, l := range sc.Links {
+		s.Connect(l.Between[0], l.Between[1], memnet.Link{Delay: l.Latency, Loss: l.Loss, Reorder: l.Reorder, Bandwidth: l.Bandwidth})
+		if l.Cost > 0 {
+			s.SetCost(l.Between[0], l.Between[1], l.Cost)
+		}
+	}
+	for _, nodeName := range sc.Nodes {
+		if err := s.AddNode(nodeName); err != nil {
+			return nil, fmt.Errorf("unable to start node %s: %v", nodeName, err)
+		}
+	}
+
+	events := make([]ScenarioEvent, len(sc.Events))
+	copy(events, sc.Events)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].At < events[j].At
+	})
+
+	result := &Result{}
+	phase := Convergence{}
+	for {
+		// the events at the start of this phase
+		for len(events) > 0 && events[0].At == phase.At {
+			phase.Events = append(phase.Events, events[0])
+			events = events[1:]
+		}
+
+		var err error
+		for _, e := range phase.Events {
+			e := e
+			s.At(e.At, func() {
+				if eventErr := s.apply(e); eventErr != nil && err == nil {
+					err = fmt.Errorf("event %s at %v: %v", e, e.At, eventErr)
+				}
+			})
+		}
+		s.RunUntil(phase.At)
+		if err != nil {
+			return nil, err
+		}
+
+		end := sc.Duration
+		if len(events) > 0 {
+			end = events[0].At
+		}
+		phase.Took, phase.Converged = s.RunUntilConverged(end - phase.At)
+		result.Convergence = append(result.Convergence, phase)
+
+		if len(events) == 0 {
+			break
+		}
+		phase = Convergence{At: events[0].At}
+	}
+	s.RunUntil(sc.Duration)
+
+	result.Elapsed = s.Elapsed()
+	result.Routes = make(map[string]map[string]rsnet.Route)
+	for _, nodeName := range s.Nodes() {
+		result.Routes[nodeName] = s.Routes(nodeName)
+	}
+
+	return result, nil
+}
+
+// apply makes a scenario event happen
+func (s *Sim) apply(e ScenarioEvent) error {
+	switch e.Type {
+	case EventLinkDown:
+		return s.LinkDown(e.Link[0], e.Link[1])
+	case EventLinkUp:
+		return s.LinkUp(e.Link[0], e.Link[1])
+	case EventNodeKill:
+		return s.RemoveNode(e.Node)
+	case EventNodeStart:
+		return s.AddNode(e.Node)
+	case EventPartition:
+		s.Partition(e.Groups...)
+	case EventHeal:
+		s.Heal()
+	}
+
+	return nil
+}
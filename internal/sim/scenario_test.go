@@ -0,0 +1,72 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScenario(t *testing.T) {
+	sc, err := ParseScenario([]byte(`
+announceInterval: 500ms
+nodes: [a, b]
+links:
+  - between: [a, b]
+    cost: 3
+    latency: 5ms
+    loss: 0.5
+    bandwidth: 1000
+events:
+  - at: 1m
+    type: link-down
+    link: [b, a]
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Millisecond*500, sc.AnnounceInterval)
+	assert.Equal(t, []ScenarioLink{{Between: []string{"a", "b"}, Cost: 3, Latency: time.Millisecond * 5, Loss: 0.5, Bandwidth: 1000}}, sc.Links)
+	assert.Equal(t, "link-down b a", sc.Events[0].String())
+	// runs for a while after the last event
+	assert.Equal(t, time.Minute+defaultScenarioDuration, sc.Duration)
+}
+
+func TestParseScenario_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":    "nodes: [a]\nlinkz: []",
+		"duplicate node":   "nodes: [a, a]",
+		"unknown node":     "nodes: [a]\nlinks: [{between: [a, b]}]",
+		"link to itself":   "nodes: [a]\nlinks: [{between: [a, a]}]",
+		"loss":             "nodes: [a, b]\nlinks: [{between: [a, b], loss: 2}]",
+		"unknown link":     "nodes: [a, b, c]\nlinks: [{between: [a, b]}]\nevents: [{at: 1s, type: link-down, link: [a, c]}]",
+		"unknown type":     "nodes: [a]\nevents: [{at: 1s, type: explode}]",
+		"one group":        "nodes: [a, b]\nevents: [{at: 1s, type: partition, groups: [[a, b]]}]",
+		"ends before last": "nodes: [a]\nduration: 5s\nevents: [{at: 10s, type: node-kill, node: a}]",
+	}
+
+	for name, scenario := range tests {
+		_, err := ParseScenario([]byte(scenario))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestScenario_Run(t *testing.T) {
+	sc, err := LoadScenario("../../scenarios/ring.yaml")
+	require.NoError(t, err)
+
+	result, err := sc.Run()
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Second*40, result.Elapsed)
+	require.Len(t, result.Convergence, 4)
+	for _, c := range result.Convergence {
+		assert.True(t, c.Converged, "at %v", c.At)
+	}
+	assert.Equal(t, time.Second*30, result.Convergence[3].At)
+	assert.Len(t, result.Convergence[3].Events, 2)
+
+	// the link from a to d costs more than the other way round the ring
+	assert.Equal(t, []string{"a", "b", "c", "d"}, result.Routes["a"]["d"].Path)
+	assert.Len(t, result.Routes, 4)
+}
@@ -0,0 +1,512 @@
+// Package sim runs a mesh of nodes in-process, on a virtual clock and an in-memory network, so that
+// convergence can be tested without waiting in wall clock time.
+//
+// Scripted events, such as link failures, partitions and nodes joining or leaving, are scheduled at
+// virtual times with At, and the simulation is moved forward with RunFor or RunUntil. Time only moves
+// once every node is idle, with every goroutine running node code blocked waiting for another, so
+// everything due at an instant is handled at that instant.
+package sim
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/graph"
+	"github.com/Heanthor/rsec-net/internal/memnet"
+	"github.com/Heanthor/rsec-net/internal/udp"
+	rsnet "github.com/Heanthor/rsec-net/pkg/net"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	announceGroup = "announce:1145"
+	dataPort      = "1146"
+	announcePort  = "1145"
+
+	// defaultAnnounceInterval is used if the settings don't have an announce interval
+	defaultAnnounceInterval = time.Second
+
+	// idleCheckInterval is the wall clock time between checks for whether the nodes are idle
+	idleCheckInterval = time.Microsecond * 20
+	// maxSettleTime bounds the wall clock time spent waiting for the nodes to be idle, in case one never is
+	maxSettleTime = time.Second * 30
+)
+
+var (
+	// ErrNodeExists is returned when adding a node with the name of a running node
+	ErrNodeExists = errors.New("node already exists")
+	// ErrUnknownNode is returned when referring to a node which isn't running
+	ErrUnknownNode = errors.New("unknown node")
+)
+
+// Epoch is the virtual time simulations start at
+var Epoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Sim is a simulated mesh network. It isn't safe for concurrent use.
+type Sim struct {
+	Clock *Clock
+
+	settings rsnet.InterfaceSettings
+	sw       *memnet.Switch
+	nodes    map[string]*rsnet.Interface
+
+	// links holds the configuration of each link, and whether it is down
+	links map[nodePair]*link
+	// costs holds the fixed cost of each node's links, keyed by node and then by the node at the other end
+	costs map[string]map[string]int
+
+	events eventQueue
+	seq    uint64
+
+	// stacks is reused for the goroutine stack traces looked at to tell if the nodes are idle
+	stacks []byte
+}
+
+// nodePair names the ends of a link, in order
+type nodePair struct {
+	a, b string
+}
+
+func pair(a, b string) nodePair {
+	if b < a {
+		a, b = b, a
+	}
+
+	return nodePair{a, b}
+}
+
+type link struct {
+	config memnet.Link
+	down   bool
+}
+
+// New creates an empty simulation. Nodes are created with the settings, and a clock and writers
+// provided by the simulation. Random drops and reordering are seeded with seed.
+func New(seed int64, settings rsnet.InterfaceSettings) *Sim {
+	clock := NewClock(Epoch)
+	sw := memnet.NewSwitch(seed)
+	sw.SetClock(clock)
+	// nodes can only hear each other over links
+	sw.SetDefaultLink(memnet.Link{Loss: 1})
+
+	if settings.AnnounceInterval == 0 {
+		settings.AnnounceInterval = defaultAnnounceInterval
+	}
+	settings.Clock = clock
+
+	return &Sim{
+		Clock:    clock,
+		settings: settings,
+		sw:       sw,
+		nodes:    make(map[string]*rsnet.Interface),
+		links:    make(map[nodePair]*link),
+		costs:    make(map[string]map[string]int),
+		stacks:   make([]byte, 64*1024),
+	}
+}
+
+// Elapsed returns the virtual time since the simulation started
+func (s *Sim) Elapsed() time.Duration {
+	return s.Clock.Now().Sub(Epoch)
+}
+
+// AddNode starts a node, which starts announcing to the nodes it has links to
+func (s *Sim) AddNode(nodeName string) error {
+	if _, ok := s.nodes[nodeName]; ok {
+		return ErrNodeExists
+	}
+
+	settings := s.settings
+	if costs, ok := s.costs[nodeName]; ok {
+		settings.LinkCosts = make(map[string]int, len(costs))
+		for k, v := range costs {
+			settings.LinkCosts[k] = v
+		}
+	}
+	settings.NewWriter = func(addr string) (udp.NetWriter, error) {
+		return s.sw.NewWriter(nodeName, addr), nil
+	}
+
+	n, err := rsnet.NewInterface(context.Background(), nodeName,
+		s.sw.NewReader(net.JoinHostPort(nodeName, dataPort)),
+		s.sw.NewWriter(nodeName, announceGroup),
+		s.sw.NewMulticastReader(announceGroup, net.JoinHostPort(nodeName, announcePort)),
+		settings)
+	if err != nil {
+		return err
+	}
+	n.StartAnnounce()
+	s.nodes[nodeName] = n
+
+	return nil
+}
+
+// RemoveNode stops a node, as if it crashed
+func (s *Sim) RemoveNode(nodeName string) error {
+	n, ok := s.nodes[nodeName]
+	if !ok {
+		return ErrUnknownNode
+	}
+
+	delete(s.nodes, nodeName)
+
+	return n.Close(context.Background())
+}
+
+// Node returns the interface of a running node, or nil if there isn't one
+func (s *Sim) Node(nodeName string) *rsnet.Interface {
+	return s.nodes[nodeName]
+}
+
+// Nodes returns the names of the running nodes, sorted
+func (s *Sim) Nodes() []string {
+	names := make([]string, 0, len(s.nodes))
+	for nodeName := range s.nodes {
+		names = append(names, nodeName)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Routes returns the routing table of a running node, keyed by destination
+func (s *Sim) Routes(nodeName string) map[string]rsnet.Route {
+	n, ok := s.nodes[nodeName]
+	if !ok {
+		return nil
+	}
+
+	return n.RoutingTable().Routes()
+}
+
+// Connect adds a link between two nodes, carrying datagrams both ways, or reconfigures an existing one.
+// The nodes don't need to be running.
+func (s *Sim) Connect(a, b string, config memnet.Link) {
+	l, ok := s.links[pair(a, b)]
+	if !ok {
+		l = &link{}
+		s.links[pair(a, b)] = l
+	}
+	l.config = config
+	s.applyLink(a, b, l)
+}
+
+// SetCost fixes the cost both nodes advertise for the link between them, instead of deriving it from latency.
+// Only nodes added afterwards use the cost.
+func (s *Sim) SetCost(a, b string, cost int) {
+	for _, p := range [][2]string{{a, b}, {b, a}} {
+		if _, ok := s.costs[p[0]]; !ok {
+			s.costs[p[0]] = make(map[string]int)
+		}
+		s.costs[p[0]][p[1]] = cost
+	}
+}
+
+// LinkDown cuts the link between two nodes, until LinkUp is called
+func (s *Sim) LinkDown(a, b string) error {
+	return s.setDown(a, b, true)
+}
+
+// LinkUp restores the link between two nodes
+func (s *Sim) LinkUp(a, b string) error {
+	return s.setDown(a, b, false)
+}
+
+// Partition cuts every link between nodes in different groups. Nodes which aren't in any group keep their links.
+func (s *Sim) Partition(groups ...[]string) {
+	group := make(map[string]int)
+	for i, g := range groups {
+		for _, nodeName := range g {
+			group[nodeName] = i
+		}
+	}
+
+	for p := range s.links {
+		ga, aOK := group[p.a]
+		gb, bOK := group[p.b]
+		if aOK && bOK && ga != gb {
+			s.setDown(p.a, p.b, true)
+		}
+	}
+}
+
+// Heal restores every link
+func (s *Sim) Heal() {
+	for p := range s.links {
+		s.setDown(p.a, p.b, false)
+	}
+}
+
+func (s *Sim) setDown(a, b string, down bool) error {
+	l, ok := s.links[pair(a, b)]
+	if !ok {
+		return ErrUnknownNode
+	}
+	l.down = down
+	s.applyLink(a, b, l)
+
+	return nil
+}
+
+func (s *Sim) applyLink(a, b string, l *link) {
+	config := l.config
+	if l.down {
+		config = memnet.Link{Loss: 1}
+	}
+	s.sw.SetLink(a, b, config)
+	s.sw.SetLink(b, a, config)
+}
+
+// At schedules f to be called once the simulation reaches the virtual time at, since the start.
+// Events scheduled for the same time are called in the order they were scheduled, before any timers due then.
+func (s *Sim) At(at time.Duration, f func()) {
+	s.seq++
+	heap.Push(&s.events, &event{Epoch.Add(at), s.seq, f})
+}
+
+// RunFor runs the simulation for d of virtual time
+func (s *Sim) RunFor(d time.Duration) {
+	s.RunUntil(s.Elapsed() + d)
+}
+
+// RunUntil runs the simulation until the virtual time since the start is at
+func (s *Sim) RunUntil(at time.Duration) {
+	until := Epoch.Add(at)
+	for s.step(until) {
+	}
+	s.Clock.fire(until)
+	s.settle()
+}
+
+// RunUntilConverged runs the simulation until every node has converged, for at most max of virtual time.
+// Returns how long that took, and false if the nodes didn't converge.
+func (s *Sim) RunUntilConverged(max time.Duration) (time.Duration, bool) {
+	start := s.Elapsed()
+	until := Epoch.Add(start + max)
+
+	s.settle()
+	for !s.Converged() {
+		if !s.step(until) {
+			s.Clock.fire(until)
+			s.settle()
+
+			return s.Elapsed() - start, s.Converged()
+		}
+	}
+
+	return s.Elapsed() - start, true
+}
+
+// step moves time forward to the next event or timer due by until, and waits for the network to go quiet
+// after handling it. Returns false if there wasn't one.
+func (s *Sim) step(until time.Time) bool {
+	next, timerDue := s.Clock.next()
+	timerDue = timerDue && !next.After(until)
+	eventDue := len(s.events) > 0 && !s.events[0].at.After(until)
+
+	switch {
+	case eventDue && (!timerDue || !s.events[0].at.After(next)):
+		e := heap.Pop(&s.events).(*event)
+		s.Clock.fire(e.at)
+		e.f()
+	case timerDue:
+		s.Clock.fire(next)
+	default:
+		return false
+	}
+
+	s.settle()
+
+	return true
+}
+
+// settle waits until every node is idle: every datagram which is due has been received, and every tick,
+// datagram and anything else the nodes queued for themselves has been handled.
+func (s *Sim) settle() {
+	deadline := time.Now().Add(maxSettleTime)
+	for !s.idle() {
+		if time.Now().After(deadline) {
+			log.Error().Int("pending", s.sw.Pending()).Dur("elapsed", s.Elapsed()).Msg("Simulated nodes didn't become idle")
+			return
+		}
+
+		runtime.Gosched()
+		time.Sleep(idleCheckInterval)
+	}
+}
+
+// idle returns true if every node is idle. Datagrams waiting to be received are counted first,
+// since that is cheaper than looking at the goroutines of the nodes.
+func (s *Sim) idle() bool {
+	return s.sw.Pending() == 0 && s.nodesBlocked()
+}
+
+// Converged returns true if every running node has a route to exactly the nodes it can reach over links
+// which are up, and each route is a shortest path over those links. Links cost what they were fixed to with
+// SetCost, or otherwise what the node at their start advertises for them.
+func (s *Sim) Converged() bool {
+	costs, ok := s.linkCosts()
+	if !ok {
+		return false
+	}
+	g, err := s.graph(costs)
+	if err != nil {
+		log.Error().Err(err).Msg("Couldn't build the simulated network graph")
+		return false
+	}
+	searcher := graph.DijkstraSearcher{}
+
+	for nodeName, n := range s.nodes {
+		shortest := searcher.ShortestPaths(g, nodeName)
+		routes := n.RoutingTable().Routes()
+		if len(routes) != len(shortest) {
+			return false
+		}
+
+		for dest, route := range routes {
+			path, ok := shortest[dest]
+			if !ok {
+				return false
+			}
+
+			want := pathCost(costs, keys(path))
+			if route.Cost != want || pathCost(costs, route.Path) != want {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// linkCosts returns the cost of each link which is up, in both directions, keyed by the node at the start and
+// then the node at the end. Returns false if a node doesn't advertise a link yet.
+func (s *Sim) linkCosts() (map[string]map[string]int, bool) {
+	costs := make(map[string]map[string]int)
+	for nodeName, n := range s.nodes {
+		costs[nodeName] = make(map[string]int)
+		advertised := n.Topology()[nodeName]
+
+		for p := range s.links {
+			other := p.b
+			if p.b == nodeName {
+				other = p.a
+			} else if p.a != nodeName {
+				continue
+			}
+			if !s.up(nodeName, other) {
+				continue
+			}
+
+			cost, ok := s.costs[nodeName][other]
+			if !ok {
+				if cost, ok = advertised[other]; !ok {
+					return nil, false
+				}
+			}
+			costs[nodeName][other] = cost
+		}
+	}
+
+	return costs, true
+}
+
+// graph builds the graph of the running nodes and the links between them
+func (s *Sim) graph(costs map[string]map[string]int) (*graph.DirectedGraph, error) {
+	chain := graph.NewDirectedGraphChain()
+	for nodeName := range s.nodes {
+		chain.AddNode(&graph.Node{Key: nodeName})
+	}
+	for from, adj := range costs {
+		for to, cost := range adj {
+			chain.AddEdge(from, to, cost)
+		}
+	}
+
+	return chain.DirectedGraph()
+}
+
+// pathCost returns the cost of a path, which is -1 if it uses a link which isn't up
+func pathCost(costs map[string]map[string]int, path []string) int {
+	total := 0
+	for i := 1; i < len(path); i++ {
+		cost, ok := costs[path[i-1]][path[i]]
+		if !ok {
+			return -1
+		}
+		total += cost
+	}
+
+	return total
+}
+
+func keys(path []*graph.Node) []string {
+	k := make([]string, len(path))
+	for i, n := range path {
+		k[i] = n.Key
+	}
+
+	return k
+}
+
+// up returns true if there is a link between two running nodes which is up and not completely lossy
+func (s *Sim) up(a, b string) bool {
+	l, ok := s.links[pair(a, b)]
+	if !ok || l.down || l.config.Loss >= 1 {
+		return false
+	}
+	_, aOK := s.nodes[a]
+	_, bOK := s.nodes[b]
+
+	return aOK && bOK
+}
+
+// Close stops every node
+func (s *Sim) Close() {
+	for _, nodeName := range s.Nodes() {
+		s.RemoveNode(nodeName)
+	}
+	s.sw.Close()
+}
+
+type event struct {
+	at  time.Time
+	seq uint64
+	f   func()
+}
+
+// eventQueue is a heap of events, ordered by when they are due and then by when they were scheduled
+type eventQueue []*event
+
+func (q eventQueue) Len() int {
+	return len(q)
+}
+
+func (q eventQueue) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *eventQueue) Push(x interface{}) {
+	*q = append(*q, x.(*event))
+}
+
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return e
+}
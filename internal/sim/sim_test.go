@@ -0,0 +1,240 @@
+package sim
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/memnet"
+	rsnet "github.com/Heanthor/rsec-net/pkg/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClock(t *testing.T) {
+	c := NewClock(Epoch)
+	after := c.After(time.Second * 2)
+	ticker := c.NewTicker(time.Second)
+
+	next, ok := c.next()
+	require.True(t, ok)
+	assert.Equal(t, Epoch.Add(time.Second), next)
+
+	c.fire(next)
+	assert.Equal(t, Epoch.Add(time.Second), <-ticker.C())
+	assert.Empty(t, after)
+
+	c.fire(Epoch.Add(time.Second * 2))
+	assert.Equal(t, Epoch.Add(time.Second*2), <-after)
+	assert.Equal(t, Epoch.Add(time.Second*2), <-ticker.C())
+	assert.Equal(t, Epoch.Add(time.Second*2), c.Now())
+
+	ticker.Stop()
+	_, ok = c.next()
+	assert.False(t, ok)
+}
+
+func TestBlocked(t *testing.T) {
+	caller := "goroutine 1 [running]:\nmain.main()\n"
+	node := func(state string) string {
+		return "goroutine 7 [" + state + "]:\ngithub.com/Heanthor/rsec-net/pkg/net.(*announceDaemon).send(0xc000010000)\n"
+	}
+	other := "goroutine 9 [runnable]:\ntesting.tRunner(0xc000010000)\n"
+
+	assert.True(t, blocked([]byte(caller)))
+	assert.True(t, blocked([]byte(caller+"\n"+node("select")+"\n"+node("chan receive, 2 minutes")+"\n"+other)))
+	assert.True(t, blocked([]byte(caller+"\n"+node("sync.Cond.Wait"))))
+	assert.False(t, blocked([]byte(caller+"\n"+node("select")+"\n"+node("runnable"))))
+	assert.False(t, blocked([]byte(caller+"\n"+node("sleep"))))
+
+	// goroutines started by the nodes are theirs, whatever they run
+	helper := "goroutine 12 [runnable]:\ngithub.com/orcaman/concurrent-map.ConcurrentMap.Items.func1()\n" +
+		"created by github.com/orcaman/concurrent-map.ConcurrentMap.Items in goroutine 7\n"
+	assert.False(t, blocked([]byte(caller+"\n"+node("chan receive")+"\n"+helper)))
+	assert.True(t, blocked([]byte(caller+"\n"+helper)))
+}
+
+func newSim(t *testing.T, nodeNames ...string) *Sim {
+	s := New(1, rsnet.InterfaceSettings{
+		AnnounceInterval: time.Second,
+		HoldTime:         time.Second * 3,
+	})
+	for _, nodeName := range nodeNames {
+		require.NoError(t, s.AddNode(nodeName))
+	}
+
+	return s
+}
+
+// path returns the path of the route from one node to another, or nil if there isn't one
+func path(s *Sim, from, to string) []string {
+	return s.Routes(from)[to].Path
+}
+
+func TestSim_Converges(t *testing.T) {
+	s := newSim(t, "a", "b", "c", "d")
+	defer s.Close()
+	s.Connect("a", "b", memnet.Link{Delay: time.Millisecond * 10})
+	s.Connect("b", "c", memnet.Link{Delay: time.Millisecond * 10})
+	s.Connect("c", "d", memnet.Link{Delay: time.Millisecond * 10})
+
+	took, ok := s.RunUntilConverged(time.Second * 30)
+	require.True(t, ok)
+	assert.True(t, took < time.Second*5, "took %v", took)
+
+	assert.Equal(t, []string{"a", "b", "c", "d"}, path(s, "a", "d"))
+	assert.Equal(t, []string{"d", "c", "b", "a"}, path(s, "d", "a"))
+}
+
+func TestSim_Converges_Grid(t *testing.T) {
+	const rows, cols = 5, 10
+
+	s := New(1, rsnet.InterfaceSettings{
+		AnnounceInterval: time.Millisecond * 100,
+		CostFunc:         func(time.Duration, float64) int { return 1 },
+	})
+	defer s.Close()
+
+	name := func(r, c int) string {
+		return fmt.Sprintf("n%d-%d", r, c)
+	}
+	// only nodes next to each other in the grid can hear each other
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			if r+1 < rows {
+				s.Connect(name(r, c), name(r+1, c), memnet.Link{})
+			}
+			if c+1 < cols {
+				s.Connect(name(r, c), name(r, c+1), memnet.Link{})
+			}
+		}
+	}
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			require.NoError(t, s.AddNode(name(r, c)))
+		}
+	}
+
+	// every node has a shortest path to every other, which is as long as the distance through the grid
+	converged := func() bool {
+		for r1 := 0; r1 < rows; r1++ {
+			for c1 := 0; c1 < cols; c1++ {
+				routes := s.Routes(name(r1, c1))
+				for r2 := 0; r2 < rows; r2++ {
+					for c2 := 0; c2 < cols; c2++ {
+						if r1 == r2 && c1 == c2 {
+							continue
+						}
+						route, ok := routes[name(r2, c2)]
+						if !ok || route.Cost != abs(r1-r2)+abs(c1-c2) {
+							return false
+						}
+					}
+				}
+			}
+		}
+
+		return true
+	}
+	for !converged() {
+		require.True(t, s.Elapsed() < time.Second*30, "routes didn't converge")
+		s.RunFor(time.Millisecond * 100)
+	}
+
+	assert.Len(t, s.Node(name(0, 0)).Neighbors(), 2)
+	assert.Len(t, s.Node(name(2, 2)).Neighbors(), 4)
+	assert.Equal(t, rows+cols-2, len(path(s, name(0, 0), name(rows-1, cols-1)))-1)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
+
+func TestSim_LinkFailure(t *testing.T) {
+	// a ring, where a's shortest route to c is through b
+	s := newSim(t, "a", "b", "c", "d")
+	defer s.Close()
+	s.Connect("a", "b", memnet.Link{Delay: time.Millisecond})
+	s.Connect("b", "c", memnet.Link{Delay: time.Millisecond})
+	s.Connect("c", "d", memnet.Link{Delay: time.Millisecond * 20})
+	s.Connect("d", "a", memnet.Link{Delay: time.Millisecond * 20})
+
+	s.At(time.Second*10, func() {
+		s.LinkDown("a", "b")
+	})
+
+	s.RunUntil(time.Second * 5)
+	require.True(t, s.Converged())
+	assert.Equal(t, []string{"a", "b", "c"}, path(s, "a", "c"))
+
+	// until the hold time runs out, a still thinks b is connected
+	s.RunUntil(time.Second*12 + time.Millisecond*500)
+	assert.Equal(t, []string{"a", "b", "c"}, path(s, "a", "c"))
+	assert.False(t, s.Converged())
+
+	s.RunUntil(time.Second * 15)
+	assert.True(t, s.Converged())
+	assert.Equal(t, []string{"a", "d", "c"}, path(s, "a", "c"))
+	assert.Equal(t, []string{"a", "d", "c", "b"}, path(s, "a", "b"))
+
+	// the route through d is still valid, so a switches back once it hears from b again
+	s.LinkUp("a", "b")
+	s.RunFor(time.Second * 5)
+	assert.True(t, s.Converged())
+	assert.Equal(t, []string{"a", "b", "c"}, path(s, "a", "c"))
+}
+
+func TestSim_Partition(t *testing.T) {
+	s := newSim(t, "a", "b", "c", "d")
+	defer s.Close()
+	s.Connect("a", "b", memnet.Link{})
+	s.Connect("b", "c", memnet.Link{})
+	s.Connect("c", "d", memnet.Link{})
+	_, ok := s.RunUntilConverged(time.Second * 30)
+	require.True(t, ok)
+
+	s.Partition([]string{"a", "b"}, []string{"c", "d"})
+	_, ok = s.RunUntilConverged(time.Second * 30)
+	require.True(t, ok)
+	assert.Len(t, s.Routes("a"), 1)
+	assert.Nil(t, path(s, "a", "d"))
+	assert.Equal(t, []string{"d", "c"}, path(s, "d", "c"))
+
+	s.Heal()
+	_, ok = s.RunUntilConverged(time.Second * 30)
+	require.True(t, ok)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, path(s, "a", "d"))
+}
+
+func TestSim_NodeJoinAndLeave(t *testing.T) {
+	s := newSim(t, "a", "b")
+	defer s.Close()
+	s.Connect("a", "b", memnet.Link{})
+	s.Connect("b", "c", memnet.Link{})
+
+	s.At(time.Second*5, func() {
+		require.NoError(t, s.AddNode("c"))
+	})
+	s.At(time.Second*20, func() {
+		require.NoError(t, s.RemoveNode("c"))
+	})
+
+	s.RunUntil(time.Second * 4)
+	assert.Nil(t, path(s, "a", "c"))
+
+	s.RunUntil(time.Second * 10)
+	assert.Equal(t, []string{"a", "b", "c"}, path(s, "a", "c"))
+	assert.True(t, s.Converged())
+
+	assert.Equal(t, ErrNodeExists, s.AddNode("c"))
+
+	s.RunUntil(time.Second * 30)
+	assert.Nil(t, s.Node("c"))
+	assert.Nil(t, path(s, "a", "c"))
+	assert.True(t, s.Converged())
+	assert.Equal(t, []string{"a", "b"}, s.Nodes())
+}
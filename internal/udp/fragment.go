@@ -0,0 +1,202 @@
+package udp
+
+import (
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Frames larger than maxFrameSize are split into fragments, each sent as its own frame
+// with the fragment flag set. The payload of a fragment frame is:
+//
+//	offset  size  field
+//	0       4     message ID, shared by all fragments of a frame
+//	4       2     fragment index
+//	6       2     fragment count
+//	8       n     fragment data
+//
+// Concatenating the data of every fragment in index order gives the original frame.
+const (
+	// maxFrameSize keeps datagrams within a typical 1500 byte ethernet MTU, after IP and UDP headers
+	maxFrameSize = 1400
+	// fragmentHeaderSize is the size of the fragment fields preceding the data
+	fragmentHeaderSize = 8
+	// fragmentDataSize is the number of bytes of the original frame carried by each fragment
+	fragmentDataSize = maxFrameSize - frameHeaderSize - fragmentHeaderSize
+
+	// maxMessageSize is the largest frame which can be fragmented
+	maxMessageSize = 1 << 20
+	// reassemblyTimeout is how long to wait for the rest of a message's fragments after the first arrives
+	reassemblyTimeout = time.Second * 5
+	// maxReassemblyBytes caps the memory used by partially reassembled messages on each reader
+	maxReassemblyBytes = maxMessageSize * 4
+)
+
+var (
+	// ErrMessageTooLarge is returned when writing a message larger than can be fragmented
+	ErrMessageTooLarge = errors.New("message too large")
+	// ErrBadFragment is returned when receiving a fragment with inconsistent fields
+	ErrBadFragment = errors.New("bad fragment")
+)
+
+var nextMessageID = rand.New(rand.NewSource(time.Now().UnixNano())).Uint32()
+
+// fragment splits the frame into fragment frames of at most maxFrameSize.
+// Frames which already fit are returned as is.
+func fragment(frame []byte) ([][]byte, error) {
+	if len(frame) <= maxFrameSize {
+		return [][]byte{frame}, nil
+	}
+	if len(frame) > maxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	msgID := atomic.AddUint32(&nextMessageID, 1)
+	count := (len(frame) + fragmentDataSize - 1) / fragmentDataSize
+	fragments := make([][]byte, 0, count)
+
+	for i := 0; i < count; i++ {
+		data := frame[i*fragmentDataSize:]
+		if len(data) > fragmentDataSize {
+			data = data[:fragmentDataSize]
+		}
+
+		var e Encoder
+		e.PutUint32(msgID)
+		e.PutUint16(uint16(i))
+		e.PutUint16(uint16(count))
+		payload := append(e.Encoded(), data...)
+
+		h := frameHeader{frame[3], flagFragment, uint32(len(payload))}
+		fragments = append(fragments, appendFrame(make([]byte, 0, frameHeaderSize+len(payload)), h, payload))
+	}
+
+	return fragments, nil
+}
+
+type partialKey struct {
+	src   string
+	msgID uint32
+}
+
+// partial is a message which has only received some of its fragments
+type partial struct {
+	fragments [][]byte
+	received  int
+	size      int
+	started   time.Time
+}
+
+// reassembler collects fragments until a whole frame has arrived.
+// It is not safe for concurrent use.
+type reassembler struct {
+	partials map[partialKey]*partial
+	// order holds partial keys oldest first, for eviction
+	order   []partialKey
+	size    int
+	maxSize int
+	timeout time.Duration
+	now     func() time.Time
+}
+
+func newReassembler(maxSize int, timeout time.Duration) *reassembler {
+	return &reassembler{
+		partials: make(map[partialKey]*partial),
+		maxSize:  maxSize,
+		timeout:  timeout,
+		now:      time.Now,
+	}
+}
+
+// add adds the payload of a fragment frame received from src.
+// Returns the original frame once every fragment has arrived, otherwise nil.
+func (r *reassembler) add(src string, payload []byte) ([]byte, error) {
+	r.expire()
+
+	if len(payload) < fragmentHeaderSize {
+		return nil, ErrTruncated
+	}
+	d := NewDecoder(payload[:fragmentHeaderSize])
+	key := partialKey{src, d.GetUint32()}
+	index := int(d.GetUint16())
+	count := int(d.GetUint16())
+	data := payload[fragmentHeaderSize:]
+
+	if count == 0 || index >= count || count*fragmentDataSize > maxMessageSize+fragmentDataSize || len(data) > fragmentDataSize {
+		return nil, ErrBadFragment
+	}
+
+	p, ok := r.partials[key]
+	if !ok {
+		p = &partial{
+			fragments: make([][]byte, count),
+			started:   r.now(),
+		}
+		r.partials[key] = p
+		r.order = append(r.order, key)
+	}
+	if len(p.fragments) != count {
+		r.remove(key)
+		return nil, ErrBadFragment
+	}
+	if p.fragments[index] != nil {
+		// duplicate
+		return nil, nil
+	}
+
+	p.fragments[index] = append([]byte{}, data...)
+	p.received++
+	p.size += len(data)
+	r.size += len(data)
+
+	if p.received == count {
+		frame := make([]byte, 0, p.size)
+		for _, f := range p.fragments {
+			frame = append(frame, f...)
+		}
+		r.remove(key)
+
+		return frame, nil
+	}
+
+	// evict the oldest messages to stay under the memory cap, possibly including this one
+	for r.size > r.maxSize && len(r.order) > 0 {
+		log.Debug().Str("src", r.order[0].src).Uint32("msgID", r.order[0].msgID).Msg("Reassembly memory full, dropping partial message")
+		r.remove(r.order[0])
+	}
+
+	return nil, nil
+}
+
+// expire drops partial messages which have been waiting longer than the timeout
+func (r *reassembler) expire() {
+	now := r.now()
+	for len(r.order) > 0 {
+		key := r.order[0]
+		if now.Sub(r.partials[key].started) <= r.timeout {
+			return
+		}
+
+		log.Debug().Str("src", key.src).Uint32("msgID", key.msgID).Msg("Reassembly timed out, dropping partial message")
+		r.remove(key)
+	}
+}
+
+func (r *reassembler) remove(key partialKey) {
+	p, ok := r.partials[key]
+	if !ok {
+		return
+	}
+
+	r.size -= p.size
+	delete(r.partials, key)
+	for i, k := range r.order {
+		if k == key {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package udp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFragment_Small(t *testing.T) {
+	frame, err := Marshal(s{"hello"})
+	require.NoError(t, err)
+
+	fragments, err := fragment(frame)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{frame}, fragments)
+}
+
+func TestFragment_TooLarge(t *testing.T) {
+	_, err := fragment(make([]byte, maxMessageSize+1))
+	assert.Equal(t, ErrMessageTooLarge, err)
+}
+
+func TestFragment_Reassemble(t *testing.T) {
+	frame, err := Marshal(s{string(bytes.Repeat([]byte("a"), maxFrameSize*3))})
+	require.NoError(t, err)
+
+	fragments, err := fragment(frame)
+	require.NoError(t, err)
+	require.Len(t, fragments, 4)
+
+	r := newReassembler(maxReassemblyBytes, reassemblyTimeout)
+
+	// out of order, with a duplicate
+	order := []int{2, 0, 2, 3, 1}
+	var reassembled []byte
+	for i, idx := range order {
+		f := fragments[idx]
+		assert.True(t, len(f) <= maxFrameSize)
+
+		h, payload, err := parseFrame(f)
+		require.NoError(t, err)
+		assert.NotZero(t, h.flags&flagFragment)
+
+		reassembled, err = r.add("src", payload)
+		require.NoError(t, err)
+		if i < len(order)-1 {
+			assert.Nil(t, reassembled)
+		}
+	}
+
+	assert.Equal(t, frame, reassembled)
+	assert.Empty(t, r.partials)
+	assert.Zero(t, r.size)
+
+	_, err = Unmarshal(fragments[0])
+	assert.Equal(t, ErrFragment, err)
+}
+
+func TestFragment_Timeout(t *testing.T) {
+	frame := make([]byte, maxFrameSize*2)
+	fragments, err := fragment(frame)
+	require.NoError(t, err)
+
+	now := time.Now()
+	r := newReassembler(maxReassemblyBytes, time.Second)
+	r.now = func() time.Time { return now }
+
+	_, payload, _ := parseFrame(fragments[0])
+	_, err = r.add("src", payload)
+	require.NoError(t, err)
+	assert.Len(t, r.partials, 1)
+
+	now = now.Add(time.Second * 2)
+	_, payload, _ = parseFrame(fragments[1])
+	reassembled, err := r.add("src", payload)
+	require.NoError(t, err)
+	assert.Nil(t, reassembled)
+	// the first fragment was dropped, so the message can never complete
+	assert.Equal(t, 1, r.partials[r.order[0]].received)
+}
+
+func TestFragment_MemoryCap(t *testing.T) {
+	r := newReassembler(fragmentDataSize*2, reassemblyTimeout)
+
+	for i := 0; i < 3; i++ {
+		fragments, err := fragment(make([]byte, maxFrameSize*2))
+		require.NoError(t, err)
+
+		_, payload, _ := parseFrame(fragments[0])
+		_, err = r.add("src", payload)
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, r.partials, 2)
+	assert.True(t, r.size <= fragmentDataSize*2)
+}
+
+func TestFragment_BadFragment(t *testing.T) {
+	r := newReassembler(maxReassemblyBytes, reassemblyTimeout)
+
+	var e Encoder
+	e.PutUint32(1)
+	e.PutUint16(2)
+	e.PutUint16(2)
+	_, err := r.add("src", e.Encoded())
+	assert.Equal(t, ErrBadFragment, err)
+
+	_, err = r.add("src", []byte{1, 2})
+	assert.Equal(t, ErrTruncated, err)
+}
@@ -13,9 +13,5 @@ type NetWriter interface {
 	WriteAddr() string
 }
 
-// Message is a basic serializable message
-type Message struct {
-	Data interface{}
-}
-
-const maxDatagramSize = 8192
+// maxDatagramSize is the largest possible UDP datagram payload
+const maxDatagramSize = 65507
@@ -1,8 +1,6 @@
 package udp
 
 import (
-	"bytes"
-	"encoding/gob"
 	"net"
 	"strings"
 	"time"
@@ -17,6 +15,8 @@ type listenFunc func(network string, laddr *net.UDPAddr) (*net.UDPConn, error)
 type UDPWriter struct {
 	addr       *net.UDPAddr
 	addrString string
+	networkKey []byte
+	stats      *tagStats
 }
 
 // NewUDPWriter creates a new writer that writes to the given address (host:port)
@@ -33,6 +33,16 @@ func NewUDPWriter(addr string) (*UDPWriter, error) {
 	}, nil
 }
 
+// SetNetworkKey makes the writer append a MAC to every datagram, keyed with the network key
+func (u *UDPWriter) SetNetworkKey(key []byte) {
+	u.networkKey = key
+}
+
+// SetTag counts the datagrams written in the stats of the tag, see TagStats
+func (u *UDPWriter) SetTag(tag string) {
+	u.stats = statsFor(tag)
+}
+
 // write opens a writes a UDP datagram to the configured address and port.
 func (u *UDPWriter) Write(data interface{}) error {
 	conn, err := net.DialUDP("udp4", nil, u.addr)
@@ -43,24 +53,32 @@ func (u *UDPWriter) Write(data interface{}) error {
 	defer conn.Close()
 	conn.SetWriteBuffer(maxDatagramSize)
 
-	// need to use a buffer instead of writing directly to the wire
-	// since gob will attempt to send type information as separate
-	// udp datagrams, which we don't want!
-	var buf bytes.Buffer
-	encoder := gob.NewEncoder(&buf)
-	err = encoder.Encode(Message{data})
+	frame, err := Marshal(data)
 	if err != nil {
 		log.Error().Err(err).Msg("Encode failure")
 		return err
 	}
 
-	len, err := conn.Write(buf.Bytes())
+	fragments, err := fragment(frame)
 	if err != nil {
-		log.Error().Err(err).Msg("Write failure")
+		log.Error().Err(err).Int("len", len(frame)).Msg("Fragment failure")
 		return err
 	}
 
-	log.Debug().Int("len", len).Interface("data", data).Msg("wrote message")
+	for _, f := range fragments {
+		if u.networkKey != nil {
+			f = appendMAC(u.networkKey, f)
+		}
+		if _, err := conn.Write(f); err != nil {
+			log.Error().Err(err).Msg("Write failure")
+			return err
+		}
+		if u.stats != nil {
+			u.stats.addOut(len(f))
+		}
+	}
+
+	log.Debug().Int("len", len(frame)).Int("fragments", len(fragments)).Interface("data", data).Msg("wrote message")
 
 	return nil
 }
@@ -71,7 +89,8 @@ func (u *UDPWriter) WriteAddr() string {
 }
 
 // startReceiving starts listening on the Net, and returns a channel which will yield messages when they arrive.
-func startReceiving(addr *net.UDPAddr, stopChan chan bool, doneStoppingChan chan bool, listenFunc listenFunc, tag string) (<-chan interface{}, resetFunc, error) {
+// If networkKey is set, datagrams without a valid MAC are dropped.
+func startReceiving(addr *net.UDPAddr, stopChan chan bool, doneStoppingChan chan bool, listenFunc listenFunc, tag string, networkKey []byte) (<-chan interface{}, resetFunc, error) {
 	listener, err := listenFunc("udp4", addr)
 	if err != nil {
 		log.Error().Err(err).Msg("ListenUDP failure")
@@ -80,7 +99,11 @@ func startReceiving(addr *net.UDPAddr, stopChan chan bool, doneStoppingChan chan
 	listener.SetReadBuffer(maxDatagramSize)
 
 	dataChan := make(chan interface{})
+	stats := statsFor(tag)
 	go func(dc chan interface{}) {
+		b := make([]byte, maxDatagramSize)
+		reassembler := newReassembler(maxReassemblyBytes, reassemblyTimeout)
+
 		for {
 			select {
 			case <-stopChan:
@@ -91,7 +114,6 @@ func startReceiving(addr *net.UDPAddr, stopChan chan bool, doneStoppingChan chan
 
 			listener.SetReadDeadline(time.Now().Add(time.Second * 2))
 
-			b := make([]byte, maxDatagramSize)
 			len, src, err := listener.ReadFromUDP(b)
 			if err != nil && strings.Index(err.Error(), "i/o timeout") < 0 {
 				log.Error().Err(err).Msg("Accept failure")
@@ -103,17 +125,40 @@ func startReceiving(addr *net.UDPAddr, stopChan chan bool, doneStoppingChan chan
 				continue
 			}
 
-			var data Message
-			r := bytes.NewReader(b)
-			decoder := gob.NewDecoder(r)
-			err = decoder.Decode(&data)
+			stats.addIn(len)
+
+			frame := b[:len]
+			if networkKey != nil {
+				if frame, err = checkMAC(networkKey, frame); err != nil {
+					stats.addBadMAC()
+					log.Debug().Err(err).Str("tag", tag).Interface("src", src).Msg("Dropping datagram")
+					continue
+				}
+			}
+
+			if h, payload, err := parseFrame(frame); err == nil && h.flags&flagFragment != 0 {
+				frame, err = reassembler.add(src.String(), payload)
+				if err != nil {
+					stats.addDecodeError()
+					log.Error().Err(err).Msg("Reassembly failure")
+					continue
+				}
+				if frame == nil {
+					// waiting for more fragments
+					continue
+				}
+			}
+
+			// decoders copy what they need, so the buffer can be reused for the next datagram
+			data, err := Unmarshal(frame)
 			if err != nil {
+				stats.addDecodeError()
 				log.Error().Err(err).Msg("Read failure")
 				continue
 			}
 
 			log.Debug().Str("tag", tag).Interface("src", src).Interface("message", data).Msg("msg in")
-			dataChan <- data.Data
+			dataChan <- data
 		}
 	}(dataChan)
 
@@ -16,6 +16,8 @@ type MulticastReader struct {
 
 	stopListener func()
 	ErrChan      <-chan error
+
+	networkKey []byte
 }
 
 // NewMulticastReader creates a new net struct used for receiving from the given address (hostname:port)
@@ -46,12 +48,18 @@ func (n *MulticastReader) StartReceiving(tag string) (<-chan interface{}, error)
 		return net.ListenMulticastUDP(network, nil, gaddr)
 	}
 
-	msgChan, resetFunc, err := startReceiving(n.addr, n.stopChan, n.doneStoppingChan, listenFunc, tag)
+	msgChan, resetFunc, err := startReceiving(n.addr, n.stopChan, n.doneStoppingChan, listenFunc, tag, n.networkKey)
 	n.stopListener = resetFunc
 
 	return msgChan, err
 }
 
+// SetNetworkKey makes the reader drop datagrams without a valid MAC keyed with the network key.
+// Must be called before StartReceiving.
+func (n *MulticastReader) SetNetworkKey(key []byte) {
+	n.networkKey = key
+}
+
 // StopReceiving closes channels and stops the receive loop
 func (n *MulticastReader) StopReceiving() {
 	n.stopChan <- true
@@ -0,0 +1,64 @@
+package udp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// With a network key, every frame is followed by a MAC: HMAC-SHA256 of the frame keyed with the
+// network key, truncated to macSize bytes. The keyed flag is set in the frame header, and covered by the MAC.
+// Readers with a network key drop datagrams without a valid MAC before decoding them, so datagrams from
+// nodes in other networks, or anything else sent to the same port, are ignored.
+const (
+	macSize = 16
+
+	networkKeyContext = "rsec-net network key v1"
+)
+
+// ErrBadMAC is returned when a datagram's MAC is missing, or doesn't match the network key
+var ErrBadMAC = errors.New("missing or bad network key MAC")
+
+// DeriveNetworkKey derives the network key from a passphrase shared by every node in the network
+func DeriveNetworkKey(passphrase string) []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(passphrase), nil, []byte(networkKeyContext)), key); err != nil {
+		// HKDF can only fail when reading more than 255 hashes of output
+		panic(err)
+	}
+
+	return key
+}
+
+func computeMAC(key, datagram []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(datagram)
+
+	return h.Sum(nil)[:macSize]
+}
+
+// appendMAC sets the keyed flag in the datagram's frame header, and appends its MAC
+func appendMAC(key, datagram []byte) []byte {
+	keyed := make([]byte, len(datagram), len(datagram)+macSize)
+	copy(keyed, datagram)
+	keyed[4] |= flagKeyed
+
+	return append(keyed, computeMAC(key, keyed)...)
+}
+
+// checkMAC verifies the MAC of a datagram, and returns the datagram without it
+func checkMAC(key, datagram []byte) ([]byte, error) {
+	if len(datagram) < frameHeaderSize+macSize || datagram[4]&flagKeyed == 0 {
+		return nil, ErrBadMAC
+	}
+
+	body, mac := datagram[:len(datagram)-macSize], datagram[len(datagram)-macSize:]
+	if !hmac.Equal(mac, computeMAC(key, body)) {
+		return nil, ErrBadMAC
+	}
+
+	return body, nil
+}
@@ -0,0 +1,86 @@
+package udp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const keyedAddr = ":1150"
+
+func TestDeriveNetworkKey(t *testing.T) {
+	assert.Equal(t, DeriveNetworkKey("secret"), DeriveNetworkKey("secret"))
+	assert.NotEqual(t, DeriveNetworkKey("secret"), DeriveNetworkKey("other secret"))
+	assert.Len(t, DeriveNetworkKey("secret"), 32)
+}
+
+func TestCheckMAC(t *testing.T) {
+	key := DeriveNetworkKey("secret")
+	frame, err := Marshal(s{"hello"})
+	require.NoError(t, err)
+
+	keyed := appendMAC(key, frame)
+	assert.Len(t, keyed, len(frame)+macSize)
+
+	body, err := checkMAC(key, keyed)
+	require.NoError(t, err)
+	m, err := Unmarshal(body)
+	require.NoError(t, err)
+	assert.Equal(t, s{"hello"}, m)
+
+	_, err = checkMAC(DeriveNetworkKey("other secret"), keyed)
+	assert.Equal(t, ErrBadMAC, err, "wrong key")
+
+	_, err = checkMAC(key, frame)
+	assert.Equal(t, ErrBadMAC, err, "no MAC")
+
+	tampered := append([]byte{}, keyed...)
+	tampered[frameHeaderSize] ^= 1
+	_, err = checkMAC(key, tampered)
+	assert.Equal(t, ErrBadMAC, err, "tampered")
+
+	_, err = checkMAC(key, keyed[:macSize])
+	assert.Equal(t, ErrBadMAC, err, "truncated")
+}
+
+func TestNet_NetworkKey(t *testing.T) {
+	key := DeriveNetworkKey("secret")
+
+	r, err := NewUniReader(keyedAddr)
+	require.NoError(t, err)
+	r.SetNetworkKey(key)
+	recv, err := r.StartReceiving("keyed")
+	require.NoError(t, err)
+	defer r.StopReceiving()
+
+	unkeyed, err := NewUDPWriter(keyedAddr)
+	require.NoError(t, err)
+	wrongKey, err := NewUDPWriter(keyedAddr)
+	require.NoError(t, err)
+	wrongKey.SetNetworkKey(DeriveNetworkKey("other secret"))
+	keyed, err := NewUDPWriter(keyedAddr)
+	require.NoError(t, err)
+	keyed.SetNetworkKey(key)
+	keyed.SetTag("keyed")
+
+	require.NoError(t, unkeyed.Write(s{"unkeyed"}))
+	require.NoError(t, wrongKey.Write(s{"wrong key"}))
+	require.NoError(t, keyed.Write(s{"keyed"}))
+
+	select {
+	case m := <-recv:
+		assert.Equal(t, s{"keyed"}, m)
+	case <-time.After(time.Second):
+		t.Fatal("keyed message not received")
+	}
+
+	stats := TagStats()["keyed"]
+	assert.Equal(t, uint64(3), stats.PacketsIn)
+	assert.Equal(t, uint64(1), stats.PacketsOut)
+	frame, err := Marshal(s{"keyed"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(frame)+macSize), stats.BytesOut)
+	assert.Equal(t, uint64(2), stats.BadMAC)
+}
@@ -0,0 +1,82 @@
+package udp
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Stats are running totals of the datagrams read and written with a tag.
+// Readers are tagged by StartReceiving, and writers by SetTag. Untagged writers aren't counted.
+type Stats struct {
+	PacketsIn  uint64
+	BytesIn    uint64
+	PacketsOut uint64
+	BytesOut   uint64
+	// DecodeErrors is the number of datagrams read which couldn't be reassembled or decoded
+	DecodeErrors uint64
+	// BadMAC is the number of datagrams read which were dropped for a missing or bad network key MAC
+	BadMAC uint64
+}
+
+// tagStats is updated atomically by the readers and writers sharing a tag
+type tagStats struct {
+	packetsIn, bytesIn   uint64
+	packetsOut, bytesOut uint64
+	decodeErrors, badMAC uint64
+}
+
+var (
+	stats   = make(map[string]*tagStats)
+	statsMu sync.Mutex
+)
+
+func statsFor(tag string) *tagStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	s, ok := stats[tag]
+	if !ok {
+		s = &tagStats{}
+		stats[tag] = s
+	}
+
+	return s
+}
+
+func (s *tagStats) addIn(n int) {
+	atomic.AddUint64(&s.packetsIn, 1)
+	atomic.AddUint64(&s.bytesIn, uint64(n))
+}
+
+func (s *tagStats) addOut(n int) {
+	atomic.AddUint64(&s.packetsOut, 1)
+	atomic.AddUint64(&s.bytesOut, uint64(n))
+}
+
+func (s *tagStats) addDecodeError() {
+	atomic.AddUint64(&s.decodeErrors, 1)
+}
+
+func (s *tagStats) addBadMAC() {
+	atomic.AddUint64(&s.badMAC, 1)
+}
+
+// TagStats returns a snapshot of the stats of every tag used so far
+func TagStats() map[string]Stats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	snapshot := make(map[string]Stats, len(stats))
+	for tag, s := range stats {
+		snapshot[tag] = Stats{
+			PacketsIn:    atomic.LoadUint64(&s.packetsIn),
+			BytesIn:      atomic.LoadUint64(&s.bytesIn),
+			PacketsOut:   atomic.LoadUint64(&s.packetsOut),
+			BytesOut:     atomic.LoadUint64(&s.bytesOut),
+			DecodeErrors: atomic.LoadUint64(&s.decodeErrors),
+			BadMAC:       atomic.LoadUint64(&s.badMAC),
+		}
+	}
+
+	return snapshot
+}
@@ -16,6 +16,8 @@ type UniReader struct {
 
 	stopListener func()
 	ErrChan      <-chan error
+
+	networkKey []byte
 }
 
 // NewUniReader creates a new net struct used for receiving from the given address (hostname:port)
@@ -42,12 +44,18 @@ func NewUniReader(addr string) (*UniReader, error) {
 
 // StartReceiving starts listening on the Net, and returns a channel which will yield messages when they arrive.
 func (n *UniReader) StartReceiving(tag string) (<-chan interface{}, error) {
-	msgChan, resetFunc, err := startReceiving(n.addr, n.stopChan, n.doneStoppingChan, net.ListenUDP, tag)
+	msgChan, resetFunc, err := startReceiving(n.addr, n.stopChan, n.doneStoppingChan, net.ListenUDP, tag, n.networkKey)
 	n.stopListener = resetFunc
 
 	return msgChan, err
 }
 
+// SetNetworkKey makes the reader drop datagrams without a valid MAC keyed with the network key.
+// Must be called before StartReceiving.
+func (n *UniReader) SetNetworkKey(key []byte) {
+	n.networkKey = key
+}
+
 // StopReceiving closes channels and stops the receive loop
 func (n *UniReader) StopReceiving() {
 	n.stopChan <- true
@@ -1,7 +1,7 @@
 package udp
 
 import (
-	"encoding/gob"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -21,6 +21,26 @@ type s struct {
 	St string
 }
 
+const testWireType = 200
+
+func (m s) WireType() uint8 {
+	return testWireType
+}
+
+func (m s) MarshalBinary() ([]byte, error) {
+	var e Encoder
+	e.PutString(m.St)
+
+	return e.Encoded(), nil
+}
+
+func decodeS(payload []byte) (interface{}, error) {
+	d := NewDecoder(payload)
+	m := s{d.GetString()}
+
+	return m, d.Err()
+}
+
 func init() {
 	w, err := NewUDPWriter(addr)
 	if err != nil {
@@ -40,8 +60,7 @@ func init() {
 	testReader = n
 	recvChan = recv
 
-	// needed to send the type through gob
-	gob.Register(s{})
+	Register(testWireType, decodeS)
 }
 
 func TestNet_SendReceive(t *testing.T) {
@@ -79,3 +98,17 @@ func TestNet_SendReceiveMultiple(t *testing.T) {
 	assert.True(t, ok)
 	assert.Equal(t, "goodbye", sIn2.St)
 }
+
+func TestNet_SendReceiveLarge(t *testing.T) {
+	w, err := NewUDPWriter(addr)
+	require.NoError(t, err)
+
+	large := strings.Repeat("large message ", 2000)
+	err = w.Write(s{large})
+	require.NoError(t, err)
+
+	msgIn := <-recvChan
+	sIn, ok := msgIn.(s)
+	assert.True(t, ok)
+	assert.Equal(t, large, sIn.St)
+}
@@ -0,0 +1,342 @@
+package udp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Every datagram is a single frame, laid out as (multi-byte fields are big endian):
+//
+//	offset  size  field
+//	0       2     magic, "RS"
+//	2       1     protocol version
+//	3       1     packet type, identifying how to decode the payload
+//	4       1     flags
+//	5       4     payload length
+//	9       n     payload
+//
+// Payloads are built from the primitives written by Encoder: fixed size unsigned integers,
+// uvarints, and byte strings prefixed by their length as a uvarint.
+//
+// If the fragment flag is set, the payload is one fragment of a larger frame (see fragment.go).
+// If the keyed flag is set, the frame is followed by a network key MAC (see netkey.go).
+// The other flag bits are reserved, and frames with any of them set are rejected.
+const (
+	frameMagic0 = 'R'
+	frameMagic1 = 'S'
+
+	// ProtocolVersion is the version of the wire format written by this package
+	ProtocolVersion = 1
+
+	frameHeaderSize = 9
+
+	// flagFragment marks a frame whose payload is a fragment of a larger frame
+	flagFragment = 1 << 0
+	// flagKeyed marks a frame followed by a network key MAC
+	flagKeyed = 1 << 1
+	// knownFlags are the flag bits which have a meaning
+	knownFlags = flagFragment | flagKeyed
+)
+
+var (
+	// ErrBadMagic is returned when decoding bytes which aren't a frame
+	ErrBadMagic = errors.New("bad frame magic")
+	// ErrUnsupportedVersion is returned when decoding a frame from a different protocol version
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	// ErrUnknownFlags is returned when decoding a frame with reserved flag bits set
+	ErrUnknownFlags = errors.New("unknown frame flags")
+	// ErrUnknownType is returned when decoding a frame with no registered decoder
+	ErrUnknownType = errors.New("unknown packet type")
+	// ErrTruncated is returned when a frame or payload is shorter than its contents
+	ErrTruncated = errors.New("truncated frame")
+	// ErrNotMarshaler is returned when writing a message which doesn't implement Marshaler
+	ErrNotMarshaler = errors.New("message does not implement Marshaler")
+	// ErrFragment is returned when unmarshaling a fragment, which must be reassembled first
+	ErrFragment = errors.New("frame is a fragment")
+)
+
+// frameHeader is the fixed size header at the start of each frame
+type frameHeader struct {
+	wireType uint8
+	flags    uint8
+	length   uint32
+}
+
+// Marshaler is implemented by messages which can be written to the wire
+type Marshaler interface {
+	// WireType identifies the decoder for the message, registered with Register
+	WireType() uint8
+	MarshalBinary() ([]byte, error)
+}
+
+// DecodeFunc decodes the payload of a frame into a message
+type DecodeFunc func(payload []byte) (interface{}, error)
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = make(map[uint8]DecodeFunc)
+)
+
+// Register sets the decoder for payloads of the given packet type.
+// Panics if the type already has a decoder.
+func Register(wireType uint8, decode DecodeFunc) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+
+	if _, ok := decoders[wireType]; ok {
+		panic(fmt.Sprintf("udp: decoder for packet type %d registered twice", wireType))
+	}
+	decoders[wireType] = decode
+}
+
+// Marshal encodes the message into a frame
+func Marshal(data interface{}) ([]byte, error) {
+	m, ok := data.(Marshaler)
+	if !ok {
+		return nil, ErrNotMarshaler
+	}
+
+	payload, err := m.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return appendFrame(nil, frameHeader{m.WireType(), 0, uint32(len(payload))}, payload), nil
+}
+
+// Unmarshal decodes a frame into a message using the decoder registered for its packet type
+func Unmarshal(frame []byte) (interface{}, error) {
+	h, payload, err := parseFrame(frame)
+	if err != nil {
+		return nil, err
+	}
+	if h.flags&flagFragment != 0 {
+		return nil, ErrFragment
+	}
+
+	decodersMu.RLock()
+	decode, ok := decoders[h.wireType]
+	decodersMu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownType
+	}
+
+	return decode(payload)
+}
+
+func appendFrame(buf []byte, h frameHeader, payload []byte) []byte {
+	buf = append(buf, frameMagic0, frameMagic1, ProtocolVersion, h.wireType, h.flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf[len(buf)-4:], h.length)
+
+	return append(buf, payload...)
+}
+
+// parseFrame validates the frame header, and returns it along with the payload
+func parseFrame(frame []byte) (frameHeader, []byte, error) {
+	if len(frame) < frameHeaderSize {
+		return frameHeader{}, nil, ErrTruncated
+	}
+	if frame[0] != frameMagic0 || frame[1] != frameMagic1 {
+		return frameHeader{}, nil, ErrBadMagic
+	}
+	if frame[2] != ProtocolVersion {
+		return frameHeader{}, nil, ErrUnsupportedVersion
+	}
+
+	h := frameHeader{
+		wireType: frame[3],
+		flags:    frame[4],
+		length:   binary.BigEndian.Uint32(frame[5:]),
+	}
+	if h.flags&^knownFlags != 0 {
+		return frameHeader{}, nil, ErrUnknownFlags
+	}
+	if uint32(len(frame)-frameHeaderSize) < h.length {
+		return frameHeader{}, nil, ErrTruncated
+	}
+
+	return h, frame[frameHeaderSize : frameHeaderSize+int(h.length)], nil
+}
+
+// Encoder builds a payload from primitive fields
+type Encoder struct {
+	buf []byte
+}
+
+// Encoded returns the payload built so far
+func (e *Encoder) Encoded() []byte {
+	return e.buf
+}
+
+// PutUint8 appends a single byte
+func (e *Encoder) PutUint8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+// PutBool appends a bool as a single byte
+func (e *Encoder) PutBool(v bool) {
+	if v {
+		e.PutUint8(1)
+	} else {
+		e.PutUint8(0)
+	}
+}
+
+// PutUint16 appends a big endian uint16
+func (e *Encoder) PutUint16(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+// PutUint32 appends a big endian uint32
+func (e *Encoder) PutUint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+// PutUint64 appends a big endian uint64
+func (e *Encoder) PutUint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+// PutUvarint appends a variable length unsigned integer
+func (e *Encoder) PutUvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	e.buf = append(e.buf, b[:n]...)
+}
+
+// PutBytes appends a byte string prefixed by its length
+func (e *Encoder) PutBytes(v []byte) {
+	e.PutUvarint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// PutString appends a string prefixed by its length
+func (e *Encoder) PutString(v string) {
+	e.PutUvarint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// Decoder reads primitive fields from a payload, in the order they were written by an Encoder.
+// Reading past the end of the payload returns zero values, and sets Err.
+type Decoder struct {
+	buf []byte
+	err error
+}
+
+// NewDecoder creates a decoder reading from the payload
+func NewDecoder(payload []byte) *Decoder {
+	return &Decoder{buf: payload}
+}
+
+// Err returns ErrTruncated if any field was read past the end of the payload
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+func (d *Decoder) next(n int) []byte {
+	if d.err != nil || n > len(d.buf) || n < 0 {
+		d.err = ErrTruncated
+		return nil
+	}
+
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+
+	return b
+}
+
+// GetUint8 reads a single byte
+func (d *Decoder) GetUint8() uint8 {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+
+	return b[0]
+}
+
+// GetBool reads a bool written as a single byte
+func (d *Decoder) GetBool() bool {
+	return d.GetUint8() != 0
+}
+
+// GetUint16 reads a big endian uint16
+func (d *Decoder) GetUint16() uint16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint16(b)
+}
+
+// GetUint32 reads a big endian uint32
+func (d *Decoder) GetUint32() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(b)
+}
+
+// GetUint64 reads a big endian uint64
+func (d *Decoder) GetUint64() uint64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(b)
+}
+
+// GetUvarint reads a variable length unsigned integer
+func (d *Decoder) GetUvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrTruncated
+		return 0
+	}
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+// GetBytes reads a byte string prefixed by its length
+func (d *Decoder) GetBytes() []byte {
+	n := d.GetUvarint()
+	if n > uint64(len(d.buf)) {
+		d.err = ErrTruncated
+		return nil
+	}
+
+	b := d.next(int(n))
+	if b == nil {
+		return nil
+	}
+
+	return append([]byte{}, b...)
+}
+
+// GetString reads a string prefixed by its length
+func (d *Decoder) GetString() string {
+	n := d.GetUvarint()
+	if n > uint64(len(d.buf)) {
+		d.err = ErrTruncated
+		return ""
+	}
+
+	return string(d.next(int(n)))
+}
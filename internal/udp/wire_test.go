@@ -0,0 +1,91 @@
+package udp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWire_MarshalUnmarshal(t *testing.T) {
+	frame, err := Marshal(s{"hello"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{'R', 'S', ProtocolVersion, testWireType, 0, 0, 0, 0, 6, 5, 'h', 'e', 'l', 'l', 'o'}, frame)
+
+	m, err := Unmarshal(frame)
+	require.NoError(t, err)
+	assert.Equal(t, s{"hello"}, m)
+}
+
+func TestWire_MarshalNotMarshaler(t *testing.T) {
+	_, err := Marshal(struct{}{})
+	assert.Equal(t, ErrNotMarshaler, err)
+}
+
+func TestWire_UnmarshalErrors(t *testing.T) {
+	frame, err := Marshal(s{"hello"})
+	require.NoError(t, err)
+
+	badMagic := append([]byte{}, frame...)
+	badMagic[0] = 'X'
+	_, err = Unmarshal(badMagic)
+	assert.Equal(t, ErrBadMagic, err)
+
+	badVersion := append([]byte{}, frame...)
+	badVersion[2] = ProtocolVersion + 1
+	_, err = Unmarshal(badVersion)
+	assert.Equal(t, ErrUnsupportedVersion, err)
+
+	badFlags := append([]byte{}, frame...)
+	badFlags[4] = 1 << 7
+	_, err = Unmarshal(badFlags)
+	assert.Equal(t, ErrUnknownFlags, err)
+
+	badType := append([]byte{}, frame...)
+	badType[3] = testWireType + 1
+	_, err = Unmarshal(badType)
+	assert.Equal(t, ErrUnknownType, err)
+
+	_, err = Unmarshal(frame[:len(frame)-1])
+	assert.Equal(t, ErrTruncated, err)
+
+	_, err = Unmarshal(frame[:4])
+	assert.Equal(t, ErrTruncated, err)
+}
+
+func TestWire_EncoderDecoder(t *testing.T) {
+	var e Encoder
+	e.PutUint8(1)
+	e.PutBool(true)
+	e.PutUint16(2)
+	e.PutUint32(3)
+	e.PutUint64(4)
+	e.PutUvarint(300)
+	e.PutBytes([]byte{5, 6})
+	e.PutString("seven")
+
+	d := NewDecoder(e.Encoded())
+	assert.Equal(t, uint8(1), d.GetUint8())
+	assert.Equal(t, true, d.GetBool())
+	assert.Equal(t, uint16(2), d.GetUint16())
+	assert.Equal(t, uint32(3), d.GetUint32())
+	assert.Equal(t, uint64(4), d.GetUint64())
+	assert.Equal(t, uint64(300), d.GetUvarint())
+	assert.Equal(t, []byte{5, 6}, d.GetBytes())
+	assert.Equal(t, "seven", d.GetString())
+	assert.NoError(t, d.Err())
+
+	// reading past the end
+	assert.Equal(t, uint32(0), d.GetUint32())
+	assert.Equal(t, ErrTruncated, d.Err())
+}
+
+func TestWire_DecoderBadLength(t *testing.T) {
+	var e Encoder
+	e.PutUvarint(1000)
+	e.PutUint8(1)
+
+	d := NewDecoder(e.Encoded())
+	assert.Nil(t, d.GetBytes())
+	assert.Equal(t, ErrTruncated, d.Err())
+}
@@ -0,0 +1,44 @@
+package net
+
+import "time"
+
+// Clock tells the time, and schedules timers and tickers for an interface.
+// The system clock is used unless InterfaceSettings.Clock is set, for example to a virtual clock in a simulation.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel which receives the time once d has elapsed, like time.After
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks at intervals, like time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the Clock of the operating system
+type SystemClock struct{}
+
+// Now returns time.Now()
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// After returns time.After(d)
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// NewTicker returns a ticker wrapping time.NewTicker(d)
+func (SystemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
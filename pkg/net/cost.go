@@ -0,0 +1,38 @@
+package net
+
+import (
+	"math"
+	"time"
+)
+
+// defaultCostHysteresis is the default fraction a link cost must change by before it is re-advertised
+const defaultCostHysteresis = 0.25
+
+// maxLoss caps the loss rate used in cost calculations, so lossy links stay usable as a last resort
+const maxLoss = 0.9
+
+// CostFunc computes the cost advertised for a link to a connected node,
+// from its smoothed round trip time and loss rate. Costs must be positive.
+type CostFunc func(rtt time.Duration, loss float64) int
+
+// DefaultCostFunc costs a link at one per millisecond of round trip time, plus one.
+// The cost is then scaled by the expected number of transmissions needed for a probe and its
+// reply to both get through, so lossy links are avoided.
+func DefaultCostFunc(rtt time.Duration, loss float64) int {
+	if loss > maxLoss {
+		loss = maxLoss
+	}
+
+	cost := float64(rtt/time.Millisecond) + 1
+	cost /= (1 - loss) * (1 - loss)
+
+	return int(math.Round(cost))
+}
+
+// costChanged returns true if the cost has moved far enough from the advertised cost to be re-advertised.
+// The change must be more than the hysteresis fraction of the advertised cost, and more than one.
+func costChanged(advertised, cost int, hysteresis float64) bool {
+	threshold := math.Max(1, float64(advertised)*hysteresis)
+
+	return math.Abs(float64(cost-advertised)) > threshold
+}
@@ -0,0 +1,77 @@
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultCostFunc(t *testing.T) {
+	assert.Equal(t, 1, DefaultCostFunc(0, 0))
+	assert.Equal(t, 11, DefaultCostFunc(time.Millisecond*10, 0))
+	assert.Equal(t, 44, DefaultCostFunc(time.Millisecond*10, 0.5))
+	// loss is capped
+	assert.Equal(t, DefaultCostFunc(time.Millisecond*10, maxLoss), DefaultCostFunc(time.Millisecond*10, 1))
+}
+
+func TestCostChanged(t *testing.T) {
+	assert.False(t, costChanged(1, 2, defaultCostHysteresis))
+	assert.True(t, costChanged(1, 3, defaultCostHysteresis))
+	assert.False(t, costChanged(20, 25, defaultCostHysteresis))
+	assert.True(t, costChanged(20, 26, defaultCostHysteresis))
+	assert.True(t, costChanged(20, 14, defaultCostHysteresis))
+}
+
+func setLatency(a *announceDaemon, nodeName string, rtt time.Duration) {
+	a.updateNode(nodeName, func(info *NodeInfo) {
+		info.Latency = latency{SmoothedRTT: rtt, Samples: 1}
+	})
+}
+
+func Test_Adjacencies_Hysteresis(t *testing.T) {
+	a, w := newLinkStateDaemon("n1")
+	a.costHysteresis = defaultCostHysteresis
+	a.connectedNodes.Set("n2", &NodeInfo{NodeName: "n2"})
+
+	setLatency(a, "n2", time.Millisecond*20)
+	a.originateLinkState()
+
+	// jitter within the threshold keeps the advertised cost
+	setLatency(a, "n2", time.Millisecond*24)
+	a.originateLinkState()
+
+	lsas := w.linkStates()
+	assert.Equal(t, 21, lsas[1].Adjacencies["n2"])
+	assert.Equal(t, uint16(1), lsas[1].SequenceNum)
+
+	setLatency(a, "n2", time.Millisecond*40)
+	a.originateLinkState()
+
+	lsas = w.linkStates()
+	assert.Equal(t, 41, lsas[2].Adjacencies["n2"])
+	assert.Equal(t, uint16(2), lsas[2].SequenceNum)
+
+	hop, _ := a.routes.NextHop("n2")
+	assert.Equal(t, "n2", hop)
+	assert.Equal(t, 41, a.routes.Routes()["n2"].Cost)
+}
+
+func Test_Adjacencies_CustomCostFunc(t *testing.T) {
+	a, _ := newLinkStateDaemon("n1")
+	a.costFunc = func(time.Duration, float64) int {
+		return 7
+	}
+	a.connectedNodes.Set("n2", &NodeInfo{NodeName: "n2"})
+
+	assert.Equal(t, map[string]int{"n2": 7}, a.adjacencies())
+}
+
+func Test_Adjacencies_LinkCosts(t *testing.T) {
+	a, _ := newLinkStateDaemon("n1")
+	a.linkCosts = map[string]int{"n2": 12}
+	a.connectedNodes.Set("n2", &NodeInfo{NodeName: "n2", Latency: latency{SmoothedRTT: time.Millisecond * 40}})
+	a.connectedNodes.Set("n3", &NodeInfo{NodeName: "n3", Latency: latency{SmoothedRTT: time.Millisecond * 40}})
+
+	assert.Equal(t, map[string]int{"n2": 12, "n3": 41}, a.adjacencies())
+}
@@ -0,0 +1,91 @@
+package net
+
+import "sync/atomic"
+
+// DropReason is why an interface dropped a packet
+type DropReason int
+
+const (
+	// DropReplay is an authenticated packet which was already received, or is too old to tell
+	DropReplay DropReason = iota
+	// DropBadSignature is an announcement or link state advertisement without a valid signature
+	// from the key bound to its node name
+	DropBadSignature
+	// DropUnsealed is a packet received on the data port without being sealed, when traffic is encrypted
+	DropUnsealed
+	// DropBadSeal is a sealed packet which failed to open, or was sealed by a node without a session
+	DropBadSeal
+	// DropTTLExpired is a data packet which reached its maximum number of hops before its destination
+	DropTTLExpired
+	// DropNoRoute is a data packet which couldn't be forwarded, since its destination is unreachable
+	DropNoRoute
+	// DropUnknown is a packet of a type, or data packet of a kind, which isn't expected
+	DropUnknown
+
+	numDropReasons
+)
+
+func (r DropReason) String() string {
+	switch r {
+	case DropReplay:
+		return "replay"
+	case DropBadSignature:
+		return "bad_signature"
+	case DropUnsealed:
+		return "unsealed"
+	case DropBadSeal:
+		return "bad_seal"
+	case DropTTLExpired:
+		return "ttl_expired"
+	case DropNoRoute:
+		return "no_route"
+	case DropUnknown:
+		return "unknown"
+	default:
+		return "unknown_reason"
+	}
+}
+
+// Counters are running totals of notable packets handled by an interface
+type Counters struct {
+	AnnouncementsSent     uint64
+	AnnouncementsReceived uint64
+	// RouteRecomputations is the number of times the routing table was recomputed
+	RouteRecomputations uint64
+	// Dropped is the number of packets dropped for each reason
+	Dropped map[DropReason]uint64
+}
+
+// counters is updated atomically by the goroutines handling packets
+type counters struct {
+	announcementsSent     uint64
+	announcementsReceived uint64
+	dropped               [numDropReasons]uint64
+}
+
+func (c *counters) addAnnouncementSent() {
+	atomic.AddUint64(&c.announcementsSent, 1)
+}
+
+func (c *counters) addAnnouncementReceived() {
+	atomic.AddUint64(&c.announcementsReceived, 1)
+}
+
+func (c *counters) addDropped(reason DropReason) {
+	atomic.AddUint64(&c.dropped[reason], 1)
+}
+
+// Counters returns a snapshot of the interface's counters
+func (n *Interface) Counters() Counters {
+	c := Counters{
+		AnnouncementsSent:     atomic.LoadUint64(&n.counters.announcementsSent),
+		AnnouncementsReceived: atomic.LoadUint64(&n.counters.announcementsReceived),
+		RouteRecomputations:   n.routes.Recomputations(),
+		Dropped:               make(map[DropReason]uint64, numDropReasons),
+	}
+	for reason := DropReason(0); reason < numDropReasons; reason++ {
+		c.Dropped[reason] = atomic.LoadUint64(&n.counters.dropped[reason])
+	}
+
+	return c
+}
@@ -0,0 +1,192 @@
+package net
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
+	"github.com/rs/zerolog/log"
+)
+
+// Echo requests are routed like any other data packet. Their destination answers with an echo reply,
+// carrying the same ID. A node which drops a data packet because its TTL ran out answers its source with
+// a time exceeded packet, carrying the destination, kind, and first timeExceededQuoteSize bytes of the
+// payload of the dropped packet. Sending echo requests with increasing TTLs reveals the route they take.
+const (
+	// timeExceededQuoteSize is how much of the dropped packet's payload is quoted, enough for an echo ID
+	timeExceededQuoteSize = 8
+)
+
+// EchoReply is the answer to an echo request
+type EchoReply struct {
+	// From is the node which answered: the destination, or the node where the request's TTL ran out
+	From string
+	// Reached is true if the destination answered
+	Reached bool
+	// Hops is the number of hops the answer took
+	Hops int
+	RTT  time.Duration
+}
+
+// echoState holds the echo requests waiting for an answer
+type echoState struct {
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan EchoReply
+}
+
+func newEchoState() *echoState {
+	return &echoState{
+		pending: make(map[uint32]chan EchoReply),
+	}
+}
+
+// Echo sends an echo request to the named node with the given TTL, and waits for the answer.
+// Returns the context's error if no answer arrives before it is done, or ErrNoRoute if the node is unreachable.
+func (n *Interface) Echo(ctx context.Context, nodeName string, ttl uint8) (EchoReply, error) {
+	if nodeName == n.ad.identity.NodeName {
+		return EchoReply{From: nodeName, Reached: true}, nil
+	}
+
+	e := n.echo
+	answered := make(chan EchoReply, 1)
+	e.mu.Lock()
+	e.nextID++
+	id := e.nextID
+	e.pending[id] = answered
+	e.mu.Unlock()
+
+	defer func() {
+		e.mu.Lock()
+		delete(e.pending, id)
+		e.mu.Unlock()
+	}()
+
+	var enc udp.Encoder
+	enc.PutUint32(id)
+	sent := n.settings.Clock.Now()
+	err := n.forward(DataPacket{
+		Source:  n.ad.identity.NodeName,
+		Dest:    nodeName,
+		TTL:     ttl,
+		Kind:    dataEcho,
+		Payload: enc.Encoded(),
+	})
+	if err != nil {
+		return EchoReply{}, err
+	}
+
+	select {
+	case reply := <-answered:
+		reply.RTT = n.settings.Clock.Now().Sub(sent)
+		return reply, nil
+	case <-ctx.Done():
+		return EchoReply{}, ctx.Err()
+	}
+}
+
+// Traceroute sends echo requests to the named node with increasing TTLs, until the node answers or maxHops
+// requests have been sent. Returns the answer to each request, with an empty From if it wasn't answered
+// within the timeout.
+func (n *Interface) Traceroute(ctx context.Context, nodeName string, maxHops int, timeout time.Duration) ([]EchoReply, error) {
+	var replies []EchoReply
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		hopCtx, cancel := context.WithTimeout(ctx, timeout)
+		reply, err := n.Echo(hopCtx, nodeName, uint8(ttl))
+		cancel()
+
+		switch {
+		case err == context.DeadlineExceeded && ctx.Err() == nil:
+			// this hop didn't answer, but later ones might
+			replies = append(replies, EchoReply{})
+			continue
+		case err != nil:
+			return replies, err
+		}
+
+		replies = append(replies, reply)
+		if reply.Reached {
+			break
+		}
+	}
+
+	return replies, nil
+}
+
+// handleEcho answers an echo request addressed to us
+func (n *Interface) handleEcho(p DataPacket) {
+	reply := DataPacket{
+		Source:  n.ad.identity.NodeName,
+		Dest:    p.Source,
+		TTL:     defaultTTL,
+		Kind:    dataEchoReply,
+		Payload: p.Payload,
+	}
+	if err := n.forward(reply); err != nil {
+		log.Debug().Err(err).Str("dest", p.Source).Msg("Unable to send echo reply")
+	}
+}
+
+// handleEchoReply wakes the sender waiting on the answered echo request
+func (n *Interface) handleEchoReply(p DataPacket) {
+	d := udp.NewDecoder(p.Payload)
+	id := d.GetUint32()
+	if d.Err() != nil {
+		return
+	}
+
+	n.echo.answer(id, EchoReply{From: p.Source, Reached: true, Hops: int(p.Hops) + 1})
+}
+
+// sendTimeExceeded tells the source of a packet that it was dropped here, since its TTL ran out
+func (n *Interface) sendTimeExceeded(p DataPacket) {
+	if p.Kind == dataTimeExceeded {
+		// never answer an answer
+		return
+	}
+
+	quote := p.Payload
+	if len(quote) > timeExceededQuoteSize {
+		quote = quote[:timeExceededQuoteSize]
+	}
+
+	var e udp.Encoder
+	e.PutString(p.Dest)
+	e.PutUint8(p.Kind)
+	e.PutBytes(quote)
+	reply := DataPacket{
+		Source:  n.ad.identity.NodeName,
+		Dest:    p.Source,
+		TTL:     defaultTTL,
+		Kind:    dataTimeExceeded,
+		Payload: e.Encoded(),
+	}
+	if err := n.forward(reply); err != nil {
+		log.Debug().Err(err).Str("dest", p.Source).Msg("Unable to send time exceeded")
+	}
+}
+
+// handleTimeExceeded wakes the sender waiting on an echo request which was dropped on the way
+func (n *Interface) handleTimeExceeded(p DataPacket) {
+	d := udp.NewDecoder(p.Payload)
+	d.GetString()
+	kind := d.GetUint8()
+	quote := udp.NewDecoder(d.GetBytes())
+	id := quote.GetUint32()
+	if d.Err() != nil || quote.Err() != nil || kind != dataEcho {
+		return
+	}
+
+	n.echo.answer(id, EchoReply{From: p.Source, Hops: int(p.Hops) + 1})
+}
+
+func (e *echoState) answer(id uint32, reply EchoReply) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if answered, ok := e.pending[id]; ok {
+		answered <- reply
+		delete(e.pending, id)
+	}
+}
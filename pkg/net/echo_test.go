@@ -0,0 +1,79 @@
+package net
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newEchoChain creates three interfaces connected as n1 <-> n2 <-> n3, with routes between the ends
+func newEchoChain(t *testing.T) (*Interface, *Interface, *Interface) {
+	n1 := newForwardingInterface(t, "n1", "localhost:1171")
+	n2 := newForwardingInterface(t, "n2", "localhost:1172")
+	n3 := newForwardingInterface(t, "n3", "localhost:1173")
+
+	connect(n1, n2)
+	connect(n2, n1)
+	connect(n2, n3)
+	connect(n3, n2)
+	lsa := &LinkStatePacket{Packet{1, 0}, "n2", map[string]int{"n1": 1, "n3": 1}, nil, nil}
+	n1.routes.UpdateLinkState(lsa)
+	n3.routes.UpdateLinkState(lsa)
+
+	return n1, n2, n3
+}
+
+func TestInterface_Echo(t *testing.T) {
+	n1, n2, n3 := newEchoChain(t)
+	defer stopForwarding(n1, n2, n3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reply, err := n1.Echo(ctx, "n3", defaultTTL)
+	require.NoError(t, err)
+	assert.Equal(t, "n3", reply.From)
+	assert.True(t, reply.Reached)
+	assert.Equal(t, 2, reply.Hops)
+	assert.True(t, reply.RTT > 0)
+
+	// runs out one hop short
+	reply, err = n1.Echo(ctx, "n3", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "n2", reply.From)
+	assert.False(t, reply.Reached)
+	assert.Equal(t, uint64(1), n2.Counters().Dropped[DropTTLExpired])
+
+	_, err = n1.Echo(ctx, "nowhere", defaultTTL)
+	assert.Equal(t, ErrNoRoute, err)
+}
+
+func TestInterface_Echo_Timeout(t *testing.T) {
+	n1, n2, n3 := newEchoChain(t)
+	defer stopForwarding(n1, n2, n3)
+	// n3 has no route back
+	n3.routes.RemoveNode("n1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	_, err := n1.Echo(ctx, "n3", defaultTTL)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Empty(t, n1.echo.pending)
+}
+
+func TestInterface_Traceroute(t *testing.T) {
+	n1, n2, n3 := newEchoChain(t)
+	defer stopForwarding(n1, n2, n3)
+
+	replies, err := n1.Traceroute(context.Background(), "n3", defaultTTL, time.Second)
+	require.NoError(t, err)
+	require.Len(t, replies, 2)
+	assert.Equal(t, "n2", replies[0].From)
+	assert.False(t, replies[0].Reached)
+	assert.Equal(t, "n3", replies[1].From)
+	assert.True(t, replies[1].Reached)
+}
@@ -0,0 +1,147 @@
+package net
+
+import (
+	"errors"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultTTL is the maximum number of hops a data packet may take before being dropped
+const defaultTTL = 16
+
+var (
+	// ErrNoRoute is returned when sending to a node that isn't reachable
+	ErrNoRoute = errors.New("no route to node")
+	// ErrUnknownAddr is returned when sending to an address which doesn't belong to a connected node
+	ErrUnknownAddr = errors.New("address does not belong to a connected node")
+)
+
+// SendTo sends the payload to the named node, forwarding it over multiple hops if needed.
+func (n *Interface) SendTo(nodeName string, payload []byte) error {
+	p := DataPacket{
+		Source:  n.ad.identity.NodeName,
+		Dest:    nodeName,
+		TTL:     defaultTTL,
+		Payload: payload,
+	}
+
+	if nodeName == p.Source {
+		n.deliver(p, 0)
+		return nil
+	}
+
+	return n.forward(p)
+}
+
+// SendToAddr sends the payload directly to the connected node listening on addr (host:port).
+func (n *Interface) SendToAddr(addr string, payload []byte) error {
+	for _, e := range n.ad.connectedNodes.Items() {
+		info := e.(*NodeInfo)
+		if info.Addr != addr {
+			continue
+		}
+
+		return n.write(info, DataPacket{
+			Source:  n.ad.identity.NodeName,
+			Dest:    info.NodeName,
+			TTL:     defaultTTL,
+			Payload: payload,
+		})
+	}
+
+	return ErrUnknownAddr
+}
+
+// handleData delivers data packets addressed to us, and forwards everything else towards its destination.
+func (n *Interface) handleData(p DataPacket) {
+	if p.Dest == n.ad.identity.NodeName {
+		switch p.Kind {
+		case dataUser:
+			n.deliver(p, int(p.Hops)+1)
+		case dataReliable:
+			n.handleReliable(p)
+		case dataAck:
+			n.handleAck(p)
+		case dataStream:
+			n.handleStreamSegment(p)
+		case dataEcho:
+			n.handleEcho(p)
+		case dataEchoReply:
+			n.handleEchoReply(p)
+		case dataTimeExceeded:
+			n.handleTimeExceeded(p)
+		default:
+			n.counters.addDropped(DropUnknown)
+			log.Debug().Uint8("kind", p.Kind).Str("source", p.Source).Msg("Dropping data packet of unknown kind")
+		}
+		return
+	}
+
+	if p.TTL <= 1 {
+		n.counters.addDropped(DropTTLExpired)
+		log.Debug().Str("source", p.Source).Str("dest", p.Dest).Msg("Dropping data packet with expired TTL")
+		n.sendTimeExceeded(p)
+		return
+	}
+	p.TTL--
+	p.Hops++
+
+	if err := n.forward(p); err != nil {
+		if err == ErrNoRoute {
+			n.counters.addDropped(DropNoRoute)
+		}
+		log.Debug().Err(err).Str("source", p.Source).Str("dest", p.Dest).Msg("Unable to forward data packet")
+	}
+}
+
+func (n *Interface) deliver(p DataPacket, hops int) {
+	n.inbox.put(Envelope{
+		Source:   p.Source,
+		Dest:     p.Dest,
+		Hops:     hops,
+		Received: n.settings.Clock.Now(),
+		Payload:  p.Payload,
+	})
+}
+
+// forward sends the packet to the next hop on the route to its destination
+func (n *Interface) forward(p DataPacket) error {
+	hop, ok := n.routes.NextHop(p.Dest)
+	if !ok {
+		return ErrNoRoute
+	}
+
+	e, ok := n.ad.connectedNodes.Get(hop)
+	if !ok {
+		// the routing table is ahead of our connected nodes
+		return ErrNoRoute
+	}
+
+	return n.write(e.(*NodeInfo), p)
+}
+
+// write sends the packet directly to the connected node, sealing it first if traffic is encrypted
+func (n *Interface) write(info *NodeInfo, p interface{}) error {
+	if n.security != nil {
+		sealed, err := n.seal(info, p)
+		if err != nil {
+			return err
+		}
+		p = sealed
+	}
+
+	var w udp.NetWriter
+	if e, ok := n.dataSend.Get(info.Addr); ok {
+		w = e.(udp.NetWriter)
+	} else {
+		nw, err := n.settings.NewWriter(info.Addr)
+		if err != nil {
+			return err
+		}
+		n.dataSend.SetIfAbsent(info.Addr, nw)
+		w = nw
+	}
+
+	return w.Write(p)
+}
@@ -0,0 +1,123 @@
+package net
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chanReader is a udp.NetReader which never receives anything
+type chanReader struct {
+	c chan interface{}
+}
+
+func (c *chanReader) StartReceiving(string) (<-chan interface{}, error) {
+	c.c = make(chan interface{})
+	return c.c, nil
+}
+
+func (c *chanReader) StopReceiving() {
+	close(c.c)
+}
+
+func (c *chanReader) ReadAddr() string {
+	return "chanReader"
+}
+
+func newForwardingInterface(t *testing.T, nodeName, addr string) *Interface {
+	dr, err := udp.NewUniReader(addr)
+	require.NoError(t, err)
+
+	n, err := NewInterface(context.Background(), nodeName, dr, &recordingWriter{}, &chanReader{}, InterfaceSettings{AnnounceInterval: time.Second})
+	require.NoError(t, err)
+
+	return n
+}
+
+// connect makes b a connected node of a
+func connect(a, b *Interface) {
+	a.ad.connectedNodes.Set(b.ad.identity.NodeName, &NodeInfo{NodeName: b.ad.identity.NodeName, Addr: b.ad.identity.Addr})
+	a.routes.SetNeighbors(a.ad.adjacencies())
+}
+
+func stopForwarding(nodes ...*Interface) {
+	for _, n := range nodes {
+		n.dataReceive.StopReceiving()
+	}
+}
+
+func TestInterface_SendTo_MultiHop(t *testing.T) {
+	n1 := newForwardingInterface(t, "n1", "localhost:1161")
+	n2 := newForwardingInterface(t, "n2", "localhost:1162")
+	n3 := newForwardingInterface(t, "n3", "localhost:1163")
+	defer stopForwarding(n1, n2, n3)
+
+	// n1 <-> n2 <-> n3
+	connect(n1, n2)
+	connect(n2, n1)
+	connect(n2, n3)
+	connect(n3, n2)
+	n1.routes.UpdateLinkState(&LinkStatePacket{Packet{1, 0}, "n2", map[string]int{"n1": 1, "n3": 1}, nil, nil})
+
+	err := n1.SendTo("n3", []byte("hello"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	e, err := n3.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "n1", e.Source)
+	assert.Equal(t, "n3", e.Dest)
+	assert.Equal(t, 2, e.Hops)
+	assert.Equal(t, []byte("hello"), e.Payload)
+
+	assert.Equal(t, 0, n2.inbox.len(), "intermediate node delivered packet")
+}
+
+func TestInterface_SendTo_NoRoute(t *testing.T) {
+	n1 := newForwardingInterface(t, "n1", "localhost:1164")
+	defer stopForwarding(n1)
+
+	err := n1.SendTo("nowhere", []byte("hello"))
+	assert.Equal(t, ErrNoRoute, err)
+}
+
+func TestInterface_HandleData_CountsDrops(t *testing.T) {
+	n1 := newForwardingInterface(t, "n1", "localhost:1167")
+	defer stopForwarding(n1)
+
+	n1.handleData(DataPacket{Source: "n0", Dest: "nowhere", TTL: 1})
+	n1.handleData(DataPacket{Source: "n0", Dest: "nowhere", TTL: defaultTTL})
+	n1.handleData(DataPacket{Source: "n0", Dest: "n1", TTL: defaultTTL, Kind: 200})
+
+	dropped := n1.Counters().Dropped
+	assert.Equal(t, uint64(1), dropped[DropTTLExpired])
+	assert.Equal(t, uint64(1), dropped[DropNoRoute])
+	assert.Equal(t, uint64(1), dropped[DropUnknown])
+}
+
+func TestInterface_SendToAddr(t *testing.T) {
+	n1 := newForwardingInterface(t, "n1", "localhost:1165")
+	n2 := newForwardingInterface(t, "n2", "localhost:1166")
+	defer stopForwarding(n1, n2)
+
+	err := n1.SendToAddr("localhost:1166", []byte("hello"))
+	assert.Equal(t, ErrUnknownAddr, err)
+
+	connect(n1, n2)
+	err = n1.SendToAddr("localhost:1166", []byte("hello"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	e, err := n2.ReceiveFrom(ctx, "n1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, e.Hops)
+	assert.Equal(t, []byte("hello"), e.Payload)
+}
@@ -0,0 +1,125 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// inboxSize is the number of received messages buffered before the oldest are dropped
+const inboxSize = 256
+
+// ErrClosed is returned when receiving from a closed interface
+var ErrClosed = errors.New("interface closed")
+
+// Envelope is a message received from the network, along with information about how it arrived
+type Envelope struct {
+	Source   string
+	Dest     string
+	Hops     int
+	Received time.Time
+	Payload  []byte
+}
+
+// inbox buffers received envelopes until they are taken by a receiver
+type inbox struct {
+	mu        sync.Mutex
+	envelopes []Envelope
+	// notify is closed and replaced whenever an envelope is added, waking any waiting receivers
+	notify chan struct{}
+	closed bool
+	size   int
+}
+
+func newInbox(size int) *inbox {
+	return &inbox{
+		notify: make(chan struct{}),
+		size:   size,
+	}
+}
+
+// put adds the envelope to the inbox, dropping the oldest envelope if the inbox is full
+func (i *inbox) put(e Envelope) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.closed {
+		return
+	}
+
+	if len(i.envelopes) >= i.size {
+		log.Error().Str("source", i.envelopes[0].Source).Msg("Inbox full, dropping oldest message")
+		i.envelopes = i.envelopes[1:]
+	}
+	i.envelopes = append(i.envelopes, e)
+
+	close(i.notify)
+	i.notify = make(chan struct{})
+}
+
+// take removes and returns the oldest envelope accepted by match, waiting for one to arrive if needed.
+func (i *inbox) take(ctx context.Context, match func(*Envelope) bool) (Envelope, error) {
+	for {
+		i.mu.Lock()
+		for idx := range i.envelopes {
+			if match(&i.envelopes[idx]) {
+				e := i.envelopes[idx]
+				i.envelopes = append(i.envelopes[:idx], i.envelopes[idx+1:]...)
+				i.mu.Unlock()
+
+				return e, nil
+			}
+		}
+
+		if i.closed {
+			i.mu.Unlock()
+			return Envelope{}, ErrClosed
+		}
+		notify := i.notify
+		i.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Envelope{}, ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+// len returns the number of envelopes waiting to be taken
+func (i *inbox) len() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return len(i.envelopes)
+}
+
+// close wakes all waiting receivers. Envelopes already in the inbox can still be taken.
+func (i *inbox) close() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if !i.closed {
+		i.closed = true
+		close(i.notify)
+	}
+}
+
+// Receive returns the next message received from any node.
+// Blocks until a message arrives, the context is done, or the interface is closed.
+func (n *Interface) Receive(ctx context.Context) (Envelope, error) {
+	return n.inbox.take(ctx, func(*Envelope) bool {
+		return true
+	})
+}
+
+// ReceiveFrom returns the next message received from the named node.
+// Messages from other nodes are left for other receivers.
+func (n *Interface) ReceiveFrom(ctx context.Context, nodeName string) (Envelope, error) {
+	return n.inbox.take(ctx, func(e *Envelope) bool {
+		return e.Source == nodeName
+	})
+}
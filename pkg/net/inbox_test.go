@@ -0,0 +1,71 @@
+package net
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInbox_TakeFrom(t *testing.T) {
+	i := newInbox(10)
+	i.put(Envelope{Source: "n1", Payload: []byte("a")})
+	i.put(Envelope{Source: "n2", Payload: []byte("b")})
+	i.put(Envelope{Source: "n1", Payload: []byte("c")})
+
+	fromN2 := func(e *Envelope) bool { return e.Source == "n2" }
+	anyNode := func(*Envelope) bool { return true }
+
+	e, err := i.take(context.Background(), fromN2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("b"), e.Payload)
+
+	e, err = i.take(context.Background(), anyNode)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("a"), e.Payload)
+
+	e, err = i.take(context.Background(), anyNode)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("c"), e.Payload)
+}
+
+func TestInbox_Wait(t *testing.T) {
+	i := newInbox(10)
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		i.put(Envelope{Source: "n1"})
+		i.put(Envelope{Source: "n2"})
+	}()
+
+	e, err := i.take(context.Background(), func(e *Envelope) bool { return e.Source == "n2" })
+	require.NoError(t, err)
+	assert.Equal(t, "n2", e.Source)
+}
+
+func TestInbox_ContextAndClose(t *testing.T) {
+	i := newInbox(10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err := i.take(ctx, func(*Envelope) bool { return true })
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	go i.close()
+	_, err = i.take(context.Background(), func(*Envelope) bool { return true })
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestInbox_DropsOldest(t *testing.T) {
+	i := newInbox(2)
+	i.put(Envelope{Source: "n1"})
+	i.put(Envelope{Source: "n2"})
+	i.put(Envelope{Source: "n3"})
+
+	require.Equal(t, 2, i.len())
+	e, err := i.take(context.Background(), func(*Envelope) bool { return true })
+	require.NoError(t, err)
+	assert.Equal(t, "n2", e.Source)
+}
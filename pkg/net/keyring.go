@@ -0,0 +1,95 @@
+package net
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrKeyMismatch is returned when a node name is claimed by a different key than the one it is bound to
+	ErrKeyMismatch = errors.New("node name is bound to a different identity key")
+	// ErrBadPublicKey is returned when parsing a malformed public key
+	ErrBadPublicKey = errors.New("malformed public key")
+)
+
+// keyring binds node names to identity keys. Names on the trusted list are pinned to their listed key,
+// and other names are bound to the first key seen claiming them (trust on first use).
+type keyring struct {
+	mu     sync.Mutex
+	bound  map[string]ed25519.PublicKey
+	pinned map[string]ed25519.PublicKey
+}
+
+func newKeyring(trusted map[string]ed25519.PublicKey) *keyring {
+	k := &keyring{
+		bound:  make(map[string]ed25519.PublicKey),
+		pinned: make(map[string]ed25519.PublicKey),
+	}
+	for nodeName, key := range trusted {
+		k.pinned[nodeName] = key
+	}
+
+	return k
+}
+
+// bind binds the node name to the key, if it isn't bound already
+func (k *keyring) bind(nodeName string, key ed25519.PublicKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.bound[nodeName]; !ok {
+		k.bound[nodeName] = key
+	}
+}
+
+// check returns ErrKeyMismatch unless the key is the one bound to the node name,
+// binding it if the name is new.
+func (k *keyring) check(nodeName string, key ed25519.PublicKey) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	expected, ok := k.pinned[nodeName]
+	if !ok {
+		expected, ok = k.bound[nodeName]
+	}
+	if !ok {
+		log.Info().Str("nodeName", nodeName).Str("fingerprint", Fingerprint(key)).Msg("Binding node name to identity key")
+		k.bound[nodeName] = key
+		return nil
+	}
+
+	if !bytes.Equal(expected, key) {
+		log.Warn().Str("nodeName", nodeName).Str("fingerprint", Fingerprint(key)).Str("expected", Fingerprint(expected)).Msg("Node name claimed by a different identity key")
+		return ErrKeyMismatch
+	}
+
+	return nil
+}
+
+// Fingerprint returns a short digest of an identity key, for comparing keys by eye
+func Fingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// EncodePublicKey encodes an identity key as base64, the form used in trusted key lists
+func EncodePublicKey(key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// ParsePublicKey parses an identity key encoded by EncodePublicKey
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, ErrBadPublicKey
+	}
+
+	return ed25519.PublicKey(b), nil
+}
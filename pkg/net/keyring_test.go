@@ -0,0 +1,45 @@
+package net
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPublicKey(t *testing.T) ed25519.PublicKey {
+	key, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return key
+}
+
+func TestKeyring_Check(t *testing.T) {
+	pinned, first, other := newPublicKey(t), newPublicKey(t), newPublicKey(t)
+	k := newKeyring(map[string]ed25519.PublicKey{"n1": pinned})
+
+	assert.Equal(t, ErrKeyMismatch, k.check("n1", first), "pinned name accepted first key seen")
+	assert.NoError(t, k.check("n1", pinned))
+
+	assert.NoError(t, k.check("n2", first))
+	assert.NoError(t, k.check("n2", first))
+	assert.Equal(t, ErrKeyMismatch, k.check("n2", other))
+}
+
+func TestParsePublicKey(t *testing.T) {
+	key := newPublicKey(t)
+
+	parsed, err := ParsePublicKey(EncodePublicKey(key))
+	require.NoError(t, err)
+	assert.Equal(t, key, parsed)
+
+	_, err = ParsePublicKey("not base64")
+	assert.Equal(t, ErrBadPublicKey, err)
+	_, err = ParsePublicKey(EncodePublicKey(key[:10]))
+	assert.Equal(t, ErrBadPublicKey, err)
+
+	assert.Equal(t, Fingerprint(key), Fingerprint(parsed))
+	assert.NotEqual(t, Fingerprint(key), Fingerprint(newPublicKey(t)))
+}
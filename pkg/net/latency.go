@@ -1,4 +1,160 @@
 package net
 
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// latency contains round trip time statistics for a connected node, measured by probes.
+// Smoothing follows the TCP retransmission timer estimator (RFC 6298).
 type latency struct {
+	SmoothedRTT time.Duration
+	// Jitter is the smoothed mean deviation of the round trip time
+	Jitter time.Duration
+	MinRTT time.Duration
+	MaxRTT time.Duration
+	// Loss is the smoothed fraction of probes which went unanswered
+	Loss    float64
+	Samples int
+}
+
+// addSample updates the statistics with a measured round trip time
+func (l *latency) addSample(rtt time.Duration) {
+	if l.Samples == 0 {
+		l.SmoothedRTT = rtt
+		l.Jitter = rtt / 2
+		l.MinRTT = rtt
+		l.MaxRTT = rtt
+	} else {
+		deviation := l.SmoothedRTT - rtt
+		if deviation < 0 {
+			deviation = -deviation
+		}
+		l.Jitter = (3*l.Jitter + deviation) / 4
+		l.SmoothedRTT = (7*l.SmoothedRTT + rtt) / 8
+
+		if rtt < l.MinRTT {
+			l.MinRTT = rtt
+		}
+		if rtt > l.MaxRTT {
+			l.MaxRTT = rtt
+		}
+	}
+
+	l.Samples++
+	l.addLoss(false)
+}
+
+// addLoss updates the loss rate with the outcome of a probe
+func (l *latency) addLoss(lost bool) {
+	sample := 0.0
+	if lost {
+		sample = 1.0
+	}
+
+	l.Loss = (7*l.Loss + sample) / 8
+}
+
+// prober tracks the latency probes sent to each connected node
+type prober struct {
+	mu    sync.Mutex
+	seqNo uint32
+	// pending holds the sequence number of the unanswered probe sent to each node
+	pending map[string]uint32
+}
+
+func newProber() *prober {
+	return &prober{
+		pending: make(map[string]uint32),
+	}
+}
+
+func (n *Interface) startProbing() {
+	probeTicker := n.settings.Clock.NewTicker(n.settings.ProbeInterval)
+	n.group.Go(func(ctx context.Context) error {
+		defer probeTicker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-probeTicker.C():
+				n.probe()
+			}
+		}
+	})
+}
+
+// probe sends a probe to every connected node, counting any probe still unanswered from last time as lost
+func (n *Interface) probe() {
+	n.prober.mu.Lock()
+	defer n.prober.mu.Unlock()
+
+	for nodeName, e := range n.ad.connectedNodes.Items() {
+		if _, ok := n.prober.pending[nodeName]; ok {
+			n.ad.updateNode(nodeName, func(info *NodeInfo) {
+				info.Latency.addLoss(true)
+			})
+		}
+
+		n.prober.seqNo++
+		n.prober.pending[nodeName] = n.prober.seqNo
+
+		p := ProbePacket{
+			Source: n.ad.identity.NodeName,
+			Target: nodeName,
+			SeqNo:  n.prober.seqNo,
+			SentAt: n.settings.Clock.Now().UnixNano(),
+		}
+		if err := n.write(e.(*NodeInfo), p); err != nil {
+			log.Debug().Err(err).Str("nodeName", nodeName).Msg("Unable to send probe")
+		}
+	}
+
+	// forget about nodes which are no longer connected
+	for nodeName := range n.prober.pending {
+		if !n.ad.connectedNodes.Has(nodeName) {
+			delete(n.prober.pending, nodeName)
+		}
+	}
+}
+
+// handleProbe echoes probes from connected nodes, and records the round trip time of replies to our own probes.
+func (n *Interface) handleProbe(p ProbePacket) {
+	if !p.Reply {
+		e, ok := n.ad.connectedNodes.Get(p.Source)
+		if !ok {
+			return
+		}
+
+		p.Reply = true
+		if err := n.write(e.(*NodeInfo), p); err != nil {
+			log.Debug().Err(err).Str("nodeName", p.Source).Msg("Unable to reply to probe")
+		}
+
+		return
+	}
+
+	if p.Source != n.ad.identity.NodeName {
+		return
+	}
+
+	n.prober.mu.Lock()
+	seqNo, ok := n.prober.pending[p.Target]
+	if !ok || seqNo != p.SeqNo {
+		// late reply to a probe which was already counted as lost
+		n.prober.mu.Unlock()
+		return
+	}
+	delete(n.prober.pending, p.Target)
+	n.prober.mu.Unlock()
+
+	rtt := n.settings.Clock.Now().Sub(time.Unix(0, p.SentAt))
+	n.ad.updateNode(p.Target, func(info *NodeInfo) {
+		info.Latency.addSample(rtt)
+	})
+	log.Debug().Str("nodeName", p.Target).Dur("rtt", rtt).Msg("Probe reply")
 }
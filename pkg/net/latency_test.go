@@ -0,0 +1,109 @@
+package net
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatency_AddSample(t *testing.T) {
+	l := latency{}
+	l.addSample(time.Millisecond * 10)
+
+	assert.Equal(t, time.Millisecond*10, l.SmoothedRTT)
+	assert.Equal(t, time.Millisecond*5, l.Jitter)
+
+	l.addSample(time.Millisecond * 18)
+
+	assert.Equal(t, time.Millisecond*11, l.SmoothedRTT)
+	assert.Equal(t, time.Millisecond*5+time.Millisecond*3/4, l.Jitter)
+	assert.Equal(t, time.Millisecond*10, l.MinRTT)
+	assert.Equal(t, time.Millisecond*18, l.MaxRTT)
+	assert.Equal(t, 2, l.Samples)
+	assert.Equal(t, 0.0, l.Loss)
+}
+
+func TestLatency_AddLoss(t *testing.T) {
+	l := latency{}
+	l.addLoss(true)
+	assert.Equal(t, 0.125, l.Loss)
+
+	l.addLoss(false)
+	assert.InDelta(t, 0.109, l.Loss, 0.001)
+}
+
+// newProbeInterface creates an interface whose writes are all recorded by w
+func newProbeInterface(t *testing.T, nodeName string, w *recordingWriter) *Interface {
+	settings := InterfaceSettings{
+		AnnounceInterval: time.Second,
+		DataAddr:         nodeName,
+		NewWriter: func(string) (udp.NetWriter, error) {
+			return w, nil
+		},
+	}
+	n, err := NewInterface(context.Background(), nodeName, &chanReader{}, &recordingWriter{}, &chanReader{}, settings)
+	require.NoError(t, err)
+
+	return n
+}
+
+func (r *recordingWriter) probes() []ProbePacket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	probes := []ProbePacket{}
+	for _, w := range r.written {
+		if p, ok := w.(ProbePacket); ok {
+			probes = append(probes, p)
+		}
+	}
+
+	return probes
+}
+
+func TestInterface_Probe(t *testing.T) {
+	w1, w2 := &recordingWriter{}, &recordingWriter{}
+	n1 := newProbeInterface(t, "n1", w1)
+	n2 := newProbeInterface(t, "n2", w2)
+	connect(n1, n2)
+	connect(n2, n1)
+
+	n1.probe()
+	require.Len(t, w1.probes(), 1)
+	req := w1.probes()[0]
+	assert.Equal(t, "n2", req.Target)
+	assert.False(t, req.Reply)
+
+	n2.handleProbe(req)
+	require.Len(t, w2.probes(), 1)
+	reply := w2.probes()[0]
+	assert.True(t, reply.Reply)
+
+	n1.handleProbe(reply)
+	e, _ := n1.ad.connectedNodes.Get("n2")
+	l := e.(*NodeInfo).Latency
+	assert.Equal(t, 1, l.Samples)
+	assert.True(t, l.SmoothedRTT > 0)
+
+	// a duplicate reply is ignored
+	n1.handleProbe(reply)
+	e, _ = n1.ad.connectedNodes.Get("n2")
+	assert.Equal(t, 1, e.(*NodeInfo).Latency.Samples)
+}
+
+func TestInterface_ProbeLoss(t *testing.T) {
+	w1 := &recordingWriter{}
+	n1 := newProbeInterface(t, "n1", w1)
+	n2 := newProbeInterface(t, "n2", &recordingWriter{})
+	connect(n1, n2)
+
+	n1.probe()
+	n1.probe()
+
+	e, _ := n1.ad.connectedNodes.Get("n2")
+	assert.Equal(t, 0.125, e.(*NodeInfo).Latency.Loss)
+}
@@ -0,0 +1,164 @@
+package net
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// lsaMaxAgeFactor is how many hold times an advertisement is kept without being refreshed by its origin.
+// Origins refresh their advertisement every hold time, so a few refreshes may be lost before it is purged.
+const lsaMaxAgeFactor = 3
+
+// linkStateEntry is an advertisement in the link state database, and when it was stored
+type linkStateEntry struct {
+	lsa      *LinkStatePacket
+	received time.Time
+}
+
+// adjacencies returns the cost of the link from this node to each of its connected nodes.
+// Costs are derived from measured latency, but stay at their last advertised value
+// until they change by more than the hysteresis threshold, so routes don't flap on jitter.
+// Links with a fixed cost always advertise it.
+func (a *announceDaemon) adjacencies() map[string]int {
+	costFunc := a.costFunc
+	if costFunc == nil {
+		costFunc = DefaultCostFunc
+	}
+
+	adj := make(map[string]int)
+	for nodeName, e := range a.connectedNodes.Items() {
+		if cost, ok := a.linkCosts[nodeName]; ok {
+			adj[nodeName] = cost
+			continue
+		}
+
+		l := e.(*NodeInfo).Latency
+		cost := costFunc(l.SmoothedRTT, l.Loss)
+		if cost < 1 {
+			cost = 1
+		}
+
+		if advertised, ok := a.advertisedCosts[nodeName]; ok && !costChanged(advertised, cost, a.costHysteresis) {
+			cost = advertised
+		}
+		adj[nodeName] = cost
+	}
+	a.advertisedCosts = adj
+
+	return adj
+}
+
+// originateLinkState floods this node's own link state advertisement.
+// As with announcements, the sequence number is only incremented when our adjacencies
+// have changed since the last advertisement, but the LSA is sent regardless.
+// It is also incremented once every hold time, so other nodes know we are still originating it.
+func (a *announceDaemon) originateLinkState() {
+	now := a.clock.Now()
+	adj := a.adjacencies()
+	changed := !reflect.DeepEqual(adj, a.lastAdjacencies)
+	if changed || (a.holdTime != 0 && now.Sub(a.lsaRefreshed) >= a.holdTime) {
+		a.lsaSeqNo++
+		a.lsaRefreshed = now
+	}
+	if changed {
+		a.lastAdjacencies = adj
+		a.routes.SetNeighbors(adj)
+	}
+
+	lsa := &LinkStatePacket{
+		Packet:      Packet{a.lsaSeqNo, a.epoch},
+		Origin:      a.identity.NodeName,
+		Adjacencies: adj,
+	}
+	if a.security != nil {
+		a.security.signLinkState(lsa)
+	}
+	a.lsdb.Set(lsa.Origin, &linkStateEntry{lsa, now})
+
+	log.Debug().Uint16("seqNo", a.lsaSeqNo).Interface("adjacencies", adj).Msg("Originating link state")
+	a.flood(lsa)
+}
+
+// handleLinkState stores an advertisement received from the network and re-floods it to our neighbors,
+// unless it is a duplicate or stale copy of one we have already seen.
+func (a *announceDaemon) handleLinkState(lsa *LinkStatePacket) {
+	if lsa.Origin == a.identity.NodeName {
+		// our own advertisement, flooded back to us by a neighbor
+		return
+	}
+
+	existing, ok := a.linkState(lsa.Origin)
+	if ok && !lsa.newerThan(existing.Packet) {
+		return
+	}
+
+	if a.security != nil {
+		if err := a.security.verifyLinkState(lsa); err != nil {
+			a.counters.addDropped(DropBadSignature)
+			log.Debug().Err(err).Str("origin", lsa.Origin).Msg("Ignoring unauthenticated link state")
+			return
+		}
+	}
+
+	a.lsdb.Set(lsa.Origin, &linkStateEntry{lsa, a.clock.Now()})
+	log.Debug().Str("origin", lsa.Origin).Uint16("seqNo", lsa.SequenceNum).Msg("New link state")
+	if !ok || !reflect.DeepEqual(lsa.Adjacencies, existing.Adjacencies) {
+		// refreshes don't change any routes
+		a.routes.UpdateLinkState(lsa)
+	}
+
+	a.flood(lsa)
+}
+
+// floodDatabase re-floods every advertisement we know about.
+// Nodes which have already seen them will drop the copies.
+func (a *announceDaemon) floodDatabase() {
+	for _, e := range a.lsdb.Items() {
+		a.flood(e.(*linkStateEntry).lsa)
+	}
+}
+
+// linkState returns the advertisement in the link state database from the origin
+func (a *announceDaemon) linkState(origin string) (*LinkStatePacket, bool) {
+	e, ok := a.lsdb.Get(origin)
+	if !ok {
+		return nil, false
+	}
+
+	return e.(*linkStateEntry).lsa, true
+}
+
+// expireLinkState purges advertisements which their origin hasn't refreshed within the max age,
+// so nodes which went down further away than our neighbors don't stay in the topology.
+func (a *announceDaemon) expireLinkState() {
+	if a.holdTime == 0 {
+		return
+	}
+
+	now := a.clock.Now()
+	maxAge := a.holdTime * lsaMaxAgeFactor
+	for origin, e := range a.lsdb.Items() {
+		if origin == a.identity.NodeName || now.Sub(e.(*linkStateEntry).received) <= maxAge {
+			continue
+		}
+
+		// the origin may have refreshed it since we took the snapshot
+		expired := a.lsdb.RemoveCb(origin, func(_ string, v interface{}, exists bool) bool {
+			return exists && now.Sub(v.(*linkStateEntry).received) > maxAge
+		})
+		if !expired {
+			continue
+		}
+
+		log.Info().Str("origin", origin).Msg("Link state expired")
+		a.routes.RemoveLinkState(origin)
+	}
+}
+
+func (a *announceDaemon) flood(lsa *LinkStatePacket) {
+	if err := a.w.Write(*lsa); err != nil {
+		log.Error().Err(err).Str("origin", lsa.Origin).Msg("Unable to flood link state")
+	}
+}
@@ -0,0 +1,206 @@
+package net
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	cmap "github.com/orcaman/concurrent-map"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingWriter is a udp.NetWriter which keeps everything written to it
+type recordingWriter struct {
+	mu      sync.Mutex
+	written []interface{}
+}
+
+func (r *recordingWriter) Write(data interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.written = append(r.written, data)
+
+	return nil
+}
+
+func (r *recordingWriter) WriteAddr() string {
+	return "recorder"
+}
+
+func (r *recordingWriter) linkStates() []LinkStatePacket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lsas := []LinkStatePacket{}
+	for _, w := range r.written {
+		if lsa, ok := w.(LinkStatePacket); ok {
+			lsas = append(lsas, lsa)
+		}
+	}
+
+	return lsas
+}
+
+func newLinkStateDaemon(nodeName string) (*announceDaemon, *recordingWriter) {
+	w := &recordingWriter{}
+
+	return &announceDaemon{
+		identity:       Identity{nodeName, "", nil},
+		clock:          SystemClock{},
+		w:              w,
+		connectedNodes: cmap.New(),
+		lsdb:           cmap.New(),
+		routes:         NewRoutingTable(nodeName),
+		counters:       &counters{},
+	}, w
+}
+
+func Test_HandleLinkState_FloodsNew(t *testing.T) {
+	a, w := newLinkStateDaemon("n1")
+
+	lsa := &LinkStatePacket{Packet{1, 0}, "n2", map[string]int{"n3": 1}, nil, nil}
+	a.handleLinkState(lsa)
+
+	stored, ok := a.linkState("n2")
+	require.True(t, ok)
+	assert.Equal(t, lsa, stored)
+	assert.Equal(t, []LinkStatePacket{*lsa}, w.linkStates())
+}
+
+func Test_HandleLinkState_DropsDuplicateAndStale(t *testing.T) {
+	a, w := newLinkStateDaemon("n1")
+
+	a.handleLinkState(&LinkStatePacket{Packet{5, 0}, "n2", map[string]int{"n3": 1}, nil, nil})
+	a.handleLinkState(&LinkStatePacket{Packet{5, 0}, "n2", map[string]int{"n3": 1}, nil, nil})
+	a.handleLinkState(&LinkStatePacket{Packet{4, 0}, "n2", map[string]int{}, nil, nil})
+
+	assert.Len(t, w.linkStates(), 1)
+
+	stored, _ := a.linkState("n2")
+	assert.Equal(t, uint16(5), stored.SequenceNum)
+
+	a.handleLinkState(&LinkStatePacket{Packet{6, 0}, "n2", map[string]int{}, nil, nil})
+	assert.Len(t, w.linkStates(), 2)
+}
+
+func Test_HandleLinkState_WraparoundAndRestart(t *testing.T) {
+	a, w := newLinkStateDaemon("n1")
+
+	a.handleLinkState(&LinkStatePacket{Packet{65535, 10}, "n2", map[string]int{}, nil, nil})
+	a.handleLinkState(&LinkStatePacket{Packet{0, 10}, "n2", map[string]int{"n3": 1}, nil, nil})
+	assert.Len(t, w.linkStates(), 2, "wrapped sequence number treated as stale")
+
+	// restarted, so counting from the beginning again
+	a.handleLinkState(&LinkStatePacket{Packet{1, 20}, "n2", map[string]int{"n4": 1}, nil, nil})
+	assert.Len(t, w.linkStates(), 3, "restarted origin treated as stale")
+
+	// delayed copy from before the restart
+	a.handleLinkState(&LinkStatePacket{Packet{2, 10}, "n2", map[string]int{}, nil, nil})
+	assert.Len(t, w.linkStates(), 3)
+
+	stored, _ := a.linkState("n2")
+	assert.Equal(t, map[string]int{"n4": 1}, stored.Adjacencies)
+}
+
+func Test_HandleLinkState_IgnoresOwn(t *testing.T) {
+	a, w := newLinkStateDaemon("n1")
+
+	a.handleLinkState(&LinkStatePacket{Packet{1, 0}, "n1", map[string]int{"n2": 1}, nil, nil})
+
+	assert.Empty(t, w.linkStates())
+	assert.False(t, a.lsdb.Has("n1"))
+}
+
+func Test_OriginateLinkState_SeqNo(t *testing.T) {
+	a, w := newLinkStateDaemon("n1")
+	a.connectedNodes.Set("n2", &NodeInfo{})
+
+	a.originateLinkState()
+	a.originateLinkState()
+
+	lsas := w.linkStates()
+	require.Len(t, lsas, 2)
+	assert.Equal(t, uint16(1), lsas[0].SequenceNum)
+	assert.Equal(t, uint16(1), lsas[1].SequenceNum)
+	assert.Equal(t, map[string]int{"n2": DefaultCostFunc(0, 0)}, lsas[1].Adjacencies)
+
+	a.connectedNodes.Set("n3", &NodeInfo{})
+	a.originateLinkState()
+
+	lsas = w.linkStates()
+	assert.Equal(t, uint16(2), lsas[2].SequenceNum)
+	assert.True(t, a.lsdb.Has("n1"))
+}
+
+func Test_OriginateLinkState_Refresh(t *testing.T) {
+	a, w := newLinkStateDaemon("n1")
+	a.holdTime = time.Second * 3
+	a.connectedNodes.Set("n2", &NodeInfo{})
+
+	a.originateLinkState()
+	a.originateLinkState()
+	a.lsaRefreshed = a.lsaRefreshed.Add(-a.holdTime)
+	a.originateLinkState()
+
+	lsas := w.linkStates()
+	require.Len(t, lsas, 3)
+	assert.Equal(t, uint16(1), lsas[1].SequenceNum)
+	assert.Equal(t, uint16(2), lsas[2].SequenceNum, "not refreshed after the hold time")
+	assert.Equal(t, lsas[1].Adjacencies, lsas[2].Adjacencies)
+}
+
+func Test_HandleLinkState_Refresh(t *testing.T) {
+	a, w := newLinkStateDaemon("n1")
+
+	a.handleLinkState(&LinkStatePacket{Packet{1, 0}, "n2", map[string]int{"n3": 1}, nil, nil})
+	a.lsdb.Set("n2", &linkStateEntry{&LinkStatePacket{Packet{1, 0}, "n2", map[string]int{"n3": 1}, nil, nil}, time.Time{}})
+	recomputations := a.routes.Recomputations()
+
+	a.handleLinkState(&LinkStatePacket{Packet{2, 0}, "n2", map[string]int{"n3": 1}, nil, nil})
+
+	assert.Len(t, w.linkStates(), 2)
+	e, _ := a.lsdb.Get("n2")
+	assert.False(t, e.(*linkStateEntry).received.IsZero())
+	assert.Equal(t, recomputations, a.routes.Recomputations(), "unchanged links recomputed")
+}
+
+func Test_ExpireLinkState(t *testing.T) {
+	a, _ := newLinkStateDaemon("n1")
+	a.holdTime = time.Second * 3
+	a.connectedNodes.Set("n2", &NodeInfo{})
+	a.originateLinkState()
+
+	maxAge := a.holdTime * lsaMaxAgeFactor
+	old := time.Now().Add(-maxAge - time.Second)
+	a.lsdb.Set("n2", &linkStateEntry{&LinkStatePacket{Packet{1, 0}, "n2", map[string]int{"n1": 1, "n3": 1}, nil, nil}, time.Now()})
+	a.lsdb.Set("n3", &linkStateEntry{&LinkStatePacket{Packet{1, 0}, "n3", map[string]int{"n2": 1, "n4": 1}, nil, nil}, old})
+	a.routes.UpdateLinkState(&LinkStatePacket{Packet{1, 0}, "n2", map[string]int{"n1": 1, "n3": 1}, nil, nil})
+	a.routes.UpdateLinkState(&LinkStatePacket{Packet{1, 0}, "n3", map[string]int{"n2": 1, "n4": 1}, nil, nil})
+	_, ok := a.routes.NextHop("n4")
+	require.True(t, ok)
+
+	// our own advertisement is never expired, however old
+	e, _ := a.lsdb.Get("n1")
+	e.(*linkStateEntry).received = old
+
+	a.expireLinkState()
+
+	assert.ElementsMatch(t, []string{"n1", "n2"}, a.lsdb.Keys())
+	_, ok = a.routes.NextHop("n4")
+	assert.False(t, ok)
+	// n2 still advertises its link to n3
+	hop, ok := a.routes.NextHop("n3")
+	assert.True(t, ok)
+	assert.Equal(t, "n2", hop)
+}
+
+func Test_FloodDatabase(t *testing.T) {
+	a, w := newLinkStateDaemon("n1")
+	a.lsdb.Set("n2", &linkStateEntry{&LinkStatePacket{Packet{1, 0}, "n2", map[string]int{}, nil, nil}, time.Now()})
+	a.lsdb.Set("n3", &linkStateEntry{&LinkStatePacket{Packet{1, 0}, "n3", map[string]int{}, nil, nil}, time.Now()})
+
+	a.floodDatabase()
+
+	assert.Len(t, w.linkStates(), 2)
+}
@@ -0,0 +1,81 @@
+package net
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// eventBufferSize is the number of node events buffered before they are dropped
+const eventBufferSize = 64
+
+// NodeEventKind describes how a node's reachability changed
+type NodeEventKind int
+
+const (
+	// NodeUp is sent when a node is first heard from
+	NodeUp NodeEventKind = iota
+	// NodeDown is sent when a node stops announcing and is expired
+	NodeDown
+)
+
+func (k NodeEventKind) String() string {
+	switch k {
+	case NodeUp:
+		return "up"
+	case NodeDown:
+		return "down"
+	default:
+		return "unknown"
+	}
+}
+
+// NodeEvent is a change in reachability of a connected node
+type NodeEvent struct {
+	NodeName string
+	Kind     NodeEventKind
+	Time     time.Time
+}
+
+// expireNodes removes connected nodes which haven't announced within the hold time,
+// along with everything we learned about them from the network.
+func (a *announceDaemon) expireNodes() {
+	if a.holdTime == 0 {
+		return
+	}
+
+	now := a.clock.Now()
+	for nodeName, e := range a.connectedNodes.Items() {
+		lastSeen := e.(*NodeInfo).LastSeen
+		if now.Sub(lastSeen) <= a.holdTime {
+			continue
+		}
+
+		// the node may have announced since we took the snapshot
+		a.nodesMu.Lock()
+		expired := a.connectedNodes.RemoveCb(nodeName, func(_ string, v interface{}, exists bool) bool {
+			return exists && now.Sub(v.(*NodeInfo).LastSeen) > a.holdTime
+		})
+		a.nodesMu.Unlock()
+		if !expired {
+			continue
+		}
+
+		log.Info().Str("nodeName", nodeName).Time("lastSeen", lastSeen).Msg("Connected node expired")
+		a.lsdb.Remove(nodeName)
+		a.routes.RemoveNode(nodeName)
+		a.sendEvent(nodeName, NodeDown, now)
+	}
+}
+
+func (a *announceDaemon) sendEvent(nodeName string, kind NodeEventKind, t time.Time) {
+	if a.events == nil {
+		return
+	}
+
+	select {
+	case a.events <- NodeEvent{nodeName, kind, t}:
+	default:
+		log.Debug().Str("nodeName", nodeName).Str("kind", kind.String()).Msg("Event buffer full, dropping node event")
+	}
+}
@@ -0,0 +1,69 @@
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ExpireNodes(t *testing.T) {
+	a, _ := newLinkStateDaemon("n1")
+	a.holdTime = time.Second * 3
+	a.events = make(chan NodeEvent, eventBufferSize)
+
+	a.connectedNodes.Set("alive", &NodeInfo{NodeName: "alive", LastSeen: time.Now()})
+	a.connectedNodes.Set("dead", &NodeInfo{NodeName: "dead", LastSeen: time.Now().Add(-time.Second * 4)})
+	a.routes.SetNeighbors(a.adjacencies())
+	a.lsdb.Set("dead", &linkStateEntry{&LinkStatePacket{Packet{1, 0}, "dead", map[string]int{"n1": 1}, nil, nil}, time.Now()})
+
+	a.expireNodes()
+
+	assert.Equal(t, []string{"alive"}, a.connectedNodes.Keys())
+	assert.False(t, a.lsdb.Has("dead"))
+	_, ok := a.routes.NextHop("dead")
+	assert.False(t, ok)
+
+	require.Len(t, a.events, 1)
+	e := <-a.events
+	assert.Equal(t, "dead", e.NodeName)
+	assert.Equal(t, NodeDown, e.Kind)
+}
+
+func Test_HandleAnnounceResponse_UpdatesLastSeen(t *testing.T) {
+	a, _ := newLinkStateDaemon("n1")
+	a.events = make(chan NodeEvent, eventBufferSize)
+	a.announceUpdateChan = make(chan bool, 2)
+
+	a.handleAnnounceResponse(&AnnouncePacket{Packet: Packet{1, 0}, Identity: Identity{"n2", "localhost:1", nil}})
+	e, _ := a.connectedNodes.Get("n2")
+	first := e.(*NodeInfo).LastSeen
+
+	time.Sleep(time.Millisecond * 10)
+	a.handleAnnounceResponse(&AnnouncePacket{Packet: Packet{1, 0}, Identity: Identity{"n2", "localhost:1", nil}})
+	e, _ = a.connectedNodes.Get("n2")
+	assert.True(t, e.(*NodeInfo).LastSeen.After(first))
+
+	require.Len(t, a.events, 1)
+	assert.Equal(t, NodeUp, (<-a.events).Kind)
+	// only the new node triggers another announce, since the sequence number didn't change
+	assert.Len(t, a.announceUpdateChan, 1)
+}
+
+func Test_HandleAnnounceResponse_AcceptsRestartedNode(t *testing.T) {
+	a, _ := newLinkStateDaemon("n1")
+	a.announceUpdateChan = make(chan bool, 4)
+
+	a.handleAnnounceResponse(&AnnouncePacket{Packet: Packet{300, 10}, Identity: Identity{"n2", "old:1", nil}})
+
+	// restarted with a new address, and counting from the beginning again
+	a.handleAnnounceResponse(&AnnouncePacket{Packet: Packet{1, 20}, Identity: Identity{"n2", "new:1", nil}})
+	e, _ := a.connectedNodes.Get("n2")
+	assert.Equal(t, "new:1", e.(*NodeInfo).Addr)
+
+	// delayed announcement from before the restart
+	a.handleAnnounceResponse(&AnnouncePacket{Packet: Packet{301, 10}, Identity: Identity{"n2", "old:1", nil}})
+	e, _ = a.connectedNodes.Get("n2")
+	assert.Equal(t, "new:1", e.(*NodeInfo).Addr)
+}
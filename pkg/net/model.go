@@ -1,32 +1,104 @@
 package net
 
 import (
-	"encoding/gob"
+	"crypto/ed25519"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
 )
 
 const (
 	packetAnnounce = iota
+	packetLinkState
+	packetData
+	packetProbe
+	packetSealed
 )
 
 // Packet is the basic packet struct
 type Packet struct {
 	SequenceNum uint16
+	// Epoch is when the sending node started, in unix nanoseconds. See serial.go.
+	Epoch uint64
 }
 
 // Identity contains information to identify a struct
 type Identity struct {
 	NodeName string
 	Addr     string
+	// PublicKey is the node's identity key, set by nodes which authenticate their traffic
+	PublicKey ed25519.PublicKey
 }
 
 // AnnouncePacket contains information about the current node to send to other nodes
 type AnnouncePacket struct {
 	Packet
 	Identity
-	ConnectedNodes map[string]interface{}
+	ConnectedNodes []string
+	// EphemeralKey is the X25519 key used to derive sessions with the node
+	EphemeralKey []byte
+	// Counter increases with every announcement sent by a node which authenticates its traffic
+	Counter uint64
+	// Signature is made with the identity key over everything else in the packet
+	Signature []byte
+}
+
+// LinkStatePacket is a link state advertisement (LSA). It is originated by a node to describe
+// its adjacencies and their costs, and is flooded through the network so every node learns the full topology.
+type LinkStatePacket struct {
+	Packet
+	Origin      string
+	Adjacencies map[string]int
+	// PublicKey is the identity key of the origin, which the advertisement is signed with
+	PublicKey ed25519.PublicKey
+	Signature []byte
+}
+
+// kinds of data packet, which determine how the payload is handled by the destination
+const (
+	dataUser = iota
+	dataReliable
+	dataAck
+	dataStream
+	dataEcho
+	dataEchoReply
+	dataTimeExceeded
+)
+
+// DataPacket carries a payload from its source node to its destination node,
+// and is forwarded hop by hop along the routing table of each node on the way.
+type DataPacket struct {
+	Source  string
+	Dest    string
+	TTL     uint8
+	Hops    uint8
+	Kind    uint8
+	Payload []byte
+}
+
+// ProbePacket is a timestamped echo request sent directly to a connected node to measure latency.
+// The target echoes it back to the source with Reply set.
+type ProbePacket struct {
+	Source string
+	Target string
+	SeqNo  uint32
+	// SentAt is the unix time in nanoseconds, by the source's clock
+	SentAt int64
+	Reply  bool
+}
+
+// SealedPacket is a packet encrypted for a connected node, which is the only kind of packet
+// accepted on the data port by nodes which encrypt their traffic.
+type SealedPacket struct {
+	Source string
+	// Counter is the nonce the packet was sealed with, unique for each packet sent in a session
+	Counter    uint64
+	Ciphertext []byte
 }
 
 func init() {
-	gob.Register(Packet{})
-	gob.Register(AnnouncePacket{})
+	udp.Register(packetAnnounce, decodeAnnouncePacket)
+	udp.Register(packetLinkState, decodeLinkStatePacket)
+	udp.Register(packetData, decodeDataPacket)
+	udp.Register(packetProbe, decodeProbePacket)
+	udp.Register(packetSealed, decodeSealedPacket)
 }
@@ -1,11 +1,14 @@
 package net
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/Heanthor/rsec-net/internal/maputils"
-
 	"github.com/Heanthor/rsec-net/internal/udp"
 	cmap "github.com/orcaman/concurrent-map"
 
@@ -15,86 +18,137 @@ import (
 type announceDaemon struct {
 	w                udp.NetWriter
 	announceInterval time.Duration
-	errChan          chan error
+	clock            Clock
+	// errs receives errors encountered while running, which are dropped if it is full
+	errs             chan error
 	msgChan          <-chan interface{}
-	stopChan         chan bool
-	doneStoppingChan chan bool
 	identity         Identity
 	acceptOwnPackets bool
 
+	// connectedNodes holds a *NodeInfo for each node we have heard announce.
+	// Node infos are replaced rather than modified, since they are read from other goroutines.
 	connectedNodes cmap.ConcurrentMap
+	// nodesMu serializes changes to connectedNodes which depend on the existing node info
+	nodesMu sync.Mutex
+	// holdTime is how long a connected node may go without announcing before it is expired.
+	// Zero disables expiry.
+	holdTime time.Duration
+	events   chan NodeEvent
+
+	// epoch is when the daemon was created, in unix nanoseconds, distinguishing our sequence numbers
+	// from those we sent before restarting
+	epoch uint64
 
 	// announce fields
-	seqNo         uint16
-	connNodesHash [16]byte
+	seqNo              uint16
+	lastConnectedNodes []string
 	// if we update the list of connected nodes, immediately send out another broadcast
 	announceUpdateChan chan bool
+	// started is set once the daemon is started, before which received packets are ignored. Accessed atomically.
+	started int32
+
+	// link state fields
+	// lsdb holds the most recent link state advertisement seen from each origin, including our own
+	lsdb            cmap.ConcurrentMap
+	lsaSeqNo        uint16
+	lastAdjacencies map[string]int
+	routes          *RoutingTable
+	// lsaRefreshed is when lsaSeqNo was last incremented
+	lsaRefreshed time.Time
+
+	// link cost fields
+	costFunc        CostFunc
+	costHysteresis  float64
+	linkCosts       map[string]int
+	advertisedCosts map[string]int
+
+	// security is set if traffic is authenticated, in which case packets without a valid signature are ignored
+	security *security
+	counters *counters
 }
 
-// StartAnnounceDaemon creates the announce daemon and starts its operation.
+// StartAnnounceDaemon starts the operation of the announce daemon, in goroutines owned by the group.
 // The announce daemon does two things: periodically announces on the network, and listens for
 // other announcements, updating the map of known nodes when found.
-func (a *announceDaemon) StartAnnounceDaemon() {
+func (a *announceDaemon) StartAnnounceDaemon(group *supervisor) {
 	log.Info().Str("writeAddr", a.w.WriteAddr()).Str("nodeName", a.identity.NodeName).Msg("Starting announce daemon...")
-	a.announceUpdateChan = make(chan bool)
-
-	a.startSending()
 
-	a.startReceiving()
+	atomic.StoreInt32(&a.started, 1)
+	// the ticker is started now rather than in the goroutine, so a virtual clock knows about it straight away
+	announceTicker := a.clock.NewTicker(a.announceInterval)
+	group.Go(func(ctx context.Context) error {
+		return a.send(ctx, announceTicker)
+	})
 
 	log.Info().Msg("Announce daemon started")
 }
 
-func (a *announceDaemon) startSending() {
-	announceTicker := time.NewTicker(a.announceInterval)
-
-	go func() {
-		for {
-			select {
-			case <-a.stopChan:
-				// don't care about waiting for this goroutine before doing other cleanup
-				return
-			case <-announceTicker.C:
-				a.doAnnounce()
-			case <-a.announceUpdateChan:
-				log.Debug().Msg("announcing new connected nodes")
-				a.doAnnounce()
-			}
+// send announces on every tick, and as soon as our connected nodes change, until ctx is done
+func (a *announceDaemon) send(ctx context.Context, announceTicker Ticker) error {
+	defer announceTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debug().Msg("Announce daemon stopped")
+			return nil
+		case <-announceTicker.C():
+			a.expireNodes()
+			a.expireLinkState()
+			a.doAnnounce()
+		case <-a.announceUpdateChan:
+			log.Debug().Msg("announcing new connected nodes")
+			a.doAnnounce()
 		}
-	}()
+	}
 }
 
-func (a *announceDaemon) startReceiving() {
-	go func() {
-		for {
-			select {
-			case <-a.stopChan:
-				log.Debug().Msg("in stop chan")
-				a.doneStoppingChan <- true
-
-				return
-			case msgIn := <-a.msgChan:
-				log.Debug().Interface("in", msgIn).Msg("got in announce daemon")
-				if m, ok := msgIn.(AnnouncePacket); ok {
-					if a.acceptOwnPackets || m.Identity.NodeName != a.identity.NodeName {
-						a.handleAnnounceResponse(&m)
-					}
-				} else {
-					log.Error().Interface("msgIn", msgIn).Msg("announce daemon got non-announce packet message")
-					a.errChan <- fmt.Errorf("announce daemon got non-announce packet message")
-				}
+// receive handles packets from the announce reader until it is stopped, ignoring them until the daemon is started.
+// Returns ErrReaderStopped if it stopped before ctx was done.
+func (a *announceDaemon) receive(ctx context.Context) error {
+	for msgIn := range a.msgChan {
+		if ctx.Err() != nil || atomic.LoadInt32(&a.started) == 0 {
+			// drain the reader until it is stopped
+			continue
+		}
+
+		log.Debug().Interface("in", msgIn).Msg("got in announce daemon")
+		switch m := msgIn.(type) {
+		case AnnouncePacket:
+			if a.acceptOwnPackets || m.Identity.NodeName != a.identity.NodeName {
+				a.counters.addAnnouncementReceived()
+				a.handleAnnounceResponse(&m)
 			}
+		case LinkStatePacket:
+			a.handleLinkState(&m)
+		default:
+			a.counters.addDropped(DropUnknown)
+			log.Error().Interface("msgIn", msgIn).Msg("announce daemon got non-announce packet message")
+			a.reportErr(fmt.Errorf("announce daemon got non-announce packet message"))
 		}
-	}()
+	}
+
+	if ctx.Err() == nil {
+		return ErrReaderStopped
+	}
+
+	return nil
+}
+
+// reportErr passes an error on to be read from Interface.Errors, unless too many are waiting to be read
+func (a *announceDaemon) reportErr(err error) {
+	select {
+	case a.errs <- err:
+	default:
+	}
 }
 
-func (a *announceDaemon) StopAnnounceDaemon() {
-	// two goroutines listen on this channel
-	a.stopChan <- true
-	a.stopChan <- true
-	// wait to make sure receiving is done
-	<-a.doneStoppingChan
-	log.Debug().Msg("Announce daemon stopped")
+// requestAnnounce makes the daemon announce as soon as it can. Requests made before it does are merged.
+func (a *announceDaemon) requestAnnounce() {
+	select {
+	case a.announceUpdateChan <- true:
+	default:
+	}
 }
 
 func (a *announceDaemon) doAnnounce() {
@@ -105,33 +159,104 @@ func (a *announceDaemon) doAnnounce() {
 	// we only update the sequence number if the message being sent is different
 	// from the last sent message. we still send the message regardless
 	// in case a new node has joined the network
-	hash, items := maputils.ComputeHash(a.connectedNodes)
-	if hash != a.connNodesHash {
+	connected := a.connectedNodes.Keys()
+	sort.Strings(connected)
+	if !reflect.DeepEqual(connected, a.lastConnectedNodes) {
 		a.seqNo++
-		a.connNodesHash = hash
+		a.lastConnectedNodes = connected
 	}
 
-	log.Debug().Uint16("seqNo", a.seqNo).Msg("Announce daemon doing announce")
-	if err := a.w.Write(AnnouncePacket{
-		Packet:         Packet{a.seqNo},
+	p := AnnouncePacket{
+		Packet:         Packet{a.seqNo, a.epoch},
 		Identity:       a.identity,
-		ConnectedNodes: items,
-	}); err != nil {
-		a.errChan <- err
+		ConnectedNodes: connected,
+	}
+	if a.security != nil {
+		a.security.signAnnounce(&p)
 	}
+
+	log.Debug().Uint16("seqNo", a.seqNo).Msg("Announce daemon doing announce")
+	if err := a.w.Write(p); err != nil {
+		a.reportErr(err)
+	} else {
+		a.counters.addAnnouncementSent()
+	}
+
+	a.originateLinkState()
 }
 
 func (a *announceDaemon) handleAnnounceResponse(ap *AnnouncePacket) {
-	if didUpdate := a.connectedNodes.SetIfAbsent(ap.NodeName, ap); didUpdate {
-		log.Info().Interface("connectedNodes", a.connectedNodes).Msg("New connected nodes")
-		a.announceUpdateChan <- true
-	} else {
-		e, _ := a.connectedNodes.Get(ap.NodeName)
-		existing := e.(*AnnouncePacket)
+	var sess *session
+	if a.security != nil {
+		if err := a.security.verifyAnnounce(ap); err != nil {
+			if err == ErrReplay {
+				a.counters.addDropped(DropReplay)
+			} else {
+				a.counters.addDropped(DropBadSignature)
+			}
+			log.Debug().Err(err).Str("nodeName", ap.NodeName).Msg("Ignoring unauthenticated announcement")
+			return
+		}
 
-		if ap.SequenceNum > existing.SequenceNum {
-			a.connectedNodes.Set(ap.NodeName, ap)
-			a.announceUpdateChan <- true
+		var err error
+		if sess, err = a.updateSession(ap); err != nil {
+			log.Debug().Err(err).Str("nodeName", ap.NodeName).Msg("Unable to start session")
+			return
 		}
 	}
+
+	now := a.clock.Now()
+	isNew, isUpdated := false, false
+
+	found := a.updateNode(ap.NodeName, func(info *NodeInfo) {
+		info.LastSeen = now
+		if ap.newerThan(info.lastPacket) {
+			info.Addr = ap.Addr
+			info.lastPacket = ap.Packet
+			isUpdated = true
+		}
+		if sess != nil {
+			log.Info().Str("nodeName", ap.NodeName).Msg("Node restarted, starting new session")
+			info.session = sess
+		}
+	})
+	if !found {
+		a.nodesMu.Lock()
+		isNew = a.connectedNodes.SetIfAbsent(ap.NodeName, &NodeInfo{
+			NodeName:   ap.NodeName,
+			Addr:       ap.Addr,
+			LastSeen:   now,
+			lastPacket: ap.Packet,
+			session:    sess,
+		})
+		a.nodesMu.Unlock()
+	}
+
+	if isNew {
+		log.Info().Strs("connectedNodes", a.connectedNodes.Keys()).Msg("New connected nodes")
+		a.sendEvent(ap.NodeName, NodeUp, now)
+		// the new neighbor has likely missed advertisements flooded before it joined
+		a.floodDatabase()
+		a.requestAnnounce()
+	} else if isUpdated {
+		a.requestAnnounce()
+	}
+}
+
+// updateNode replaces the info of a connected node with a copy modified by update.
+// Returns false if the node is not connected.
+func (a *announceDaemon) updateNode(nodeName string, update func(info *NodeInfo)) bool {
+	a.nodesMu.Lock()
+	defer a.nodesMu.Unlock()
+
+	e, ok := a.connectedNodes.Get(nodeName)
+	if !ok {
+		return false
+	}
+
+	info := *e.(*NodeInfo)
+	update(&info)
+	a.connectedNodes.Set(nodeName, &info)
+
+	return true
 }
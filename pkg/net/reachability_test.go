@@ -1,6 +1,7 @@
 package net
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
@@ -31,15 +32,16 @@ func (suite *AnnounceDaemonSuite) TearDownSuite() {
 func (suite *AnnounceDaemonSuite) Test_DaemonAnnounce() {
 	writeDaemon := initWriteOnlyNewAnnounceDaemon("writeDaemon", suite.addr, time.Second*1)
 	fakeConnNodes := cmap.New()
-	fakeConnNodes.Set("unknownNode", AnnouncePacket{
-		Packet:   Packet{0},
-		Identity: Identity{"unknownNode", ":2222"},
+	fakeConnNodes.Set("unknownNode", &NodeInfo{
+		NodeName: "unknownNode",
+		Addr:     ":2222",
 	})
 	writeDaemon.connectedNodes = fakeConnNodes
 
 	// receiving end
 	testDaemon := initNewAnnounceDaemon("testDaemon", suite.addr, time.Second*1)
-	testDaemon.startReceiving()
+	testDaemon.started = 1
+	go testDaemon.receive(context.Background())
 
 	time.Sleep(time.Second * 1)
 
@@ -56,8 +58,6 @@ func TestAnnounceDaemonSuite(t *testing.T) {
 }
 
 func initNewAnnounceDaemon(nodeName, addr string, announceInterval time.Duration) *announceDaemon {
-	errChan := make(chan error)
-
 	// even though reachability is through multicast, test with unicast
 	w, err := udp.NewUDPWriter(addr)
 	if err != nil {
@@ -68,7 +68,7 @@ func initNewAnnounceDaemon(nodeName, addr string, announceInterval time.Duration
 	if err != nil {
 		panic(err)
 	}
-	mRecvChan, err := rd.StartReceiving()
+	mRecvChan, err := rd.StartReceiving("test")
 	if err != nil {
 		panic(err)
 	}
@@ -76,21 +76,21 @@ func initNewAnnounceDaemon(nodeName, addr string, announceInterval time.Duration
 	m := cmap.New()
 
 	return &announceDaemon{
-		identity:         Identity{nodeName, addr},
+		identity:         Identity{nodeName, addr, nil},
+		clock:            SystemClock{},
 		w:                w,
-		errChan:          errChan,
+		errs:             make(chan error, errBufferSize),
 		announceInterval: announceInterval,
 		msgChan:          mRecvChan,
-		stopChan:         make(chan bool),
-		doneStoppingChan: make(chan bool),
 		connectedNodes:   m,
+		lsdb:             cmap.New(),
+		routes:           NewRoutingTable(nodeName),
+		counters:         &counters{},
 		acceptOwnPackets: true,
 	}
 }
 
 func initWriteOnlyNewAnnounceDaemon(nodeName, addr string, announceInterval time.Duration) *announceDaemon {
-	errChan := make(chan error)
-
 	w, err := udp.NewUDPWriter(addr)
 	if err != nil {
 		panic(err)
@@ -101,13 +101,15 @@ func initWriteOnlyNewAnnounceDaemon(nodeName, addr string, announceInterval time
 	m := cmap.New()
 
 	return &announceDaemon{
-		identity:         Identity{nodeName, addr},
+		identity:         Identity{nodeName, addr, nil},
+		clock:            SystemClock{},
 		w:                w,
-		errChan:          errChan,
+		errs:             make(chan error, errBufferSize),
 		announceInterval: announceInterval,
 		msgChan:          fakeRecvChan,
-		stopChan:         make(chan bool),
-		doneStoppingChan: make(chan bool),
 		connectedNodes:   m,
+		lsdb:             cmap.New(),
+		routes:           NewRoutingTable(nodeName),
+		counters:         &counters{},
 	}
 }
@@ -0,0 +1,188 @@
+package net
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultRetransmitTimeout = time.Millisecond * 250
+	defaultMaxRetransmits    = 5
+	// seenWindowSize is how many recent sequence numbers are remembered per source to drop duplicates
+	seenWindowSize = 1024
+)
+
+// ErrDeliveryFailed is returned by SendReliable when no acknowledgement arrives after all retransmissions
+var ErrDeliveryFailed = errors.New("delivery failed, no acknowledgement received")
+
+type pendingKey struct {
+	dest  string
+	seqNo uint32
+}
+
+// seenWindow remembers the most recent sequence numbers received from a source
+type seenWindow struct {
+	seen  map[uint32]struct{}
+	order []uint32
+}
+
+// reliableState holds sequence numbers and unacknowledged messages for reliable delivery
+type reliableState struct {
+	mu sync.Mutex
+	// nextSeqNo is the next sequence number to send to each destination.
+	// They start at random, so a restarted node's messages aren't mistaken for duplicates.
+	nextSeqNo map[string]uint32
+	pending   map[pendingKey]chan struct{}
+	received  map[string]*seenWindow
+	rand      *rand.Rand
+}
+
+func newReliableState() *reliableState {
+	return &reliableState{
+		nextSeqNo: make(map[string]uint32),
+		pending:   make(map[pendingKey]chan struct{}),
+		received:  make(map[string]*seenWindow),
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// SendReliable sends the payload to the named node, and blocks until the node acknowledges it.
+// Unacknowledged messages are retransmitted with exponential backoff, and ErrDeliveryFailed is
+// returned once retransmissions are exhausted. ErrClosed is returned if the interface is closed first.
+func (n *Interface) SendReliable(nodeName string, payload []byte) error {
+	if nodeName == n.ad.identity.NodeName {
+		return n.SendTo(nodeName, payload)
+	}
+
+	r := n.reliable
+	r.mu.Lock()
+	seqNo, ok := r.nextSeqNo[nodeName]
+	if !ok {
+		seqNo = r.rand.Uint32()
+	}
+	r.nextSeqNo[nodeName] = seqNo + 1
+
+	key := pendingKey{nodeName, seqNo}
+	acked := make(chan struct{})
+	r.pending[key] = acked
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, key)
+		r.mu.Unlock()
+	}()
+
+	var e udp.Encoder
+	e.PutUint32(seqNo)
+	p := DataPacket{
+		Source:  n.ad.identity.NodeName,
+		Dest:    nodeName,
+		TTL:     defaultTTL,
+		Kind:    dataReliable,
+		Payload: append(e.Encoded(), payload...),
+	}
+
+	retransmits := n.settings.MaxRetransmits
+	if retransmits < 0 {
+		retransmits = 0
+	}
+
+	timeout := n.settings.RetransmitTimeout
+	for attempt := 0; attempt <= retransmits; attempt++ {
+		if err := n.forward(p); err != nil {
+			// the route may come back before we run out of retransmissions
+			log.Debug().Err(err).Str("dest", nodeName).Uint32("seqNo", seqNo).Msg("Unable to send reliable message")
+		}
+
+		select {
+		case <-acked:
+			return nil
+		case <-n.group.ctx.Done():
+			return ErrClosed
+		case <-n.settings.Clock.After(timeout):
+			timeout *= 2
+		}
+	}
+
+	return ErrDeliveryFailed
+}
+
+// handleReliable acknowledges a reliable message, and delivers it unless it's a duplicate
+func (n *Interface) handleReliable(p DataPacket) {
+	d := udp.NewDecoder(p.Payload)
+	seqNo := d.GetUint32()
+	if d.Err() != nil {
+		log.Debug().Str("source", p.Source).Msg("Dropping truncated reliable message")
+		return
+	}
+
+	// always acknowledge, since the retransmission may be due to a lost acknowledgement
+	var e udp.Encoder
+	e.PutUint32(seqNo)
+	ack := DataPacket{
+		Source:  n.ad.identity.NodeName,
+		Dest:    p.Source,
+		TTL:     defaultTTL,
+		Kind:    dataAck,
+		Payload: e.Encoded(),
+	}
+	if err := n.forward(ack); err != nil {
+		log.Debug().Err(err).Str("dest", p.Source).Uint32("seqNo", seqNo).Msg("Unable to send acknowledgement")
+	}
+
+	if n.reliable.markReceived(p.Source, seqNo) {
+		p.Payload = p.Payload[4:]
+		n.deliver(p, int(p.Hops)+1)
+	}
+}
+
+// handleAck wakes the sender waiting on the acknowledged message
+func (n *Interface) handleAck(p DataPacket) {
+	d := udp.NewDecoder(p.Payload)
+	seqNo := d.GetUint32()
+	if d.Err() != nil {
+		return
+	}
+
+	r := n.reliable
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := pendingKey{p.Source, seqNo}
+	if acked, ok := r.pending[key]; ok {
+		close(acked)
+		delete(r.pending, key)
+	}
+}
+
+// markReceived records the sequence number as received from the source.
+// Returns false if it was already received.
+func (r *reliableState) markReceived(source string, seqNo uint32) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, ok := r.received[source]
+	if !ok {
+		w = &seenWindow{seen: make(map[uint32]struct{})}
+		r.received[source] = w
+	}
+
+	if _, ok := w.seen[seqNo]; ok {
+		return false
+	}
+
+	w.seen[seqNo] = struct{}{}
+	w.order = append(w.order, seqNo)
+	if len(w.order) > seenWindowSize {
+		delete(w.seen, w.order[0])
+		w.order = w.order[1:]
+	}
+
+	return true
+}
@@ -0,0 +1,167 @@
+package net
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeWriter hands written messages straight to another interface, unless drop returns true
+type pipeWriter struct {
+	mu   sync.Mutex
+	to   *Interface
+	drop func(interface{}) bool
+}
+
+func (p *pipeWriter) Write(data interface{}) error {
+	p.mu.Lock()
+	drop := p.drop != nil && p.drop(data)
+	p.mu.Unlock()
+
+	if !drop {
+		go p.to.handleDataMessage(data)
+	}
+
+	return nil
+}
+
+func (p *pipeWriter) WriteAddr() string {
+	return p.to.ad.identity.Addr
+}
+
+// newPipedInterfaces creates two connected interfaces, which write to each other through the returned pipes
+func newPipedInterfaces(t *testing.T) (*Interface, *Interface, *pipeWriter, *pipeWriter) {
+	n1, n2, w1, w2 := pipedInterfaces(t, func(*InterfaceSettings) {})
+	connect(n1, n2)
+	connect(n2, n1)
+
+	return n1, n2, w1, w2
+}
+
+// pipedInterfaces creates two interfaces which write to each other through the returned pipes,
+// but aren't connected yet. configure is applied to the settings of each.
+func pipedInterfaces(t *testing.T, configure func(*InterfaceSettings)) (*Interface, *Interface, *pipeWriter, *pipeWriter) {
+	w1, w2 := &pipeWriter{}, &pipeWriter{}
+	settings := func(w *pipeWriter) InterfaceSettings {
+		s := InterfaceSettings{
+			AnnounceInterval:  time.Second,
+			RetransmitTimeout: time.Millisecond * 10,
+			NewWriter: func(string) (udp.NetWriter, error) {
+				return w, nil
+			},
+		}
+		configure(&s)
+
+		return s
+	}
+
+	n1, err := NewInterface(context.Background(), "n1", &chanReader{}, &recordingWriter{}, &chanReader{}, settings(w1))
+	require.NoError(t, err)
+	n2, err := NewInterface(context.Background(), "n2", &chanReader{}, &recordingWriter{}, &chanReader{}, settings(w2))
+	require.NoError(t, err)
+	w1.to, w2.to = n2, n1
+
+	return n1, n2, w1, w2
+}
+
+func receiveOne(t *testing.T, n *Interface) Envelope {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	e, err := n.Receive(ctx)
+	require.NoError(t, err)
+
+	return e
+}
+
+func TestInterface_SendReliable(t *testing.T) {
+	n1, n2, _, _ := newPipedInterfaces(t)
+
+	err := n1.SendReliable("n2", []byte("hello"))
+	require.NoError(t, err)
+
+	e := receiveOne(t, n2)
+	assert.Equal(t, "n1", e.Source)
+	assert.Equal(t, []byte("hello"), e.Payload)
+	assert.Empty(t, n1.reliable.pending)
+}
+
+func TestInterface_SendReliable_Retransmit(t *testing.T) {
+	n1, n2, w1, w2 := newPipedInterfaces(t)
+
+	// drop the first message, and the first acknowledgement
+	droppedMessage, droppedAck := false, false
+	w1.drop = func(data interface{}) bool {
+		if !droppedMessage {
+			droppedMessage = true
+			return true
+		}
+		return false
+	}
+	w2.drop = func(data interface{}) bool {
+		if p, ok := data.(DataPacket); ok && p.Kind == dataAck && !droppedAck {
+			droppedAck = true
+			return true
+		}
+		return false
+	}
+
+	err := n1.SendReliable("n2", []byte("hello"))
+	require.NoError(t, err)
+
+	// delivered exactly once despite being received twice
+	e := receiveOne(t, n2)
+	assert.Equal(t, []byte("hello"), e.Payload)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err = n2.Receive(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestInterface_SendReliable_Failed(t *testing.T) {
+	n1, _, w1, _ := newPipedInterfaces(t)
+	w1.drop = func(interface{}) bool {
+		return true
+	}
+
+	err := n1.SendReliable("n2", []byte("hello"))
+	assert.Equal(t, ErrDeliveryFailed, err)
+}
+
+func TestInterface_SendReliable_NoRetransmits(t *testing.T) {
+	n1, n2, w1, _ := pipedInterfaces(t, func(s *InterfaceSettings) {
+		s.MaxRetransmits = -1
+	})
+	connect(n1, n2)
+	connect(n2, n1)
+
+	sent := 0
+	w1.drop = func(interface{}) bool {
+		sent++
+		return true
+	}
+
+	err := n1.SendReliable("n2", []byte("hello"))
+	assert.Equal(t, ErrDeliveryFailed, err)
+	assert.Equal(t, 1, sent)
+}
+
+func TestReliableState_MarkReceived(t *testing.T) {
+	r := newReliableState()
+
+	assert.True(t, r.markReceived("n1", 1))
+	assert.False(t, r.markReceived("n1", 1))
+	assert.True(t, r.markReceived("n2", 1))
+
+	for i := uint32(2); i < seenWindowSize+2; i++ {
+		r.markReceived("n1", i)
+	}
+	// old enough to have been forgotten
+	assert.True(t, r.markReceived("n1", 1))
+}
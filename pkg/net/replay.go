@@ -0,0 +1,53 @@
+package net
+
+import (
+	"errors"
+	"sync"
+)
+
+// replayWindowSize is the number of counters tracked behind the highest accepted counter.
+// It is a multiple of 64, and one word of the bitmap is always being cleared for reuse, as in WireGuard.
+const replayWindowSize = 2048
+
+// ErrReplay is returned when an authenticated packet has been received before, or is too old to tell
+var ErrReplay = errors.New("replayed packet")
+
+// replayWindow is a sliding window anti-replay filter over packet counters (RFC 6479).
+// It accepts each counter once, in any order, as long as it isn't too far behind the highest counter accepted.
+type replayWindow struct {
+	mu      sync.Mutex
+	highest uint64
+	bitmap  [replayWindowSize / 64]uint64
+}
+
+// accept records the counter, returning false if it was already accepted or has fallen out of the window.
+// Counters must only be accepted after the packet is authenticated.
+func (w *replayWindow) accept(counter uint64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	const words = uint64(len(w.bitmap))
+
+	if counter > w.highest {
+		// clear the words between the old highest counter and the new one
+		current, target := w.highest/64, counter/64
+		diff := target - current
+		if diff > words {
+			diff = words
+		}
+		for i := uint64(1); i <= diff; i++ {
+			w.bitmap[(current+i)%words] = 0
+		}
+		w.highest = counter
+	} else if w.highest-counter >= replayWindowSize-64 {
+		return false
+	}
+
+	word, bit := &w.bitmap[(counter/64)%words], uint64(1)<<(counter%64)
+	if *word&bit != 0 {
+		return false
+	}
+	*word |= bit
+
+	return true
+}
@@ -0,0 +1,42 @@
+package net
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayWindow_Accept(t *testing.T) {
+	w := &replayWindow{}
+
+	assert.True(t, w.accept(0))
+	assert.False(t, w.accept(0))
+	assert.True(t, w.accept(2))
+	// out of order, but within the window
+	assert.True(t, w.accept(1))
+	assert.False(t, w.accept(1))
+
+	assert.True(t, w.accept(1000))
+	assert.True(t, w.accept(3))
+	assert.False(t, w.accept(3))
+
+	// far enough ahead that everything before falls out of the window
+	assert.True(t, w.accept(1000+replayWindowSize))
+	assert.False(t, w.accept(1000))
+	assert.True(t, w.accept(1000+replayWindowSize-1))
+	assert.True(t, w.accept(1000+64+1), "counter inside the window rejected")
+	assert.False(t, w.accept(1000+64+1))
+}
+
+func TestReplayWindow_LargeJump(t *testing.T) {
+	w := &replayWindow{}
+
+	// reused bitmap words are cleared, so counters in them aren't mistaken for duplicates
+	for i := uint64(0); i < 64; i++ {
+		assert.True(t, w.accept(i))
+	}
+	assert.True(t, w.accept(replayWindowSize*5))
+	for i := uint64(1); i < 64; i++ {
+		assert.True(t, w.accept(replayWindowSize*5-i))
+	}
+}
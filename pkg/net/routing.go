@@ -1,50 +1,135 @@
 package net
 
 import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/Heanthor/rsec-net/internal/udp"
 	cmap "github.com/orcaman/concurrent-map"
+	"github.com/rs/zerolog/log"
 )
 
+// errBufferSize is the number of errors buffered before they are dropped
+const errBufferSize = 16
+
+// ErrReaderStopped is returned by Close if a reader stopped before the interface was closed
+var ErrReaderStopped = errors.New("reader stopped")
+
 // NodeInfo contains information about a discovered network node
 type NodeInfo struct {
-	NodeName  string
-	Addr      string
-	Latency   latency
-	lastSeqNo uint16
+	NodeName   string
+	Addr       string
+	Latency    latency
+	LastSeen   time.Time
+	lastPacket Packet
+	session    *session
 }
 
 // InterfaceSettings contains settings for the net interface
 type InterfaceSettings struct {
 	AnnounceInterval time.Duration
+	// HoldTime is how long a connected node may go without announcing before it is considered down.
+	// Defaults to three announce intervals. Link state advertisements are refreshed every hold time,
+	// and purged if their origin hasn't refreshed them in three hold times.
+	HoldTime time.Duration
+	// ProbeInterval is how often connected nodes are probed for latency. Defaults to the announce interval.
+	ProbeInterval time.Duration
+	// CostFunc computes link costs from measured latency. Defaults to DefaultCostFunc.
+	CostFunc CostFunc
+	// LinkCosts fixes the cost of links to the named connected nodes, instead of deriving it from latency.
+	LinkCosts map[string]int
+	// CostHysteresis is the fraction a link cost must change by before it is re-advertised. Defaults to 0.25.
+	CostHysteresis float64
+	// DataAddr is the address (host:port) other nodes send data packets to.
+	// Defaults to the address of the data reader.
+	DataAddr string
+	// RetransmitTimeout is how long SendReliable waits for an acknowledgement before the first retransmission.
+	// Each retransmission doubles the wait. Defaults to 250ms.
+	RetransmitTimeout time.Duration
+	// MaxRetransmits is how many times SendReliable and streams retransmit before giving up. Defaults to 5 if zero.
+	// A negative value disables retransmission for SendReliable, while streams keep the default.
+	MaxRetransmits int
+	// IdentityKey enables authentication and encryption of traffic with other nodes, which must also have one.
+	IdentityKey ed25519.PrivateKey
+	// TrustedKeys pins node names to their identity keys. Names which aren't pinned are bound
+	// to the first key seen claiming them.
+	TrustedKeys map[string]ed25519.PublicKey
+	// NewWriter creates writers for sending data to other nodes. Defaults to udp.NewUDPWriter.
+	NewWriter func(addr string) (udp.NetWriter, error)
+	// Clock times announcements, probes, expiry and retransmissions. Defaults to SystemClock.
+	Clock Clock
 }
 
 // Interface maintains connectivity with the mesh network,
 // and provides functions for sending and receiving on the network.
-// TODO:
-// function for sending to addr, send to node name
-// receive from node, receive from all
 type Interface struct {
-	dataSend    udp.NetWriter
 	dataReceive udp.NetReader
+	// dataSend caches writers to our connected nodes, keyed by address
+	dataSend cmap.ConcurrentMap
 
 	announceReceive udp.NetReader
 
 	settings *InterfaceSettings
 	ad       *announceDaemon
+	routes   *RoutingTable
+
+	prober *prober
+
+	reliable *reliableState
+	streams  *streamTable
+	echo     *echoState
+	security *security
+	counters *counters
 
-	ErrChan     chan<- error
-	MessageChan <-chan interface{}
+	// group owns every goroutine of the interface, which return once it is closed
+	group     *supervisor
+	errs      chan error
+	closeOnce sync.Once
+	inbox     *inbox
 }
 
-// NewInterface creates a net interface.
-// addr must be of form ip:port.
-// returns error if udp address resolution fails.
-func NewInterface(nodeName string, dataReceive udp.NetReader, announceSend udp.NetWriter, announceReceive udp.NetReader, settings InterfaceSettings) (*Interface, error) {
-	errChan := make(chan error)
+// NewInterface creates a net interface, which runs until ctx is done or it is closed.
+// returns error if the readers can't be started.
+func NewInterface(ctx context.Context, nodeName string, dataReceive udp.NetReader, announceSend udp.NetWriter, announceReceive udp.NetReader, settings InterfaceSettings) (*Interface, error) {
+	if settings.DataAddr == "" {
+		settings.DataAddr = dataReceive.ReadAddr()
+	}
+	if settings.HoldTime == 0 {
+		settings.HoldTime = settings.AnnounceInterval * 3
+	}
+	if settings.ProbeInterval == 0 {
+		settings.ProbeInterval = settings.AnnounceInterval
+	}
+	if settings.CostFunc == nil {
+		settings.CostFunc = DefaultCostFunc
+	}
+	if settings.CostHysteresis == 0 {
+		settings.CostHysteresis = defaultCostHysteresis
+	}
+	if settings.RetransmitTimeout == 0 {
+		settings.RetransmitTimeout = defaultRetransmitTimeout
+	}
+	if settings.MaxRetransmits == 0 {
+		settings.MaxRetransmits = defaultMaxRetransmits
+	}
+	if settings.NewWriter == nil {
+		settings.NewWriter = newUDPWriter
+	}
+	if settings.Clock == nil {
+		settings.Clock = SystemClock{}
+	}
 
-	// TODO create data sender when a recipient is determined
+	var sec *security
+	if settings.IdentityKey != nil {
+		var err error
+		if sec, err = newSecurity(nodeName, settings.IdentityKey, settings.TrustedKeys, settings.Clock.Now()); err != nil {
+			return nil, err
+		}
+	}
 
 	recvChan, err := dataReceive.StartReceiving("data")
 	if err != nil {
@@ -53,40 +138,208 @@ func NewInterface(nodeName string, dataReceive udp.NetReader, announceSend udp.N
 
 	mRecvChan, err := announceReceive.StartReceiving("announce")
 	if err != nil {
+		dataReceive.StopReceiving()
 		return nil, err
 	}
 
 	m := cmap.New()
+	routes := NewRoutingTable(nodeName)
+	errs := make(chan error, errBufferSize)
 
-	return &Interface{
+	n := &Interface{
 		dataReceive:     dataReceive,
+		dataSend:        cmap.New(),
 		announceReceive: announceReceive,
 		settings:        &settings,
-		ErrChan:         errChan,
-		MessageChan:     recvChan,
+		group:           newSupervisor(ctx),
+		errs:            errs,
+		inbox:           newInbox(inboxSize),
+		routes:          routes,
+		prober:          newProber(),
+		reliable:        newReliableState(),
+		streams:         newStreamTable(),
+		echo:            newEchoState(),
+		counters:        &counters{},
 		ad: &announceDaemon{
-			identity:         Identity{nodeName, dataReceive.ReadAddr()}, // TODO what is my external ip?
-			w:                announceSend,
-			errChan:          errChan,
-			announceInterval: settings.AnnounceInterval,
-			msgChan:          mRecvChan,
-			stopChan:         make(chan bool),
-			doneStoppingChan: make(chan bool),
-			connectedNodes:   m,
-			acceptOwnPackets: false,
+			identity:           Identity{NodeName: nodeName, Addr: settings.DataAddr},
+			epoch:              uint64(settings.Clock.Now().UnixNano()),
+			clock:              settings.Clock,
+			w:                  announceSend,
+			errs:               errs,
+			announceInterval:   settings.AnnounceInterval,
+			msgChan:            mRecvChan,
+			announceUpdateChan: make(chan bool, 1),
+			connectedNodes:     m,
+			holdTime:           settings.HoldTime,
+			events:             make(chan NodeEvent, eventBufferSize),
+			lsdb:               cmap.New(),
+			routes:             routes,
+			costFunc:           settings.CostFunc,
+			costHysteresis:     settings.CostHysteresis,
+			linkCosts:          settings.LinkCosts,
+			acceptOwnPackets:   false,
 		},
-	}, nil
+	}
+
+	n.ad.counters = n.counters
+
+	if sec != nil {
+		n.security = sec
+		n.ad.security = sec
+		n.ad.identity.PublicKey = sec.publicKey
+	}
+
+	// readers are stopped once we're done, and the receiving goroutines return once their channels are closed
+	for _, r := range []udp.NetReader{dataReceive, announceReceive} {
+		r := r
+		n.group.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			r.StopReceiving()
+			return nil
+		})
+	}
+	n.group.Go(func(ctx context.Context) error {
+		return n.receiveData(ctx, recvChan)
+	})
+	n.group.Go(func(ctx context.Context) error {
+		return n.ad.receive(ctx)
+	})
+
+	return n, nil
 }
 
-// StartAnnounce starts announcing the node to the network
+// StartAnnounce starts announcing the node to the network, and probing the latency to connected nodes
 func (n *Interface) StartAnnounce() {
-	n.ad.StartAnnounceDaemon()
+	n.ad.StartAnnounceDaemon(n.group)
+	n.startProbing()
+}
+
+// Errors returns a channel which yields errors encountered while running, such as failed announcements.
+// Errors are dropped if the channel is not read from. The channel is closed once the interface is closed.
+func (n *Interface) Errors() <-chan error {
+	return n.errs
 }
 
-// Close stops the announce daemon and closes all open connections and channels
-func (n *Interface) Close() {
-	n.dataReceive.StopReceiving()
-	n.announceReceive.StopReceiving()
-	n.ad.StopAnnounceDaemon()
-	close(n.ErrChan)
+// Events returns a channel which yields an event whenever a connected node comes up or goes down.
+// Events are dropped if the channel is not read from.
+func (n *Interface) Events() <-chan NodeEvent {
+	return n.ad.events
+}
+
+// RoutingTable returns the routing table built from the network topology
+func (n *Interface) RoutingTable() *RoutingTable {
+	return n.routes
+}
+
+// Identity returns the identity the node announces to the network
+func (n *Interface) Identity() Identity {
+	return n.ad.identity
+}
+
+// Neighbors returns a snapshot of the connected nodes, sorted by name
+func (n *Interface) Neighbors() []NodeInfo {
+	neighbors := make([]NodeInfo, 0, n.ad.connectedNodes.Count())
+	for _, e := range n.ad.connectedNodes.Items() {
+		neighbors = append(neighbors, *e.(*NodeInfo))
+	}
+	sort.Slice(neighbors, func(i, j int) bool {
+		return neighbors[i].NodeName < neighbors[j].NodeName
+	})
+
+	return neighbors
+}
+
+// Topology returns the network graph as known from link state advertisements, including our own:
+// the cost of the links from each node to its connected nodes.
+func (n *Interface) Topology() map[string]map[string]int {
+	topology := make(map[string]map[string]int)
+	for origin, e := range n.ad.lsdb.Items() {
+		adj := make(map[string]int)
+		for nodeName, cost := range e.(*linkStateEntry).lsa.Adjacencies {
+			adj[nodeName] = cost
+		}
+		topology[origin] = adj
+	}
+
+	return topology
+}
+
+// Close stops the interface and its readers, and waits for all of its goroutines to return, or for ctx to be done.
+// Returns the error of ctx if it was done first, in which case Close may be called again to keep waiting.
+// Returns ErrReaderStopped if a reader stopped before the interface was closed.
+func (n *Interface) Close(ctx context.Context) error {
+	err := n.group.Stop(ctx)
+
+	select {
+	case <-n.group.Done():
+		n.closeOnce.Do(func() {
+			close(n.errs)
+		})
+	default:
+	}
+
+	return err
+}
+
+func newUDPWriter(addr string) (udp.NetWriter, error) {
+	w, err := udp.NewUDPWriter(addr)
+	if err != nil {
+		return nil, err
+	}
+	w.SetTag("data")
+
+	return w, nil
+}
+
+// receiveData handles packets from the data reader until it is stopped.
+// Returns ErrReaderStopped if it stopped before ctx was done.
+func (n *Interface) receiveData(ctx context.Context, recvChan <-chan interface{}) error {
+	defer n.streams.close()
+	defer n.inbox.close()
+
+	for msgIn := range recvChan {
+		if ctx.Err() != nil {
+			// drain the reader until it is stopped
+			continue
+		}
+		n.handleDataMessage(msgIn)
+	}
+
+	if ctx.Err() == nil {
+		return ErrReaderStopped
+	}
+
+	return nil
+}
+
+func (n *Interface) handleDataMessage(msgIn interface{}) {
+	if n.security != nil {
+		sealed, ok := msgIn.(SealedPacket)
+		if !ok {
+			n.counters.addDropped(DropUnsealed)
+			log.Debug().Interface("msgIn", msgIn).Msg("Dropping unsealed packet")
+			return
+		}
+
+		var err error
+		if msgIn, err = n.open(sealed); err != nil {
+			if err == ErrReplay {
+				n.counters.addDropped(DropReplay)
+			} else {
+				n.counters.addDropped(DropBadSeal)
+			}
+			log.Debug().Err(err).Str("source", sealed.Source).Msg("Dropping sealed packet")
+			return
+		}
+	}
+
+	switch m := msgIn.(type) {
+	case DataPacket:
+		n.handleData(m)
+	case ProbePacket:
+		n.handleProbe(m)
+	default:
+		n.counters.addDropped(DropUnknown)
+		log.Error().Interface("msgIn", msgIn).Msg("got unknown message on data reader")
+	}
 }
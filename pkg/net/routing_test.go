@@ -1,5 +1,15 @@
 package net
 
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
 const (
 	uniAddr = ":1145"
 )
@@ -16,9 +26,95 @@ var iface *Interface
 // 	iface = i
 // }
 
-// func TestInterface_Announce(t *testing.T) {
-// 	testiface, err := NewInterface("testNode", uniAddr, multiAddr, InterfaceSettings{time.Second * 1})
-// 	require.NoError(t, err)
+func TestInterface_NeighborsAndTopology(t *testing.T) {
+	n1, _, _, _ := newPipedInterfaces(t)
+	n1.ad.connectedNodes.Set("n0", &NodeInfo{NodeName: "n0"})
+	n1.ad.lsdb.Set("n2", &linkStateEntry{&LinkStatePacket{Packet{1, 0}, "n2", map[string]int{"n1": 3}, nil, nil}, time.Now()})
 
-// 	testiface.MessageChan
-// }
+	neighbors := n1.Neighbors()
+	assert.Len(t, neighbors, 2)
+	assert.Equal(t, "n0", neighbors[0].NodeName)
+	assert.Equal(t, "n2", neighbors[1].NodeName)
+
+	n1.ad.originateLinkState()
+	assert.Equal(t, map[string]map[string]int{
+		"n1": {"n0": DefaultCostFunc(0, 0), "n2": DefaultCostFunc(0, 0)},
+		"n2": {"n1": 3},
+	}, n1.Topology())
+
+	assert.Equal(t, "n1", n1.Identity().NodeName)
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(interface{}) error {
+	return errors.New("write failed")
+}
+
+func (failingWriter) WriteAddr() string {
+	return "failing"
+}
+
+func TestInterface_Close(t *testing.T) {
+	n1, _, w1, _ := newPipedInterfaces(t)
+	n1.StartAnnounce()
+
+	// blocked retransmitting a message which is never acknowledged
+	w1.drop = func(interface{}) bool {
+		return true
+	}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- n1.SendReliable("n2", []byte("lost"))
+	}()
+	time.Sleep(time.Millisecond * 5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, n1.Close(ctx))
+
+	assert.Equal(t, ErrClosed, <-sent)
+	_, err := n1.Receive(context.Background())
+	assert.Equal(t, ErrClosed, err)
+	_, open := <-n1.Errors()
+	assert.False(t, open)
+
+	// closing again is harmless
+	assert.NoError(t, n1.Close(ctx))
+}
+
+func TestInterface_ContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n, err := NewInterface(ctx, "n1", &chanReader{}, &recordingWriter{}, &chanReader{}, InterfaceSettings{AnnounceInterval: time.Second})
+	require.NoError(t, err)
+	n.StartAnnounce()
+
+	cancel()
+	receiveCtx, cancelReceive := context.WithTimeout(context.Background(), time.Second)
+	defer cancelReceive()
+	_, err = n.Receive(receiveCtx)
+	assert.Equal(t, ErrClosed, err)
+
+	assert.NoError(t, n.Close(context.Background()))
+}
+
+func TestInterface_Errors(t *testing.T) {
+	n, err := NewInterface(context.Background(), "n1", &chanReader{}, failingWriter{}, &chanReader{},
+		InterfaceSettings{AnnounceInterval: time.Millisecond})
+	require.NoError(t, err)
+	n.StartAnnounce()
+
+	// nothing reads the errors while the interface announces, which must not block it
+	deadline := time.Now().Add(time.Second * 5)
+	for len(n.Errors()) < errBufferSize {
+		require.True(t, time.Now().Before(deadline), "only %d errors buffered", len(n.Errors()))
+		time.Sleep(time.Millisecond * 5)
+	}
+	assert.EqualError(t, <-n.Errors(), "write failed")
+
+	// the interface is still announcing, rather than blocked on the full buffer
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, n.Close(ctx))
+}
@@ -0,0 +1,218 @@
+package net
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/Heanthor/rsec-net/internal/graph"
+	"github.com/rs/zerolog/log"
+)
+
+// Route describes how to reach a destination node from this node
+type Route struct {
+	Dest    string
+	NextHop string
+	Cost    int
+	Path    []string
+}
+
+// RoutingTable keeps a graph of the network topology in sync with our connected nodes
+// and received link state advertisements, and the shortest path to every node in it.
+type RoutingTable struct {
+	mu sync.RWMutex
+
+	nodeName string
+	graph    *graph.DirectedGraph
+	searcher graph.Searcher
+	// links holds the last adjacencies applied to the graph for each origin,
+	// so their edges can be replaced when the origin advertises new ones
+	links  map[string]map[string]int
+	routes map[string]Route
+
+	// recomputations is the number of times routes were recomputed, read atomically
+	recomputations uint64
+}
+
+// NewRoutingTable creates a routing table rooted at the given node.
+func NewRoutingTable(nodeName string) *RoutingTable {
+	r := &RoutingTable{
+		nodeName: nodeName,
+		graph:    graph.NewDirectedGraph(),
+		searcher: &graph.DijkstraSearcher{},
+		links:    make(map[string]map[string]int),
+		routes:   make(map[string]Route),
+	}
+	r.addNode(nodeName)
+
+	return r
+}
+
+// SetNeighbors replaces the links from this node to its directly connected nodes.
+func (r *RoutingTable) SetNeighbors(adjacencies map[string]int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.setLinks(r.nodeName, adjacencies)
+	r.recompute()
+}
+
+// UpdateLinkState replaces the links from the origin of the advertisement.
+func (r *RoutingTable) UpdateLinkState(lsa *LinkStatePacket) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.setLinks(lsa.Origin, lsa.Adjacencies)
+	r.recompute()
+}
+
+// RemoveNode removes the node and all links to and from it.
+// Links advertised to it by other nodes are restored if it is advertised again.
+func (r *RoutingTable) RemoveNode(nodeName string) {
+	if nodeName == r.nodeName {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.graph.RemoveNode(nodeName); err != nil {
+		return
+	}
+	delete(r.links, nodeName)
+
+	// we've lost our own link to it, but links other origins advertise to it are kept,
+	// since it may still be reachable through them once it's advertised again
+	if _, ok := r.links[r.nodeName][nodeName]; ok {
+		neighbors := make(map[string]int, len(r.links[r.nodeName]))
+		for k, v := range r.links[r.nodeName] {
+			if k != nodeName {
+				neighbors[k] = v
+			}
+		}
+		r.links[r.nodeName] = neighbors
+	}
+	r.recompute()
+}
+
+// RemoveLinkState removes the links advertised by the origin, keeping those other nodes advertise to it.
+func (r *RoutingTable) RemoveLinkState(origin string) {
+	if origin == r.nodeName {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.links[origin]; !ok {
+		return
+	}
+	for dest := range r.links[origin] {
+		r.graph.RemoveEdge(origin, dest)
+	}
+	delete(r.links, origin)
+	r.recompute()
+}
+
+// NextHop returns the directly connected node which packets for nodeName should be sent to.
+// Returns false if there is no known route to the node.
+func (r *RoutingTable) NextHop(nodeName string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	route, ok := r.routes[nodeName]
+
+	return route.NextHop, ok
+}
+
+// Routes returns a snapshot of the routes to every reachable node, keyed by destination.
+func (r *RoutingTable) Routes() map[string]Route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	routes := make(map[string]Route, len(r.routes))
+	for k, v := range r.routes {
+		routes[k] = v
+	}
+
+	return routes
+}
+
+// Recomputations returns the number of times the routes have been recomputed
+func (r *RoutingTable) Recomputations() uint64 {
+	return atomic.LoadUint64(&r.recomputations)
+}
+
+// addNode adds the node to the graph if it isn't already in it, and returns true if it was added
+func (r *RoutingTable) addNode(key string) bool {
+	if _, err := r.graph.GetNode(key); err == nil {
+		return false
+	}
+	r.graph.AddNode(&graph.Node{Key: key})
+
+	return true
+}
+
+func (r *RoutingTable) setLinks(origin string, adjacencies map[string]int) {
+	var added []string
+	if r.addNode(origin) {
+		added = append(added, origin)
+	}
+
+	for dest := range r.links[origin] {
+		r.graph.RemoveEdge(origin, dest)
+	}
+
+	for dest, cost := range adjacencies {
+		if r.addNode(dest) {
+			added = append(added, dest)
+		}
+		r.graph.AddEdge(origin, dest, cost)
+	}
+
+	r.links[origin] = adjacencies
+
+	// removing a node took the links other origins advertised to it out of the graph,
+	// so put them back now it has been advertised again
+	for _, key := range added {
+		for from, adj := range r.links {
+			cost, ok := adj[key]
+			if !ok || from == origin {
+				continue
+			}
+			if _, err := r.graph.GetNode(from); err == nil {
+				r.graph.AddEdge(from, key, cost)
+			}
+		}
+	}
+}
+
+// recompute runs a shortest path search from us to every node in the graph.
+// Must be called with the write lock held.
+func (r *RoutingTable) recompute() {
+	routes := make(map[string]Route)
+
+	for dest, path := range r.searcher.ShortestPaths(r.graph, r.nodeName) {
+		if dest == r.nodeName || len(path) < 2 {
+			continue
+		}
+
+		route := Route{
+			Dest:    dest,
+			NextHop: path[1].Key,
+			Path:    make([]string, len(path)),
+		}
+		for i, n := range path {
+			route.Path[i] = n.Key
+			if i > 0 {
+				cost, _ := r.graph.GetEdgeCost(path[i-1].Key, n.Key)
+				route.Cost += cost
+			}
+		}
+
+		routes[dest] = route
+	}
+
+	r.routes = routes
+	atomic.AddUint64(&r.recomputations, 1)
+	log.Debug().Int("routes", len(routes)).Msg("Recomputed routing table")
+}
@@ -0,0 +1,93 @@
+package net
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutingTable_MultiHop(t *testing.T) {
+	r := NewRoutingTable("n1")
+	r.SetNeighbors(map[string]int{"n2": 1})
+	r.UpdateLinkState(&LinkStatePacket{Packet{1, 0}, "n2", map[string]int{"n1": 1, "n3": 1}, nil, nil})
+	r.UpdateLinkState(&LinkStatePacket{Packet{1, 0}, "n3", map[string]int{"n2": 1, "n4": 1}, nil, nil})
+
+	hop, ok := r.NextHop("n4")
+	assert.True(t, ok)
+	assert.Equal(t, "n2", hop)
+
+	routes := r.Routes()
+	assert.Len(t, routes, 3)
+	assert.Equal(t, Route{"n4", "n2", 3, []string{"n1", "n2", "n3", "n4"}}, routes["n4"])
+	assert.Equal(t, uint64(3), r.Recomputations())
+}
+
+func TestRoutingTable_CheaperPath(t *testing.T) {
+	r := NewRoutingTable("n1")
+	r.SetNeighbors(map[string]int{"n2": 1, "n3": 10})
+	r.UpdateLinkState(&LinkStatePacket{Packet{1, 0}, "n2", map[string]int{"n3": 2}, nil, nil})
+
+	hop, ok := r.NextHop("n3")
+	assert.True(t, ok)
+	assert.Equal(t, "n2", hop)
+
+	// n2 loses its link to n3, so the direct link is used
+	r.UpdateLinkState(&LinkStatePacket{Packet{2, 0}, "n2", map[string]int{}, nil, nil})
+
+	hop, ok = r.NextHop("n3")
+	assert.True(t, ok)
+	assert.Equal(t, "n3", hop)
+}
+
+func TestRoutingTable_RemoveNode_RestoresLinksWhenReadvertised(t *testing.T) {
+	r := NewRoutingTable("n1")
+	r.SetNeighbors(map[string]int{"n2": 1, "n3": 5})
+	r.UpdateLinkState(&LinkStatePacket{Packet{1, 0}, "n3", map[string]int{"n2": 1}, nil, nil})
+
+	// n1 loses its direct link to n2, which is still advertised by n3
+	r.SetNeighbors(map[string]int{"n3": 5})
+	r.RemoveNode("n2")
+	_, ok := r.NextHop("n2")
+	assert.False(t, ok)
+
+	// n2 comes back through its own advertisement, and the link from n3 is used again
+	r.UpdateLinkState(&LinkStatePacket{Packet{1, 0}, "n2", map[string]int{"n3": 1}, nil, nil})
+	hop, ok := r.NextHop("n2")
+	assert.True(t, ok)
+	assert.Equal(t, "n3", hop)
+	assert.Equal(t, []string{"n1", "n3", "n2"}, r.Routes()["n2"].Path)
+}
+
+func TestRoutingTable_Unreachable(t *testing.T) {
+	r := NewRoutingTable("n1")
+	r.SetNeighbors(map[string]int{"n2": 1})
+	r.UpdateLinkState(&LinkStatePacket{Packet{1, 0}, "n3", map[string]int{"n4": 1}, nil, nil})
+
+	_, ok := r.NextHop("n4")
+	assert.False(t, ok)
+
+	_, ok = r.NextHop("n1")
+	assert.False(t, ok)
+
+	r.SetNeighbors(map[string]int{})
+	_, ok = r.NextHop("n2")
+	assert.False(t, ok)
+}
+
+func TestRoutingTable_RemoveNode(t *testing.T) {
+	r := NewRoutingTable("n1")
+	r.SetNeighbors(map[string]int{"n2": 1, "n3": 1})
+	r.UpdateLinkState(&LinkStatePacket{Packet{1, 0}, "n2", map[string]int{"n4": 1}, nil, nil})
+
+	r.RemoveNode("n2")
+
+	routes := r.Routes()
+	assert.Len(t, routes, 1)
+	assert.Contains(t, routes, "n3")
+
+	// the node comes back when it is advertised again
+	r.UpdateLinkState(&LinkStatePacket{Packet{1, 0}, "n3", map[string]int{"n2": 1}, nil, nil})
+	hop, ok := r.NextHop("n2")
+	assert.True(t, ok)
+	assert.Equal(t, "n3", hop)
+}
@@ -0,0 +1,326 @@
+package net
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Traffic between connected nodes is authenticated and encrypted when the interface has an identity key.
+// Announcements and link state advertisements are signed with the node's long-term Ed25519 identity key,
+// and the signing key must be the one bound to the node name (see keyring.go). Packets without a valid
+// signature are dropped.
+//
+// At startup, each node also generates an X25519 key, which it includes in its announcements.
+// Two connected nodes combine their X25519 keys into a shared secret, and derive a key for each direction
+// from it with HKDF. Every packet sent on the data port is then sealed for the next hop with ChaCha20-Poly1305,
+// using a per-direction counter as the nonce. Packets which aren't sealed, or fail to open, are dropped.
+//
+// Keys derived from the same pair of X25519 keys are always the same, so sessions are kept for as long as the peer
+// keeps its X25519 key, even when it expires and comes back. Its counters then carry on where they left off, and
+// no nonce is used twice with the same key.
+//
+// Forwarding nodes open and re-seal data packets, so traffic is protected on each link, not end to end.
+//
+// Sealed packets and announcements carry a counter, which is checked against a sliding window of the counters
+// already received from the node, so captured packets can't be replayed. Announcement counters start at the
+// time the node started in unix nanoseconds, so they keep increasing across restarts.
+
+// signature contexts, so a signature over one kind of packet can't be passed off as another
+const (
+	announceContext  = "rsec-net announce v1"
+	linkStateContext = "rsec-net link state v1"
+	sessionContext   = "rsec-net session v1"
+)
+
+var (
+	// ErrNoSession is returned when sending to a node before receiving its ephemeral key
+	ErrNoSession = errors.New("no session with node")
+	// ErrBadSignature is returned when a packet's signature is missing, or doesn't match its contents
+	ErrBadSignature = errors.New("bad signature")
+	// ErrOpen is returned when a sealed packet fails authentication
+	ErrOpen = errors.New("unable to open sealed packet")
+)
+
+// security holds the keys of a node which authenticates and encrypts its traffic
+type security struct {
+	identityKey     ed25519.PrivateKey
+	publicKey       ed25519.PublicKey
+	ephemeralKey    []byte
+	ephemeralPublic []byte
+	keys            *keyring
+
+	// announceCounter is the counter of the last announcement sent, incremented atomically
+	announceCounter uint64
+	// announceWindows holds the replay window of announcements received from each node name
+	announceWindows   map[string]*replayWindow
+	announceWindowsMu sync.Mutex
+
+	// sessions holds the latest session with each node name, which outlives the node's expiry
+	sessions   map[string]*session
+	sessionsMu sync.Mutex
+}
+
+// newSecurity creates the security state of a node. Announcement counters start at now, in unix nanoseconds.
+func newSecurity(nodeName string, identityKey ed25519.PrivateKey, trusted map[string]ed25519.PublicKey, now time.Time) (*security, error) {
+	ephemeralKey := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, ephemeralKey); err != nil {
+		return nil, err
+	}
+
+	ephemeralPublic, err := curve25519.X25519(ephemeralKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &security{
+		identityKey:     identityKey,
+		publicKey:       identityKey.Public().(ed25519.PublicKey),
+		ephemeralKey:    ephemeralKey,
+		ephemeralPublic: ephemeralPublic,
+		keys:            newKeyring(trusted),
+		announceCounter: uint64(now.UnixNano()),
+		announceWindows: make(map[string]*replayWindow),
+		sessions:        make(map[string]*session),
+	}
+	// nobody else may claim our name
+	s.keys.bind(nodeName, s.publicKey)
+
+	return s, nil
+}
+
+func signedMessage(context string, payload []byte) []byte {
+	return append([]byte(context), payload...)
+}
+
+// verify checks the signature over the payload, and that the key is the one bound to the node name
+func (s *security) verify(nodeName string, key ed25519.PublicKey, context string, payload, signature []byte) error {
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, signedMessage(context, payload), signature) {
+		return ErrBadSignature
+	}
+
+	return s.keys.check(nodeName, key)
+}
+
+// signAnnounce adds our keys and the next counter to the announcement, and signs it
+func (s *security) signAnnounce(p *AnnouncePacket) {
+	p.PublicKey = s.publicKey
+	p.EphemeralKey = s.ephemeralPublic
+	p.Counter = atomic.AddUint64(&s.announceCounter, 1)
+	p.Signature = ed25519.Sign(s.identityKey, signedMessage(announceContext, p.signedPayload()))
+}
+
+func (s *security) verifyAnnounce(p *AnnouncePacket) error {
+	if len(p.EphemeralKey) != curve25519.PointSize {
+		return ErrBadSignature
+	}
+
+	if err := s.verify(p.NodeName, p.PublicKey, announceContext, p.signedPayload(), p.Signature); err != nil {
+		return err
+	}
+
+	s.announceWindowsMu.Lock()
+	w, ok := s.announceWindows[p.NodeName]
+	if !ok {
+		w = &replayWindow{}
+		s.announceWindows[p.NodeName] = w
+	}
+	s.announceWindowsMu.Unlock()
+
+	if !w.accept(p.Counter) {
+		return ErrReplay
+	}
+
+	return nil
+}
+
+// signLinkState adds our identity key to the advertisement, and signs it
+func (s *security) signLinkState(lsa *LinkStatePacket) {
+	lsa.PublicKey = s.publicKey
+	lsa.Signature = ed25519.Sign(s.identityKey, signedMessage(linkStateContext, lsa.signedPayload()))
+}
+
+func (s *security) verifyLinkState(lsa *LinkStatePacket) error {
+	return s.verify(lsa.Origin, lsa.PublicKey, linkStateContext, lsa.signedPayload(), lsa.Signature)
+}
+
+// session seals and opens packets exchanged with a connected node
+type session struct {
+	peerPublicKey    ed25519.PublicKey
+	peerEphemeralKey []byte
+	send             cipher.AEAD
+	receive          cipher.AEAD
+	// sendCounter is the nonce of the next sealed packet, incremented atomically
+	sendCounter uint64
+	replay      replayWindow
+}
+
+// sessionWith returns the session with the node which sent the verified announcement. The previous session
+// with the node is resumed if it has the same keys, and a new one derived otherwise.
+func (s *security) sessionWith(peer *AnnouncePacket) (*session, error) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	if sess, ok := s.sessions[peer.NodeName]; ok && sess.matches(peer) {
+		return sess, nil
+	}
+
+	sess, err := s.newSession(peer)
+	if err != nil {
+		return nil, err
+	}
+	s.sessions[peer.NodeName] = sess
+
+	return sess, nil
+}
+
+// newSession derives the keys for a session with the node which sent the verified announcement
+func (s *security) newSession(peer *AnnouncePacket) (*session, error) {
+	shared, err := curve25519.X25519(s.ephemeralKey, peer.EphemeralKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// both nodes must derive the same keys, so order the inputs by ephemeral key
+	type nodeKeys struct{ public, ephemeral []byte }
+	local, remote := nodeKeys{s.publicKey, s.ephemeralPublic}, nodeKeys{peer.PublicKey, peer.EphemeralKey}
+	localFirst := bytes.Compare(local.ephemeral, remote.ephemeral) < 0
+	first, second := local, remote
+	if !localFirst {
+		first, second = remote, local
+	}
+
+	var info udp.Encoder
+	info.PutString(sessionContext)
+	info.PutBytes(first.public)
+	info.PutBytes(first.ephemeral)
+	info.PutBytes(second.public)
+	info.PutBytes(second.ephemeral)
+
+	keys := make([]byte, chacha20poly1305.KeySize*2)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, nil, info.Encoded()), keys); err != nil {
+		return nil, err
+	}
+
+	// the first key seals packets sent by the first node, the second key packets sent by the second
+	firstAEAD, err := chacha20poly1305.New(keys[:chacha20poly1305.KeySize])
+	if err != nil {
+		return nil, err
+	}
+	secondAEAD, err := chacha20poly1305.New(keys[chacha20poly1305.KeySize:])
+	if err != nil {
+		return nil, err
+	}
+
+	sess := &session{
+		peerPublicKey:    peer.PublicKey,
+		peerEphemeralKey: peer.EphemeralKey,
+		send:             firstAEAD,
+		receive:          secondAEAD,
+	}
+	if !localFirst {
+		sess.send, sess.receive = secondAEAD, firstAEAD
+	}
+
+	return sess, nil
+}
+
+// matches returns whether the session was derived from the keys in the announcement
+func (s *session) matches(peer *AnnouncePacket) bool {
+	return bytes.Equal(s.peerPublicKey, peer.PublicKey) && bytes.Equal(s.peerEphemeralKey, peer.EphemeralKey)
+}
+
+// seal encrypts and authenticates the frame for the peer
+func (s *session) seal(source string, frame []byte) SealedPacket {
+	p := SealedPacket{
+		Source:  source,
+		Counter: atomic.AddUint64(&s.sendCounter, 1) - 1,
+	}
+	p.Ciphertext = s.send.Seal(nil, sealNonce(p.Counter), frame, p.additionalData())
+
+	return p
+}
+
+// open authenticates and decrypts a frame sealed by the peer
+func (s *session) open(p SealedPacket) ([]byte, error) {
+	frame, err := s.receive.Open(nil, sealNonce(p.Counter), p.Ciphertext, p.additionalData())
+	if err != nil {
+		return nil, ErrOpen
+	}
+
+	return frame, nil
+}
+
+func sealNonce(counter uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[chacha20poly1305.NonceSize-8:], counter)
+
+	return nonce
+}
+
+// additionalData authenticates the unencrypted fields of the packet
+func (p SealedPacket) additionalData() []byte {
+	var e udp.Encoder
+	e.PutString(p.Source)
+	e.PutUint64(p.Counter)
+
+	return e.Encoded()
+}
+
+// seal marshals the packet, and seals it for the connected node
+func (n *Interface) seal(info *NodeInfo, p interface{}) (SealedPacket, error) {
+	if info.session == nil {
+		return SealedPacket{}, ErrNoSession
+	}
+
+	frame, err := udp.Marshal(p)
+	if err != nil {
+		return SealedPacket{}, err
+	}
+
+	return info.session.seal(n.ad.identity.NodeName, frame), nil
+}
+
+// open opens a packet sealed by a connected node, and unmarshals it
+func (n *Interface) open(p SealedPacket) (interface{}, error) {
+	e, ok := n.ad.connectedNodes.Get(p.Source)
+	if !ok || e.(*NodeInfo).session == nil {
+		return nil, ErrNoSession
+	}
+
+	sess := e.(*NodeInfo).session
+	frame, err := sess.open(p)
+	if err != nil {
+		return nil, err
+	}
+	if !sess.replay.accept(p.Counter) {
+		return nil, ErrReplay
+	}
+
+	return udp.Unmarshal(frame)
+}
+
+// updateSession returns a new session for the node which sent the verified announcement,
+// if its ephemeral key has changed since the current one. Returns nil if the session is current.
+func (a *announceDaemon) updateSession(ap *AnnouncePacket) (*session, error) {
+	if e, ok := a.connectedNodes.Get(ap.NodeName); ok {
+		if current := e.(*NodeInfo).session; current != nil && current.matches(ap) {
+			return nil, nil
+		}
+	}
+
+	return a.security.sessionWith(ap)
+}
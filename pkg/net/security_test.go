@@ -0,0 +1,303 @@
+package net
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSecurity(t *testing.T, nodeName string) *security {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	s, err := newSecurity(nodeName, key, nil, time.Now())
+	require.NoError(t, err)
+
+	return s
+}
+
+func signedAnnounce(s *security, nodeName string) *AnnouncePacket {
+	p := &AnnouncePacket{Packet: Packet{1, 0}, Identity: Identity{nodeName, nodeName + ":1146", nil}}
+	s.signAnnounce(p)
+
+	return p
+}
+
+func TestSecurity_VerifyAnnounce(t *testing.T) {
+	s1, s2 := newTestSecurity(t, "n1"), newTestSecurity(t, "n2")
+
+	p := signedAnnounce(s1, "n1")
+	assert.NoError(t, s2.verifyAnnounce(p))
+
+	// survives the wire, though a second copy is a replay
+	decoded := roundTrip(t, *p).(AnnouncePacket)
+	assert.Equal(t, ErrReplay, s2.verifyAnnounce(&decoded))
+	assert.NoError(t, newTestSecurity(t, "n3").verifyAnnounce(&decoded))
+
+	tampered := *p
+	tampered.ConnectedNodes = []string{"n3"}
+	assert.Equal(t, ErrBadSignature, s2.verifyAnnounce(&tampered))
+
+	unsigned := &AnnouncePacket{Packet: Packet{1, 0}, Identity: Identity{"n1", "n1:1146", nil}}
+	assert.Equal(t, ErrBadSignature, s2.verifyAnnounce(unsigned))
+
+	// n1 is bound to the key which first claimed it
+	impostor := newTestSecurity(t, "n1")
+	assert.Equal(t, ErrKeyMismatch, s2.verifyAnnounce(signedAnnounce(impostor, "n1")))
+
+	// nobody may claim our own name
+	assert.Equal(t, ErrKeyMismatch, s2.verifyAnnounce(signedAnnounce(impostor, "n2")))
+}
+
+func TestSecurity_VerifyLinkState(t *testing.T) {
+	s1, s2 := newTestSecurity(t, "n1"), newTestSecurity(t, "n2")
+
+	lsa := &LinkStatePacket{Packet{1, 0}, "n1", map[string]int{"n2": 1, "n3": 4}, nil, nil}
+	s1.signLinkState(lsa)
+	assert.NoError(t, s2.verifyLinkState(lsa))
+
+	decoded := roundTrip(t, *lsa).(LinkStatePacket)
+	assert.NoError(t, s2.verifyLinkState(&decoded))
+
+	tampered := *lsa
+	tampered.Adjacencies = map[string]int{"n2": 1}
+	assert.Equal(t, ErrBadSignature, s2.verifyLinkState(&tampered))
+
+	// a signed announcement can't be passed off as an advertisement
+	p := signedAnnounce(s1, "n1")
+	assert.Equal(t, ErrBadSignature, s2.verifyLinkState(&LinkStatePacket{Packet{1, 0}, "n1", map[string]int{}, p.PublicKey, p.Signature}))
+}
+
+func TestSession_SealOpen(t *testing.T) {
+	s1, s2 := newTestSecurity(t, "n1"), newTestSecurity(t, "n2")
+
+	sess1, err := s1.newSession(signedAnnounce(s2, "n2"))
+	require.NoError(t, err)
+	sess2, err := s2.newSession(signedAnnounce(s1, "n1"))
+	require.NoError(t, err)
+
+	p := sess1.seal("n1", []byte("hello"))
+	assert.NotContains(t, string(p.Ciphertext), "hello")
+	frame, err := sess2.open(p)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), frame)
+
+	// each direction has its own key and counter
+	reply := sess2.seal("n2", []byte("hi"))
+	assert.Equal(t, uint64(0), reply.Counter)
+	frame, err = sess1.open(reply)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hi"), frame)
+	_, err = sess2.open(reply)
+	assert.Equal(t, ErrOpen, err, "opened own packet")
+
+	assert.Equal(t, uint64(1), sess1.seal("n1", nil).Counter)
+
+	tampered := p
+	tampered.Ciphertext = append([]byte{}, p.Ciphertext...)
+	tampered.Ciphertext[0] ^= 1
+	_, err = sess2.open(tampered)
+	assert.Equal(t, ErrOpen, err)
+
+	spoofed := p
+	spoofed.Source = "n3"
+	_, err = sess2.open(spoofed)
+	assert.Equal(t, ErrOpen, err)
+
+	// a third node can't open traffic between the other two
+	s3 := newTestSecurity(t, "n3")
+	sess3, err := s3.newSession(signedAnnounce(s1, "n1"))
+	require.NoError(t, err)
+	_, err = sess3.open(p)
+	assert.Equal(t, ErrOpen, err)
+}
+
+func newSecurePipedInterfaces(t *testing.T) (*Interface, *Interface, *pipeWriter, *pipeWriter) {
+	return pipedInterfaces(t, func(s *InterfaceSettings) {
+		_, s.IdentityKey, _ = ed25519.GenerateKey(rand.Reader)
+	})
+}
+
+// announce passes an announcement from one interface to another
+func announce(from, to *Interface) {
+	p := AnnouncePacket{Packet: Packet{1, 0}, Identity: from.ad.identity}
+	from.security.signAnnounce(&p)
+
+	if to.ad.announceUpdateChan == nil {
+		to.ad.announceUpdateChan = make(chan bool, 16)
+	}
+	to.ad.handleAnnounceResponse(&p)
+	to.routes.SetNeighbors(to.ad.adjacencies())
+}
+
+func TestInterface_Encrypted(t *testing.T) {
+	n1, n2, w1, _ := newSecurePipedInterfaces(t)
+	announce(n1, n2)
+	announce(n2, n1)
+
+	var sent []interface{}
+	w1.drop = func(data interface{}) bool {
+		sent = append(sent, data)
+		return false
+	}
+
+	require.NoError(t, n1.SendTo("n2", []byte("hello")))
+	e := receiveOne(t, n2)
+	assert.Equal(t, "n1", e.Source)
+	assert.Equal(t, []byte("hello"), e.Payload)
+
+	require.Len(t, sent, 1)
+	assert.IsType(t, SealedPacket{}, sent[0])
+}
+
+func TestInterface_Encrypted_ResumesSessionAfterExpiry(t *testing.T) {
+	n1, n2, _, _ := newSecurePipedInterfaces(t)
+	announce(n1, n2)
+	announce(n2, n1)
+
+	sealNext := func() SealedPacket {
+		e, ok := n1.ad.connectedNodes.Get("n2")
+		require.True(t, ok)
+		sealed, err := n1.seal(e.(*NodeInfo), DataPacket{Source: "n1", Dest: "n2", TTL: defaultTTL, Payload: []byte("hello")})
+		require.NoError(t, err)
+
+		return sealed
+	}
+	before := sealNext()
+
+	// n2 expires, then announces again without restarting
+	n1.ad.updateNode("n2", func(info *NodeInfo) {
+		info.LastSeen = time.Now().Add(-time.Hour)
+	})
+	n1.ad.expireNodes()
+	require.False(t, n1.ad.connectedNodes.Has("n2"))
+	announce(n2, n1)
+
+	after := sealNext()
+	assert.NotEqual(t, before.Counter, after.Counter, "nonce reused")
+
+	n2.handleDataMessage(before)
+	n2.handleDataMessage(after)
+	assert.Equal(t, []byte("hello"), receiveOne(t, n2).Payload)
+	assert.Equal(t, []byte("hello"), receiveOne(t, n2).Payload)
+}
+
+func TestSecurity_SessionWith(t *testing.T) {
+	s1, s2 := newTestSecurity(t, "n1"), newTestSecurity(t, "n2")
+
+	sess, err := s1.sessionWith(signedAnnounce(s2, "n2"))
+	require.NoError(t, err)
+	resumed, err := s1.sessionWith(signedAnnounce(s2, "n2"))
+	require.NoError(t, err)
+	assert.True(t, sess == resumed, "session with the same keys not resumed")
+
+	// n2 restarted with a new ephemeral key
+	restarted := newTestSecurity(t, "n2")
+	restarted.identityKey, restarted.publicKey = s2.identityKey, s2.publicKey
+	fresh, err := s1.sessionWith(signedAnnounce(restarted, "n2"))
+	require.NoError(t, err)
+	assert.False(t, sess == fresh)
+	assert.Equal(t, uint64(0), fresh.seal("n1", nil).Counter)
+}
+
+func TestInterface_Encrypted_DropsUnauthenticated(t *testing.T) {
+	n1, n2, _, _ := newSecurePipedInterfaces(t)
+	announce(n1, n2)
+
+	// unsealed
+	n2.handleDataMessage(DataPacket{Source: "n1", Dest: "n2", TTL: defaultTTL, Payload: []byte("forged")})
+
+	// sealed by a node claiming to be n1, with a session n2 never agreed to
+	forger := newTestSecurity(t, "n1")
+	sess, err := forger.newSession(signedAnnounce(n2.security, "n2"))
+	require.NoError(t, err)
+	frame, err := udp.Marshal(DataPacket{Source: "n1", Dest: "n2", TTL: defaultTTL, Payload: []byte("forged")})
+	require.NoError(t, err)
+	n2.handleDataMessage(sess.seal("n1", frame))
+
+	assert.Equal(t, 0, n2.inbox.len())
+	assert.Equal(t, uint64(1), n2.Counters().Dropped[DropUnsealed])
+	assert.Equal(t, uint64(1), n2.Counters().Dropped[DropBadSeal])
+}
+
+func TestAnnounceDaemon_IgnoresUnauthenticated(t *testing.T) {
+	n1, n2, _, _ := newSecurePipedInterfaces(t)
+	n2.ad.announceUpdateChan = make(chan bool, 16)
+
+	n2.ad.handleAnnounceResponse(&AnnouncePacket{Packet: Packet{1, 0}, Identity: Identity{"n1", "n1:1146", nil}})
+	assert.Equal(t, 0, n2.ad.connectedNodes.Count())
+
+	announce(n1, n2)
+	assert.Equal(t, 1, n2.ad.connectedNodes.Count())
+
+	// an impostor claiming n1's name is ignored, including its advertisements
+	impostor := newTestSecurity(t, "n1")
+	p := signedAnnounce(impostor, "n1")
+	p.Addr = "impostor:1146"
+	n2.ad.handleAnnounceResponse(p)
+	e, _ := n2.ad.connectedNodes.Get("n1")
+	assert.Equal(t, n1.ad.identity.Addr, e.(*NodeInfo).Addr)
+
+	lsa := &LinkStatePacket{Packet{1, 0}, "n1", map[string]int{"n3": 1}, nil, nil}
+	impostor.signLinkState(lsa)
+	n2.ad.handleLinkState(lsa)
+	assert.False(t, n2.ad.lsdb.Has("n1"))
+	assert.Equal(t, uint64(3), n2.Counters().Dropped[DropBadSignature])
+
+	n1.security.signLinkState(lsa)
+	n2.ad.handleLinkState(lsa)
+	assert.True(t, n2.ad.lsdb.Has("n1"))
+}
+
+func TestInterface_Encrypted_DropsReplays(t *testing.T) {
+	n1, n2, _, _ := newSecurePipedInterfaces(t)
+	announce(n1, n2)
+	announce(n2, n1)
+
+	info, _ := n1.ad.connectedNodes.Get("n2")
+	sealed, err := n1.seal(info.(*NodeInfo), DataPacket{Source: "n1", Dest: "n2", TTL: defaultTTL, Payload: []byte("hello")})
+	require.NoError(t, err)
+
+	n2.handleDataMessage(sealed)
+	n2.handleDataMessage(sealed)
+
+	receiveOne(t, n2)
+	assert.Equal(t, 0, n2.inbox.len())
+	assert.Equal(t, uint64(1), n2.Counters().Dropped[DropReplay])
+}
+
+func TestAnnounceDaemon_IgnoresReplayedAnnounce(t *testing.T) {
+	n1, n2, _, _ := newSecurePipedInterfaces(t)
+	n2.ad.announceUpdateChan = make(chan bool, 16)
+
+	p := AnnouncePacket{Packet: Packet{1, 0}, Identity: n1.ad.identity}
+	n1.security.signAnnounce(&p)
+	n2.ad.handleAnnounceResponse(&p)
+	first, _ := n2.ad.connectedNodes.Get("n1")
+
+	n2.ad.handleAnnounceResponse(&p)
+	replayed, _ := n2.ad.connectedNodes.Get("n1")
+	assert.Equal(t, first.(*NodeInfo).LastSeen, replayed.(*NodeInfo).LastSeen, "replay refreshed the node")
+	assert.Equal(t, uint64(1), n2.Counters().Dropped[DropReplay])
+
+	// the next announcement has a new counter
+	next := AnnouncePacket{Packet: Packet{1, 0}, Identity: n1.ad.identity}
+	n1.security.signAnnounce(&next)
+	assert.Equal(t, p.Counter+1, next.Counter)
+	n2.ad.handleAnnounceResponse(&next)
+	assert.Equal(t, uint64(1), n2.Counters().Dropped[DropReplay])
+}
+
+func TestSecurity_AnnounceCounterStartsAtClock(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	s, err := newSecurity("n1", key, nil, time.Unix(0, 100))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(101), signedAnnounce(s, "n1").Counter)
+}
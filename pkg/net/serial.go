@@ -0,0 +1,21 @@
+package net
+
+// Sequence numbers are compared with serial number arithmetic (RFC 1982), so they keep working after
+// wrapping around. A sequence number is newer than another if it is ahead by less than half the number space.
+//
+// Sequence numbers restart when a node does, so packets also carry the node's epoch: the time it started
+// in unix nanoseconds. A packet from a later epoch is newer regardless of its sequence number.
+
+// seqAfter returns true if sequence number a is newer than b
+func seqAfter(a, b uint16) bool {
+	return int16(a-b) > 0
+}
+
+// newerThan returns true if the packet was sent after q by the same node
+func (p Packet) newerThan(q Packet) bool {
+	if p.Epoch != q.Epoch {
+		return p.Epoch > q.Epoch
+	}
+
+	return seqAfter(p.SequenceNum, q.SequenceNum)
+}
@@ -0,0 +1,33 @@
+package net
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeqAfter(t *testing.T) {
+	assert.True(t, seqAfter(2, 1))
+	assert.False(t, seqAfter(1, 2))
+	assert.False(t, seqAfter(1, 1))
+
+	// wraparound
+	assert.True(t, seqAfter(0, 65535))
+	assert.True(t, seqAfter(5, 65530))
+	assert.False(t, seqAfter(65535, 0))
+
+	// just under and over half the number space
+	assert.True(t, seqAfter(32767, 0))
+	assert.False(t, seqAfter(32768, 0))
+	assert.False(t, seqAfter(0, 32768))
+}
+
+func TestPacket_NewerThan(t *testing.T) {
+	assert.True(t, Packet{2, 10}.newerThan(Packet{1, 10}))
+	assert.True(t, Packet{0, 10}.newerThan(Packet{65535, 10}))
+	assert.False(t, Packet{1, 10}.newerThan(Packet{1, 10}))
+
+	// a restarted node starts again from a low sequence number, in a later epoch
+	assert.True(t, Packet{1, 20}.newerThan(Packet{500, 10}))
+	assert.False(t, Packet{500, 10}.newerThan(Packet{1, 20}))
+}
@@ -0,0 +1,670 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Streams carry ordered, reliable, flow controlled bytes between two nodes, as segments in data packets.
+// A stream is opened by a SYN segment, which the other side answers with SYN and ACK. After that,
+// writes are split into data segments numbered consecutively from zero. Every segment acknowledges
+// the next segment its sender expects, and advertises how many segments from there it can buffer (its window).
+// Senders keep at most a window of segments unacknowledged, and retransmit them with exponential backoff
+// until they are acknowledged. Closing a stream sends a FIN segment, which the other side reads as io.EOF.
+
+// stream segment flags
+const (
+	streamFlagSyn = 1 << iota
+	streamFlagAck
+	streamFlagFin
+	streamFlagRst
+	// streamFlagOpener marks segments sent by the node which opened the stream
+	streamFlagOpener
+)
+
+const (
+	// maxSegmentSize is the most stream data carried by a segment, keeping data packets within one frame
+	maxSegmentSize = 1024
+	// streamWindow is the number of segments buffered by each side of a stream, both for sending and receiving
+	streamWindow = 64
+	// acceptBacklog is the number of opened streams which can wait for AcceptStream before more are refused
+	acceptBacklog = 16
+)
+
+var (
+	// ErrStreamReset is returned when the other side aborts the stream
+	ErrStreamReset = errors.New("stream reset by peer")
+	// ErrStreamClosed is returned when using a stream after closing it
+	ErrStreamClosed = errors.New("stream closed")
+)
+
+// streamSegment is the payload of a stream data packet
+type streamSegment struct {
+	StreamID uint32
+	Flags    uint8
+	SeqNo    uint32
+	// AckNo is the next sequence number expected by the sender, valid if the ACK flag is set
+	AckNo  uint32
+	Window uint16
+	Data   []byte
+}
+
+type streamKey struct {
+	peer string
+	id   uint32
+	// opened is true for streams opened by this node, since both nodes may pick the same ID
+	opened bool
+}
+
+// streamTable holds the open streams of an interface
+type streamTable struct {
+	mu       sync.Mutex
+	streams  map[streamKey]*Stream
+	accept   chan *Stream
+	closed   chan struct{}
+	isClosed bool
+	rand     *rand.Rand
+}
+
+func newStreamTable() *streamTable {
+	return &streamTable{
+		streams: make(map[streamKey]*Stream),
+		accept:  make(chan *Stream, acceptBacklog),
+		closed:  make(chan struct{}),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// open adds a new stream to the peer, with an unused ID
+func (t *streamTable) open(n *Interface, peer string) (*Stream, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.isClosed {
+		return nil, ErrClosed
+	}
+
+	key := streamKey{peer: peer, opened: true}
+	for {
+		key.id = t.rand.Uint32()
+		if _, ok := t.streams[key]; !ok {
+			break
+		}
+	}
+
+	s := newStream(n, key)
+	t.streams[key] = s
+
+	return s, nil
+}
+
+// add adds a stream opened by the peer, and queues it for AcceptStream.
+// Returns false if the interface is closed, or too many streams are waiting to be accepted.
+func (t *streamTable) add(n *Interface, key streamKey) (*Stream, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.isClosed {
+		return nil, false
+	}
+
+	s := newStream(n, key)
+	s.establish()
+
+	select {
+	case t.accept <- s:
+	default:
+		return nil, false
+	}
+	t.streams[key] = s
+
+	return s, true
+}
+
+func (t *streamTable) get(key streamKey) (*Stream, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.streams[key]
+
+	return s, ok
+}
+
+func (t *streamTable) remove(key streamKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.streams, key)
+}
+
+// close fails all open streams, and stops accepting new ones
+func (t *streamTable) close() {
+	t.mu.Lock()
+	if t.isClosed {
+		t.mu.Unlock()
+		return
+	}
+	t.isClosed = true
+	close(t.closed)
+
+	streams := make([]*Stream, 0, len(t.streams))
+	for _, s := range t.streams {
+		streams = append(streams, s)
+	}
+	t.mu.Unlock()
+
+	for _, s := range streams {
+		s.mu.Lock()
+		s.finish(ErrClosed)
+		s.mu.Unlock()
+	}
+}
+
+// outSegment is a segment queued for sending, which is kept until it is acknowledged
+type outSegment struct {
+	seqNo       uint32
+	data        []byte
+	fin         bool
+	sentAt      time.Time
+	retransmits int
+}
+
+// Stream is an ordered, reliable, flow controlled byte stream to another node.
+// It implements io.ReadWriteCloser, and is safe for concurrent use.
+type Stream struct {
+	n   *Interface
+	key streamKey
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// established is closed once both sides have agreed to open the stream
+	established   chan struct{}
+	isEstablished bool
+	// err is set when the stream fails, and returned by all further operations
+	err      error
+	finished bool
+	// done is closed once the stream is finished, and removed from the stream table
+	done chan struct{}
+
+	// send fields
+	sendNext uint32
+	// outgoing holds unacknowledged segments, oldest first. The first inFlight of them have been sent.
+	outgoing   []*outSegment
+	inFlight   int
+	peerWindow int
+	finQueued  bool
+	finAcked   bool
+
+	// receive fields
+	recvNext    uint32
+	outOfOrder  map[uint32]streamSegment
+	readBuf     [][]byte
+	finReceived bool
+	readClosed  bool
+	advertised  int
+}
+
+func newStream(n *Interface, key streamKey) *Stream {
+	s := &Stream{
+		n:           n,
+		key:         key,
+		established: make(chan struct{}),
+		done:        make(chan struct{}),
+		outOfOrder:  make(map[uint32]streamSegment),
+		advertised:  streamWindow,
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	return s
+}
+
+// OpenStream opens a stream to the named node, which must accept it with AcceptStream.
+// Returns ErrDeliveryFailed if the node doesn't answer, or ErrClosed if the interface is closed first.
+func (n *Interface) OpenStream(nodeName string) (*Stream, error) {
+	if nodeName == n.ad.identity.NodeName {
+		return nil, ErrNoRoute
+	}
+
+	s, err := n.streams.open(n, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := n.settings.RetransmitTimeout
+	for attempt := 0; attempt <= n.streamRetransmits(); attempt++ {
+		s.mu.Lock()
+		s.send(streamSegment{Flags: streamFlagSyn})
+		s.mu.Unlock()
+
+		select {
+		case <-s.established:
+			n.group.Go(s.retransmitLoop)
+			return s, nil
+		case <-s.done:
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return nil, s.err
+		case <-n.group.ctx.Done():
+			s.mu.Lock()
+			s.finish(ErrClosed)
+			s.mu.Unlock()
+			return nil, ErrClosed
+		case <-n.settings.Clock.After(timeout):
+			timeout *= 2
+		}
+	}
+
+	s.mu.Lock()
+	s.finish(ErrDeliveryFailed)
+	s.mu.Unlock()
+
+	return nil, ErrDeliveryFailed
+}
+
+// AcceptStream returns the next stream opened to this node by another node.
+// Blocks until a stream is opened, the context is done, or the interface is closed.
+func (n *Interface) AcceptStream(ctx context.Context) (*Stream, error) {
+	select {
+	case s := <-n.streams.accept:
+		return s, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-n.streams.closed:
+		return nil, ErrClosed
+	}
+}
+
+// handleStreamSegment passes a stream segment to its stream
+func (n *Interface) handleStreamSegment(p DataPacket) {
+	seg, err := decodeStreamSegment(p.Payload)
+	if err != nil {
+		log.Debug().Err(err).Str("source", p.Source).Msg("Dropping bad stream segment")
+		return
+	}
+
+	key := streamKey{peer: p.Source, id: seg.StreamID, opened: seg.Flags&streamFlagOpener == 0}
+	if s, ok := n.streams.get(key); ok {
+		s.handle(seg)
+		return
+	}
+
+	switch {
+	case seg.Flags&streamFlagRst != 0:
+	case seg.Flags&streamFlagSyn != 0 && !key.opened:
+		s, ok := n.streams.add(n, key)
+		if !ok {
+			log.Debug().Str("source", p.Source).Msg("Refusing stream")
+			n.sendStreamControl(key, streamFlagRst, 0)
+			return
+		}
+
+		s.mu.Lock()
+		s.send(streamSegment{Flags: streamFlagSyn})
+		s.mu.Unlock()
+		n.group.Go(s.retransmitLoop)
+	case seg.Flags&streamFlagFin != 0:
+		// the stream has finished, but our acknowledgement of its FIN was lost
+		n.sendStreamControl(key, streamFlagAck, seg.SeqNo+1)
+	default:
+		n.sendStreamControl(key, streamFlagRst, 0)
+	}
+}
+
+// sendStreamControl sends a segment without data for a stream which isn't open
+func (n *Interface) sendStreamControl(key streamKey, flags uint8, ackNo uint32) {
+	if key.opened {
+		flags |= streamFlagOpener
+	}
+	seg := streamSegment{StreamID: key.id, Flags: flags, AckNo: ackNo}
+
+	if err := n.forward(n.streamPacket(key.peer, seg)); err != nil {
+		log.Debug().Err(err).Str("dest", key.peer).Msg("Unable to send stream segment")
+	}
+}
+
+func (n *Interface) streamPacket(dest string, seg streamSegment) DataPacket {
+	return DataPacket{
+		Source:  n.ad.identity.NodeName,
+		Dest:    dest,
+		TTL:     defaultTTL,
+		Kind:    dataStream,
+		Payload: seg.marshal(),
+	}
+}
+
+// streamRetransmits is how many times a stream segment is retransmitted before the stream is reset.
+// Streams can't work without retransmission, so they ignore a negative MaxRetransmits.
+func (n *Interface) streamRetransmits() int {
+	if n.settings.MaxRetransmits < 0 {
+		return defaultMaxRetransmits
+	}
+
+	return n.settings.MaxRetransmits
+}
+
+// RemoteNode returns the name of the node at the other end of the stream
+func (s *Stream) RemoteNode() string {
+	return s.key.peer
+}
+
+// Read reads data written to the stream by the other side, blocking until some is available.
+// Returns io.EOF once the other side has closed the stream and all its data has been read.
+func (s *Stream) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.readBuf) == 0 && !s.finReceived && s.err == nil && !s.readClosed {
+		s.cond.Wait()
+	}
+
+	if s.readClosed {
+		return 0, ErrStreamClosed
+	}
+	if len(s.readBuf) == 0 {
+		if s.finReceived {
+			return 0, io.EOF
+		}
+		return 0, s.err
+	}
+
+	read := 0
+	for read < len(b) && len(s.readBuf) > 0 {
+		c := copy(b[read:], s.readBuf[0])
+		read += c
+		if c == len(s.readBuf[0]) {
+			s.readBuf = s.readBuf[1:]
+		} else {
+			s.readBuf[0] = s.readBuf[0][c:]
+		}
+	}
+
+	if s.advertised == 0 && s.isEstablished && !s.finished {
+		// the sender is waiting for the window to open
+		s.send(streamSegment{})
+	}
+
+	return read, nil
+}
+
+// Write queues the data to be sent, blocking while the send buffer is full.
+func (s *Stream) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	written := 0
+	for written < len(b) {
+		for s.err == nil && !s.finQueued && len(s.outgoing) >= streamWindow {
+			s.cond.Wait()
+		}
+
+		if s.err != nil {
+			return written, s.err
+		}
+		if s.finQueued {
+			return written, ErrStreamClosed
+		}
+
+		chunk := b[written:]
+		if len(chunk) > maxSegmentSize {
+			chunk = chunk[:maxSegmentSize]
+		}
+		s.queue(&outSegment{data: append([]byte{}, chunk...)})
+		written += len(chunk)
+	}
+
+	return written, nil
+}
+
+// Close closes the stream, blocking until the other side has acknowledged everything written to it.
+// The other side reads io.EOF after the last of the data.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.readClosed = true
+	s.cond.Broadcast()
+
+	if !s.finQueued && s.err == nil {
+		s.queue(&outSegment{fin: true})
+	}
+
+	for !s.finAcked && s.err == nil {
+		s.cond.Wait()
+	}
+	if !s.finAcked {
+		return s.err
+	}
+
+	s.maybeFinish()
+
+	return nil
+}
+
+// queue adds the segment to the end of the stream, and sends it if the window allows
+func (s *Stream) queue(o *outSegment) {
+	o.seqNo = s.sendNext
+	s.sendNext++
+	if o.fin {
+		s.finQueued = true
+	}
+
+	s.outgoing = append(s.outgoing, o)
+	s.trySend()
+}
+
+// trySend sends queued segments while they fit in the peer's window
+func (s *Stream) trySend() {
+	window := s.peerWindow
+	if window < 1 {
+		// a single segment probes a closed window, to learn when it opens
+		window = 1
+	}
+
+	for s.inFlight < len(s.outgoing) && s.inFlight < window {
+		s.transmit(s.outgoing[s.inFlight])
+		s.inFlight++
+	}
+}
+
+func (s *Stream) transmit(o *outSegment) {
+	o.sentAt = s.n.settings.Clock.Now()
+
+	seg := streamSegment{SeqNo: o.seqNo, Data: o.data}
+	if o.fin {
+		seg.Flags = streamFlagFin
+	}
+	s.send(seg)
+}
+
+// send fills in the stream fields of the segment, and sends it to the peer
+func (s *Stream) send(seg streamSegment) {
+	seg.StreamID = s.key.id
+	if s.key.opened {
+		seg.Flags |= streamFlagOpener
+	}
+	if s.isEstablished {
+		seg.Flags |= streamFlagAck
+		seg.AckNo = s.recvNext
+		s.advertised = s.window()
+		seg.Window = uint16(s.advertised)
+	}
+
+	if err := s.n.forward(s.n.streamPacket(s.key.peer, seg)); err != nil {
+		// retransmission will try again
+		log.Debug().Err(err).Str("dest", s.key.peer).Uint32("streamID", s.key.id).Msg("Unable to send stream segment")
+	}
+}
+
+// window is the number of segments from recvNext which can be buffered
+func (s *Stream) window() int {
+	return streamWindow - len(s.readBuf)
+}
+
+func (s *Stream) establish() {
+	s.isEstablished = true
+	close(s.established)
+}
+
+// handle processes a segment received from the peer
+func (s *Stream) handle(seg streamSegment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.finished {
+		return
+	}
+	defer s.cond.Broadcast()
+
+	if seg.Flags&streamFlagRst != 0 {
+		s.finish(ErrStreamReset)
+		return
+	}
+
+	if seg.Flags&streamFlagSyn != 0 {
+		if !s.key.opened {
+			// our answer was lost
+			s.send(streamSegment{Flags: streamFlagSyn})
+		} else if seg.Flags&streamFlagAck != 0 && !s.isEstablished {
+			s.peerWindow = int(seg.Window)
+			s.establish()
+		}
+		return
+	}
+
+	if !s.isEstablished {
+		return
+	}
+
+	if seg.Flags&streamFlagAck != 0 {
+		s.handleAck(seg)
+	}
+	if len(seg.Data) > 0 || seg.Flags&streamFlagFin != 0 {
+		s.handleData(seg)
+	}
+
+	s.maybeFinish()
+}
+
+// handleAck drops acknowledged segments from the send buffer, and sends more if the window allows
+func (s *Stream) handleAck(seg streamSegment) {
+	acked := int(int32(seg.AckNo - (s.sendNext - uint32(len(s.outgoing)))))
+	if acked < 0 || acked > s.inFlight {
+		// older than what has already been acknowledged, or acknowledging something never sent
+		return
+	}
+
+	for _, o := range s.outgoing[:acked] {
+		if o.fin {
+			s.finAcked = true
+		}
+	}
+	s.outgoing = s.outgoing[acked:]
+	s.inFlight -= acked
+	s.peerWindow = int(seg.Window)
+
+	s.trySend()
+}
+
+// handleData buffers a data or FIN segment, and acknowledges it
+func (s *Stream) handleData(seg streamSegment) {
+	offset := int32(seg.SeqNo - s.recvNext)
+	if offset >= 0 && int(offset) < s.window() && !s.finReceived {
+		if _, ok := s.outOfOrder[seg.SeqNo]; !ok {
+			s.outOfOrder[seg.SeqNo] = seg
+		}
+
+		for !s.finReceived {
+			next, ok := s.outOfOrder[s.recvNext]
+			if !ok {
+				break
+			}
+
+			delete(s.outOfOrder, s.recvNext)
+			s.recvNext++
+			if len(next.Data) > 0 && !s.readClosed {
+				s.readBuf = append(s.readBuf, next.Data)
+			}
+			if next.Flags&streamFlagFin != 0 {
+				s.finReceived = true
+			}
+		}
+	}
+
+	// duplicates and segments outside the window are acknowledged too, since the last acknowledgement may have been lost
+	s.send(streamSegment{})
+}
+
+// retransmitLoop resends unacknowledged segments until the stream is finished, or ctx is done
+func (s *Stream) retransmitLoop(ctx context.Context) error {
+	ticker := s.n.settings.Clock.NewTicker(s.n.settings.RetransmitTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return nil
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C():
+			s.retransmit(now)
+		}
+	}
+}
+
+func (s *Stream) retransmit(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, o := range s.outgoing[:s.inFlight] {
+		backoff := o.retransmits
+		if backoff > 16 {
+			backoff = 16
+		}
+		if now.Sub(o.sentAt) < s.n.settings.RetransmitTimeout<<uint(backoff) {
+			continue
+		}
+
+		if s.peerWindow == 0 {
+			// probing a closed window. The peer answers with another closed window if it's still alive,
+			// otherwise the next timeout counts as a retransmission.
+			s.peerWindow = 1
+		} else if o.retransmits >= s.n.streamRetransmits() {
+			s.send(streamSegment{Flags: streamFlagRst})
+			s.finish(ErrDeliveryFailed)
+			return
+		} else {
+			o.retransmits++
+		}
+
+		s.transmit(o)
+	}
+}
+
+// maybeFinish finishes the stream once both sides have closed it
+func (s *Stream) maybeFinish() {
+	if s.finAcked && s.finReceived {
+		s.finish(nil)
+	}
+}
+
+// finish removes the stream from the stream table. If err is set, the stream fails with it.
+// Must be called with the lock held.
+func (s *Stream) finish(err error) {
+	if s.finished {
+		return
+	}
+
+	s.finished = true
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	close(s.done)
+	s.n.streams.remove(s.key)
+	s.cond.Broadcast()
+}
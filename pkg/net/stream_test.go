@@ -0,0 +1,186 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func acceptOne(t *testing.T, n *Interface) *Stream {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	s, err := n.AcceptStream(ctx)
+	require.NoError(t, err)
+
+	return s
+}
+
+func openStreams(n *Interface) int {
+	n.streams.mu.Lock()
+	defer n.streams.mu.Unlock()
+
+	return len(n.streams.streams)
+}
+
+func TestInterface_OpenStream(t *testing.T) {
+	n1, n2, _, _ := newPipedInterfaces(t)
+
+	s1, err := n1.OpenStream("n2")
+	require.NoError(t, err)
+	s2 := acceptOne(t, n2)
+	assert.Equal(t, "n2", s1.RemoteNode())
+	assert.Equal(t, "n1", s2.RemoteNode())
+
+	_, err = s1.Write([]byte("ping"))
+	require.NoError(t, err)
+	b := make([]byte, 10)
+	read, err := s2.Read(b)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(b[:read]))
+
+	_, err = s2.Write([]byte("pong"))
+	require.NoError(t, err)
+	read, err = s1.Read(b)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(b[:read]))
+
+	closed := make(chan error)
+	go func() {
+		closed <- s2.Close()
+	}()
+	read, err = s1.Read(b)
+	assert.Equal(t, 0, read)
+	assert.Equal(t, io.EOF, err)
+	require.NoError(t, <-closed)
+	require.NoError(t, s1.Close())
+
+	assert.Equal(t, 0, openStreams(n1))
+	assert.Equal(t, 0, openStreams(n2))
+}
+
+func TestInterface_OpenStream_Lossy(t *testing.T) {
+	n1, n2, w1, w2 := newPipedInterfaces(t)
+
+	w1.drop = func(interface{}) bool {
+		return rand.Intn(10) == 0
+	}
+	w2.drop = w1.drop
+
+	s1, err := n1.OpenStream("n2")
+	require.NoError(t, err)
+	s2 := acceptOne(t, n2)
+
+	sent := make([]byte, 200*1000)
+	rand.Read(sent)
+
+	go func() {
+		_, err := io.Copy(s1, bytes.NewReader(sent))
+		assert.NoError(t, err)
+		assert.NoError(t, s1.Close())
+	}()
+
+	received, err := ioutil.ReadAll(s2)
+	require.NoError(t, err)
+	assert.Equal(t, sent, received)
+}
+
+func TestInterface_OpenStream_NoReliableRetransmits(t *testing.T) {
+	n1, n2, w1, _ := pipedInterfaces(t, func(s *InterfaceSettings) {
+		s.MaxRetransmits = -1
+	})
+	connect(n1, n2)
+	connect(n2, n1)
+
+	s1, err := n1.OpenStream("n2")
+	require.NoError(t, err)
+	s2 := acceptOne(t, n2)
+
+	// disabling SendReliable retransmission leaves streams able to recover a lost segment
+	dropped := false
+	w1.mu.Lock()
+	w1.drop = func(interface{}) bool {
+		if !dropped {
+			dropped = true
+			return true
+		}
+		return false
+	}
+	w1.mu.Unlock()
+
+	_, err = s1.Write([]byte("ping"))
+	require.NoError(t, err)
+	b := make([]byte, 10)
+	read, err := s2.Read(b)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(b[:read]))
+}
+
+func TestInterface_OpenStream_FlowControl(t *testing.T) {
+	n1, n2, _, _ := newPipedInterfaces(t)
+
+	s1, err := n1.OpenStream("n2")
+	require.NoError(t, err)
+	s2 := acceptOne(t, n2)
+
+	sent := bytes.Repeat([]byte("x"), maxSegmentSize*streamWindow*3)
+	written := make(chan struct{})
+	go func() {
+		_, err := s1.Write(sent)
+		assert.NoError(t, err)
+		close(written)
+	}()
+
+	// the writer fills the receive window and its own send buffer, then blocks
+	time.Sleep(time.Millisecond * 200)
+	select {
+	case <-written:
+		t.Fatal("write finished without the data being read")
+	default:
+	}
+	s2.mu.Lock()
+	assert.Equal(t, streamWindow, len(s2.readBuf))
+	s2.mu.Unlock()
+
+	received := make([]byte, len(sent))
+	_, err = io.ReadFull(s2, received)
+	require.NoError(t, err)
+	assert.Equal(t, sent, received)
+	<-written
+}
+
+func TestInterface_OpenStream_Unreachable(t *testing.T) {
+	n1, _, w1, _ := newPipedInterfaces(t)
+	w1.drop = func(interface{}) bool {
+		return true
+	}
+
+	_, err := n1.OpenStream("n2")
+	assert.Equal(t, ErrDeliveryFailed, err)
+	assert.Equal(t, 0, openStreams(n1))
+}
+
+func TestInterface_OpenStream_Reset(t *testing.T) {
+	n1, n2, _, _ := newPipedInterfaces(t)
+
+	s1, err := n1.OpenStream("n2")
+	require.NoError(t, err)
+	s2 := acceptOne(t, n2)
+
+	// the accepting side forgets the stream, so the next segment is answered with a reset
+	s2.mu.Lock()
+	s2.finish(ErrClosed)
+	s2.mu.Unlock()
+
+	_, err = s1.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = s1.Read(make([]byte, 10))
+	assert.Equal(t, ErrStreamReset, err)
+}
@@ -0,0 +1,87 @@
+package net
+
+import (
+	"context"
+	"sync"
+)
+
+// supervisor owns the goroutines of an interface, like errgroup.Group. They share a context, which is cancelled
+// when the supervisor is stopped, when its parent is cancelled, or when any of them returns an error.
+type supervisor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	stopping bool
+	// err is the first error returned by a goroutine
+	err error
+	// done is closed once the supervisor is stopping and every goroutine has returned
+	done chan struct{}
+}
+
+func newSupervisor(ctx context.Context) *supervisor {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &supervisor{
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+// Go runs f in a goroutine, unless the supervisor is stopping. f must return once its context is done.
+func (s *supervisor) Go(f func(ctx context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopping {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		if err := f(s.ctx); err != nil {
+			s.mu.Lock()
+			if s.err == nil {
+				s.err = err
+			}
+			s.mu.Unlock()
+			s.cancel()
+		}
+	}()
+}
+
+// Stop cancels the context of the goroutines, and waits for them to return, or for ctx to be done.
+// Returns the first error returned by a goroutine, or the error of ctx if it was done first.
+// Stop may be called again to keep waiting.
+func (s *supervisor) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.stopping {
+		s.stopping = true
+		go func() {
+			s.wg.Wait()
+			close(s.done)
+		}()
+	}
+	s.mu.Unlock()
+	s.cancel()
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Done returns a channel which is closed once the supervisor has been stopped, and every goroutine has returned
+func (s *supervisor) Done() <-chan struct{} {
+	return s.done
+}
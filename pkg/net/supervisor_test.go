@@ -0,0 +1,90 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisor_Stop(t *testing.T) {
+	s := newSupervisor(context.Background())
+	for i := 0; i < 3; i++ {
+		s.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+	}
+
+	assert.NoError(t, s.Stop(context.Background()))
+	_, open := <-s.Done()
+	assert.False(t, open)
+
+	// goroutines aren't started once stopping
+	ran := make(chan bool, 1)
+	s.Go(func(context.Context) error {
+		ran <- true
+		return nil
+	})
+	assert.NoError(t, s.Stop(context.Background()))
+	assert.Empty(t, ran)
+}
+
+func TestSupervisor_ErrorCancelsOthers(t *testing.T) {
+	s := newSupervisor(context.Background())
+	failed := errors.New("failed")
+
+	cancelled := make(chan bool, 1)
+	s.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		cancelled <- true
+		return nil
+	})
+	s.Go(func(context.Context) error {
+		return failed
+	})
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("goroutine wasn't cancelled")
+	}
+	assert.Equal(t, failed, s.Stop(context.Background()))
+}
+
+func TestSupervisor_ParentCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newSupervisor(ctx)
+
+	returned := make(chan bool, 1)
+	s.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		returned <- true
+		return nil
+	})
+
+	cancel()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("goroutine wasn't cancelled")
+	}
+}
+
+func TestSupervisor_StopTimesOut(t *testing.T) {
+	s := newSupervisor(context.Background())
+	release := make(chan bool)
+	s.Go(func(context.Context) error {
+		<-release
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Stop(ctx))
+
+	close(release)
+	assert.NoError(t, s.Stop(context.Background()))
+}
@@ -0,0 +1,246 @@
+package net
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
+)
+
+// ErrBadCost is returned when a link state packet carries an adjacency cost too large to route with
+var ErrBadCost = errors.New("link cost out of range")
+
+// WireType implements udp.Marshaler
+func (p AnnouncePacket) WireType() uint8 {
+	return packetAnnounce
+}
+
+// MarshalBinary encodes the packet as:
+// sequence number, epoch, node name, data address, identity key, count of connected nodes, connected node names,
+// ephemeral key, counter, signature.
+func (p AnnouncePacket) MarshalBinary() ([]byte, error) {
+	var e udp.Encoder
+	e.PutBytes(p.Signature)
+
+	return append(p.signedPayload(), e.Encoded()...), nil
+}
+
+// signedPayload encodes everything but the signature
+func (p AnnouncePacket) signedPayload() []byte {
+	var e udp.Encoder
+	e.PutUint16(p.SequenceNum)
+	e.PutUint64(p.Epoch)
+	e.PutString(p.NodeName)
+	e.PutString(p.Addr)
+	e.PutBytes(p.PublicKey)
+	e.PutUvarint(uint64(len(p.ConnectedNodes)))
+	for _, nodeName := range p.ConnectedNodes {
+		e.PutString(nodeName)
+	}
+	e.PutBytes(p.EphemeralKey)
+	e.PutUint64(p.Counter)
+
+	return e.Encoded()
+}
+
+func decodeAnnouncePacket(payload []byte) (interface{}, error) {
+	d := udp.NewDecoder(payload)
+
+	p := AnnouncePacket{}
+	p.SequenceNum = d.GetUint16()
+	p.Epoch = d.GetUint64()
+	p.NodeName = d.GetString()
+	p.Addr = d.GetString()
+	p.PublicKey = nilIfEmpty(d.GetBytes())
+	for i := d.GetUvarint(); i > 0 && d.Err() == nil; i-- {
+		p.ConnectedNodes = append(p.ConnectedNodes, d.GetString())
+	}
+	p.EphemeralKey = nilIfEmpty(d.GetBytes())
+	p.Counter = d.GetUint64()
+	p.Signature = nilIfEmpty(d.GetBytes())
+
+	return p, d.Err()
+}
+
+// WireType implements udp.Marshaler
+func (p LinkStatePacket) WireType() uint8 {
+	return packetLinkState
+}
+
+// MarshalBinary encodes the packet as:
+// sequence number, epoch, origin, count of adjacencies, then name and cost (uvarint) of each adjacency sorted by name,
+// identity key, signature.
+func (p LinkStatePacket) MarshalBinary() ([]byte, error) {
+	var e udp.Encoder
+	e.PutBytes(p.Signature)
+
+	return append(p.signedPayload(), e.Encoded()...), nil
+}
+
+// signedPayload encodes everything but the signature
+func (p LinkStatePacket) signedPayload() []byte {
+	names := make([]string, 0, len(p.Adjacencies))
+	for nodeName := range p.Adjacencies {
+		names = append(names, nodeName)
+	}
+	sort.Strings(names)
+
+	var e udp.Encoder
+	e.PutUint16(p.SequenceNum)
+	e.PutUint64(p.Epoch)
+	e.PutString(p.Origin)
+	e.PutUvarint(uint64(len(names)))
+	for _, nodeName := range names {
+		e.PutString(nodeName)
+		e.PutUvarint(uint64(p.Adjacencies[nodeName]))
+	}
+	e.PutBytes(p.PublicKey)
+
+	return e.Encoded()
+}
+
+func decodeLinkStatePacket(payload []byte) (interface{}, error) {
+	d := udp.NewDecoder(payload)
+
+	p := LinkStatePacket{Adjacencies: make(map[string]int)}
+	p.SequenceNum = d.GetUint16()
+	p.Epoch = d.GetUint64()
+	p.Origin = d.GetString()
+	for i := d.GetUvarint(); i > 0 && d.Err() == nil; i-- {
+		nodeName := d.GetString()
+		cost := d.GetUvarint()
+		if cost > math.MaxInt32 {
+			return nil, ErrBadCost
+		}
+		p.Adjacencies[nodeName] = int(cost)
+	}
+	p.PublicKey = nilIfEmpty(d.GetBytes())
+	p.Signature = nilIfEmpty(d.GetBytes())
+
+	return p, d.Err()
+}
+
+// nilIfEmpty returns nil for empty optional fields, so unset fields decode the way they were encoded
+func nilIfEmpty(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+
+	return b
+}
+
+// WireType implements udp.Marshaler
+func (p DataPacket) WireType() uint8 {
+	return packetData
+}
+
+// MarshalBinary encodes the packet as: source, destination, TTL, hop count, kind, payload.
+func (p DataPacket) MarshalBinary() ([]byte, error) {
+	var e udp.Encoder
+	e.PutString(p.Source)
+	e.PutString(p.Dest)
+	e.PutUint8(p.TTL)
+	e.PutUint8(p.Hops)
+	e.PutUint8(p.Kind)
+	e.PutBytes(p.Payload)
+
+	return e.Encoded(), nil
+}
+
+func decodeDataPacket(payload []byte) (interface{}, error) {
+	d := udp.NewDecoder(payload)
+
+	p := DataPacket{}
+	p.Source = d.GetString()
+	p.Dest = d.GetString()
+	p.TTL = d.GetUint8()
+	p.Hops = d.GetUint8()
+	p.Kind = d.GetUint8()
+	p.Payload = d.GetBytes()
+
+	return p, d.Err()
+}
+
+// WireType implements udp.Marshaler
+func (p ProbePacket) WireType() uint8 {
+	return packetProbe
+}
+
+// MarshalBinary encodes the packet as: source, target, sequence number, send time, reply flag.
+func (p ProbePacket) MarshalBinary() ([]byte, error) {
+	var e udp.Encoder
+	e.PutString(p.Source)
+	e.PutString(p.Target)
+	e.PutUint32(p.SeqNo)
+	e.PutUint64(uint64(p.SentAt))
+	e.PutBool(p.Reply)
+
+	return e.Encoded(), nil
+}
+
+func decodeProbePacket(payload []byte) (interface{}, error) {
+	d := udp.NewDecoder(payload)
+
+	p := ProbePacket{}
+	p.Source = d.GetString()
+	p.Target = d.GetString()
+	p.SeqNo = d.GetUint32()
+	p.SentAt = int64(d.GetUint64())
+	p.Reply = d.GetBool()
+
+	return p, d.Err()
+}
+
+// WireType implements udp.Marshaler
+func (p SealedPacket) WireType() uint8 {
+	return packetSealed
+}
+
+// MarshalBinary encodes the packet as: source, counter, ciphertext.
+func (p SealedPacket) MarshalBinary() ([]byte, error) {
+	var e udp.Encoder
+	e.PutString(p.Source)
+	e.PutUint64(p.Counter)
+	e.PutBytes(p.Ciphertext)
+
+	return e.Encoded(), nil
+}
+
+func decodeSealedPacket(payload []byte) (interface{}, error) {
+	d := udp.NewDecoder(payload)
+
+	p := SealedPacket{}
+	p.Source = d.GetString()
+	p.Counter = d.GetUint64()
+	p.Ciphertext = d.GetBytes()
+
+	return p, d.Err()
+}
+
+// marshal encodes the segment as: stream ID, flags, sequence number, acknowledgement number, window, data.
+func (s streamSegment) marshal() []byte {
+	var e udp.Encoder
+	e.PutUint32(s.StreamID)
+	e.PutUint8(s.Flags)
+	e.PutUint32(s.SeqNo)
+	e.PutUint32(s.AckNo)
+	e.PutUint16(s.Window)
+	e.PutBytes(s.Data)
+
+	return e.Encoded()
+}
+
+func decodeStreamSegment(payload []byte) (streamSegment, error) {
+	d := udp.NewDecoder(payload)
+
+	s := streamSegment{}
+	s.StreamID = d.GetUint32()
+	s.Flags = d.GetUint8()
+	s.SeqNo = d.GetUint32()
+	s.AckNo = d.GetUint32()
+	s.Window = d.GetUint16()
+	s.Data = d.GetBytes()
+
+	return s, d.Err()
+}
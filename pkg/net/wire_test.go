@@ -0,0 +1,95 @@
+package net
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Heanthor/rsec-net/internal/udp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func roundTrip(t *testing.T, p interface{}) interface{} {
+	frame, err := udp.Marshal(p)
+	require.NoError(t, err)
+
+	decoded, err := udp.Unmarshal(frame)
+	require.NoError(t, err)
+
+	return decoded
+}
+
+func TestWire_AnnouncePacket(t *testing.T) {
+	p := AnnouncePacket{
+		Packet:         Packet{12, 0},
+		Identity:       Identity{"n1", "n1:1146", nil},
+		ConnectedNodes: []string{"n2", "n3"},
+	}
+	assert.Equal(t, p, roundTrip(t, p))
+
+	empty := AnnouncePacket{Packet: Packet{1, 0}, Identity: Identity{"n1", "n1:1146", nil}}
+	assert.Equal(t, empty, roundTrip(t, empty))
+
+	p.PublicKey = []byte("public")
+	p.EphemeralKey = []byte("ephemeral")
+	p.Signature = []byte("signature")
+	assert.Equal(t, p, roundTrip(t, p))
+}
+
+func TestWire_LinkStatePacket(t *testing.T) {
+	p := LinkStatePacket{Packet{3, 0}, "n1", map[string]int{"n2": 1, "n3": 300}, nil, nil}
+	assert.Equal(t, p, roundTrip(t, p))
+
+	// encoding is independent of map order
+	b1, _ := p.MarshalBinary()
+	b2, _ := LinkStatePacket{Packet{3, 0}, "n1", map[string]int{"n3": 300, "n2": 1}, nil, nil}.MarshalBinary()
+	assert.Equal(t, b1, b2)
+
+	// costs that would overflow route arithmetic are rejected
+	huge := LinkStatePacket{Packet{3, 0}, "n1", map[string]int{"n2": math.MaxInt32 + 1}, nil, nil}
+	b, err := huge.MarshalBinary()
+	require.NoError(t, err)
+	_, err = decodeLinkStatePacket(b)
+	assert.Equal(t, ErrBadCost, err)
+}
+
+func TestWire_DataPacket(t *testing.T) {
+	p := DataPacket{"n1", "n2", 15, 1, dataReliable, []byte("hello")}
+	assert.Equal(t, p, roundTrip(t, p))
+}
+
+func TestWire_ProbePacket(t *testing.T) {
+	p := ProbePacket{"n1", "n2", 7, 1577836800000000000, true}
+	assert.Equal(t, p, roundTrip(t, p))
+}
+
+func TestWire_SealedPacket(t *testing.T) {
+	p := SealedPacket{"n1", 1 << 40, []byte("ciphertext")}
+	assert.Equal(t, p, roundTrip(t, p))
+}
+
+func TestWire_Truncated(t *testing.T) {
+	b, err := DataPacket{"n1", "n2", 15, 1, dataReliable, []byte("hello")}.MarshalBinary()
+	require.NoError(t, err)
+
+	_, err = decodeDataPacket(b[:len(b)-2])
+	assert.Equal(t, udp.ErrTruncated, err)
+}
+
+func TestWire_StreamSegment(t *testing.T) {
+	seg := streamSegment{
+		StreamID: 7,
+		Flags:    streamFlagAck | streamFlagOpener,
+		SeqNo:    3,
+		AckNo:    9,
+		Window:   streamWindow,
+		Data:     []byte("hello"),
+	}
+
+	decoded, err := decodeStreamSegment(seg.marshal())
+	require.NoError(t, err)
+	assert.Equal(t, seg, decoded)
+
+	_, err = decodeStreamSegment(seg.marshal()[:5])
+	assert.Equal(t, udp.ErrTruncated, err)
+}